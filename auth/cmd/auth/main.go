@@ -1,53 +1,196 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	"github.com/Flaviogonzalez/e-commerce/contracts/logger"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/config"
 	"github.com/flaviogonzalez/e-commerce/auth/internal/server"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/throttle"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	db, err := connectToDB()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Initialize logger
+	appLogger, err := logger.New(logger.Config{
+		Service:      "auth",
+		KafkaBrokers: cfg.KafkaBrokers,
+		Topic:        "logs",
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to initialize logger: %v", err)
+	} else {
+		defer appLogger.Close()
+	}
+
+	pool, err := connectToDB(ctx, cfg)
 	if err != nil {
 		log.Fatal("Cannot connect to database:", err)
 	}
-	defer db.Close()
-	server := server.NewServer(db)
+	defer pool.Close()
+
+	server := server.NewServer(pool, appLogger)
+	if cfg.LoginChallengeToken != "" {
+		server.Verifier = throttle.FakeVerifier{Token: cfg.LoginChallengeToken}
+	}
+	server.Proxies, err = clientip.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	HTTPServer := &http.Server{
-		Addr:    ":8080",
-		Handler: server.Routes(),
+		Addr:              ":" + cfg.Port,
+		Handler:           server.Routes(),
+		ReadTimeout:       cfg.ReadTimeout.Duration,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+		WriteTimeout:      cfg.WriteTimeout.Duration,
+		IdleTimeout:       cfg.IdleTimeout.Duration,
+	}
+
+	// The gRPC API serves the same operations next to the HTTP routes
+	grpcServer := server.GRPC()
+	grpcListener, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+	if err != nil {
+		log.Fatal("Cannot listen for gRPC:", err)
+	}
+
+	// Operator endpoints listen apart from the public routes; an empty
+	// ADMIN_ADDR turns them off
+	var adminServer *http.Server
+	if cfg.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr:              cfg.AdminAddr,
+			Handler:           server.AdminRoutes(),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+		}
+	}
+
+	serverErr := make(chan error, 3)
+	go func() {
+		if appLogger != nil {
+			appLogger.Info("Auth service starting", logger.WithField("port", cfg.Port), logger.WithField("grpc_port", cfg.GRPCPort))
+		}
+		log.Printf("Auth service started on port %s", cfg.Port)
+		serverErr <- HTTPServer.ListenAndServe()
+	}()
+	go func() {
+		log.Printf("Auth gRPC API started on port %s", cfg.GRPCPort)
+		serverErr <- grpcServer.Serve(grpcListener)
+	}()
+	if adminServer != nil {
+		go func() {
+			log.Printf("Auth admin endpoints started on %s", cfg.AdminAddr)
+			serverErr <- adminServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			if appLogger != nil {
+				appLogger.Error("Server failed", logger.WithError(err))
+			}
+			log.Printf("Server failed: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	// Fail readiness first and keep serving while load balancers notice,
+	// then stop accepting connections
+	log.Printf("Shutting down, reporting not ready for %s...", cfg.ShutdownDelay)
+	server.SetShuttingDown()
+	time.Sleep(cfg.ShutdownDelay.Duration)
+
+	log.Println("Draining in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	if err := HTTPServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown failed: %v", err)
+	}
+	stopGRPC(shutdownCtx, grpcServer)
+	if adminServer != nil {
+		adminServer.Shutdown(shutdownCtx)
+	}
+	log.Println("Auth service stopped")
+}
+
+// stopGRPC lets in-flight calls finish, cutting them off when ctx ends.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("Graceful gRPC shutdown timed out, closing open calls")
+		srv.Stop()
 	}
-	log.Println("Auth service started on port 8080")
-	log.Fatal(HTTPServer.ListenAndServe())
 }
 
-func connectToDB() (*sql.DB, error) {
-	db, err := sql.Open("pgx", os.Getenv("DATABASE_URL"))
+func connectToDB(ctx context.Context, cfg config.Config) (*pgxpool.Pool, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse database url: %w", err)
+	}
+	poolCfg.MaxConns = cfg.DBMaxConns
+	poolCfg.MinConns = cfg.DBMinConns
+	poolCfg.MaxConnLifetime = cfg.DBMaxConnLifetime.Duration
+	poolCfg.MaxConnIdleTime = cfg.DBMaxConnIdleTime.Duration
+	poolCfg.HealthCheckPeriod = cfg.DBHealthCheckPeriod.Duration
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("create pool: %w", err)
 	}
 
-	var count int16
-	for {
-		err = db.Ping()
+	backoff := cfg.DBRetryInterval.Duration
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = pool.Ping(pingCtx)
+		cancel()
 		if err == nil {
-			break
+			return pool, nil
 		}
 
-		count++
-		if count > 10 {
-			return nil, fmt.Errorf("unable to connect to database, exceeded maximum retries")
+		if attempt >= cfg.DBMaxRetries {
+			pool.Close()
+			return nil, fmt.Errorf("unable to connect to database after %d attempts: %w", attempt, err)
 		}
 
-		log.Println("Waiting for database to be ready...")
-		time.Sleep(2 * time.Second)
-	}
+		log.Printf("Waiting for database to be ready (attempt %d), retrying in %s...", attempt, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			pool.Close()
+			return nil, ctx.Err()
+		}
 
-	return db, nil
+		backoff *= 2
+		if backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
 }
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every tunable of the auth service. Values are read from the
+// optional JSON file named by AUTH_CONFIG_FILE and then overridden by
+// environment variables.
+type Config struct {
+	Port                string   `json:"port"`
+	GRPCPort            string   `json:"grpc_port"`
+	DatabaseURL         string   `json:"database_url"`
+	KafkaBrokers        []string `json:"kafka_brokers"`
+	LoginChallengeToken string   `json:"login_challenge_token"`
+
+	// AdminAddr is where operator-only endpoints such as /debug/pool are
+	// served, apart from the public routes; loopback by default
+	AdminAddr string `json:"admin_addr"`
+
+	// TrustedProxies lists the CIDRs or addresses of the services relaying
+	// the client address (the listener), comma separated
+	TrustedProxies string `json:"trusted_proxies"`
+
+	DBMaxRetries    int      `json:"db_max_retries"`
+	DBRetryInterval Duration `json:"db_retry_interval"`
+
+	DBMaxConns          int32    `json:"db_max_conns"`
+	DBMinConns          int32    `json:"db_min_conns"`
+	DBMaxConnLifetime   Duration `json:"db_max_conn_lifetime"`
+	DBMaxConnIdleTime   Duration `json:"db_max_conn_idle_time"`
+	DBHealthCheckPeriod Duration `json:"db_health_check_period"`
+
+	ReadTimeout       Duration `json:"read_timeout"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	ShutdownTimeout   Duration `json:"shutdown_timeout"`
+
+	// ShutdownDelay is how long /readyz reports not-ready before the server
+	// stops accepting connections, so load balancers see it first
+	ShutdownDelay Duration `json:"shutdown_delay"`
+}
+
+// Duration wraps time.Duration so it can be written as "5s" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func defaults() Config {
+	return Config{
+		Port:                "8080",
+		GRPCPort:            "9090",
+		AdminAddr:           "127.0.0.1:8081",
+		KafkaBrokers:        []string{"kafka:9092"},
+		DBMaxRetries:        10,
+		DBRetryInterval:     Duration{500 * time.Millisecond},
+		DBMaxConns:          20,
+		DBMinConns:          2,
+		DBMaxConnLifetime:   Duration{time.Hour},
+		DBMaxConnIdleTime:   Duration{30 * time.Minute},
+		DBHealthCheckPeriod: Duration{time.Minute},
+		ReadTimeout:         Duration{10 * time.Second},
+		ReadHeaderTimeout:   Duration{5 * time.Second},
+		WriteTimeout:        Duration{15 * time.Second},
+		IdleTimeout:         Duration{60 * time.Second},
+		ShutdownTimeout:     Duration{20 * time.Second},
+		ShutdownDelay:       Duration{5 * time.Second},
+	}
+}
+
+// Load builds the configuration from defaults, the optional file and the
+// environment, in that order of precedence (lowest first).
+func Load() (Config, error) {
+	cfg := defaults()
+
+	if path := os.Getenv("AUTH_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parse config file: %w", err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.DatabaseURL == "" {
+		return cfg, fmt.Errorf("DATABASE_URL is required")
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	setString(&c.Port, "PORT")
+	setString(&c.GRPCPort, "GRPC_PORT")
+	setString(&c.AdminAddr, "ADMIN_ADDR")
+	setString(&c.DatabaseURL, "DATABASE_URL")
+	setString(&c.LoginChallengeToken, "LOGIN_CHALLENGE_TOKEN")
+	setString(&c.TrustedProxies, "TRUSTED_PROXIES")
+
+	if v := os.Getenv("KAFKA_BROKERS"); v != "" {
+		c.KafkaBrokers = strings.Split(v, ",")
+	}
+
+	if v := os.Getenv("DB_MAX_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("DB_MAX_RETRIES: %w", err)
+		}
+		c.DBMaxRetries = n
+	}
+
+	conns := map[string]*int32{
+		"DB_MAX_CONNS": &c.DBMaxConns,
+		"DB_MIN_CONNS": &c.DBMinConns,
+	}
+	for key, dst := range conns {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		*dst = int32(n)
+	}
+
+	durations := map[string]*Duration{
+		"DB_RETRY_INTERVAL":      &c.DBRetryInterval,
+		"DB_MAX_CONN_LIFETIME":   &c.DBMaxConnLifetime,
+		"DB_MAX_CONN_IDLE_TIME":  &c.DBMaxConnIdleTime,
+		"DB_HEALTH_CHECK_PERIOD": &c.DBHealthCheckPeriod,
+		"READ_TIMEOUT":           &c.ReadTimeout,
+		"READ_HEADER_TIMEOUT":    &c.ReadHeaderTimeout,
+		"WRITE_TIMEOUT":          &c.WriteTimeout,
+		"IDLE_TIMEOUT":           &c.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":       &c.ShutdownTimeout,
+		"SHUTDOWN_DELAY":         &c.ShutdownDelay,
+	}
+	for key, d := range durations {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		d.Duration = parsed
+	}
+
+	return nil
+}
+
+func setString(dst *string, key string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
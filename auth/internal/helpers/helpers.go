@@ -2,36 +2,41 @@ package helpers
 
 import (
 	"encoding/json"
+	"errors"
 	"maps"
 	"net/http"
 
-	"github.com/flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
 )
 
 func ReadJSON(w http.ResponseWriter, r *http.Request, data any) error {
 	return json.NewDecoder(r.Body).Decode(data)
 }
 
-func ErrorJSON(w http.ResponseWriter, errorCode int, message string) {
-	if errorCode == 0 {
-		errorCode = http.StatusInternalServerError
+// ErrorJSON writes err as a structured contracts error. Errors that are not
+// a *contracts.Error are reported as a generic 500 so internal details such
+// as SQL messages never reach the client; callers log those errors.
+func ErrorJSON(w http.ResponseWriter, err error) {
+	var apiErr *contracts.Error
+	if !errors.As(err, &apiErr) {
+		apiErr = contracts.ErrInternal()
 	}
 
-	payload := contracts.Payload{
-		Error:   true,
-		Message: message,
+	status := apiErr.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	data, err := json.Marshal(payload)
+	data, err := json.Marshal(apiErr.ToPayload())
 	if err != nil {
-
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": true, "message": "Internal server errorr"}`))
+		w.Write([]byte(`{"error": true, "code": "internal_error", "message": "Internal server error"}`))
 		return
 	}
 
-	w.WriteHeader(errorCode)
+	w.WriteHeader(status)
 	w.Write(data)
 }
 
@@ -48,3 +53,10 @@ func WriteJSON(w http.ResponseWriter, status int, data any, headers http.Header)
 	w.Write(js)
 	return nil
 }
+
+// ClientIP returns the client address: the one the broker/listener chain
+// relayed when r comes from one of proxies, else the socket address. It is
+// "" for a trusted proxy that relayed none.
+func ClientIP(r *http.Request, proxies clientip.Proxies) string {
+	return proxies.Resolve(r.RemoteAddr, r.Header.Get(clientip.Header))
+}
@@ -26,7 +26,7 @@ WHERE id = $1 LIMIT 1;
 
 -- name: GetUserByEmail :one
 SELECT * FROM users
-WHERE email = $1 LIMIT 1;
+WHERE LOWER(email) = LOWER(sqlc.arg('email')) AND deleted_at IS NULL LIMIT 1;
 
 -- name: ListUsers :many
 SELECT 
@@ -1,17 +1,135 @@
 package repository
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/flaviogonzalez/e-commerce/auth/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	maxTxAttempts  = 5
+	txRetryBackoff = 20 * time.Millisecond
+)
+
+// Postgres error codes inspected by the repository. The first two mean a
+// transaction can safely be re-run.
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+	codeUniqueViolation      = "23505"
 )
 
 type Repository struct {
 	*models.Queries
+	pool pool
+}
+
+// pool is the part of *pgxpool.Pool the repository uses, so tests can fake
+// transactions.
+type pool interface {
+	models.DBTX
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+	Ping(ctx context.Context) error
+	Stat() *pgxpool.Stat
+}
+
+// PoolStats is a point-in-time snapshot of the connection pool.
+type PoolStats struct {
+	TotalConns           int32         `json:"total_conns"`
+	IdleConns            int32         `json:"idle_conns"`
+	AcquiredConns        int32         `json:"acquired_conns"`
+	ConstructingConns    int32         `json:"constructing_conns"`
+	MaxConns             int32         `json:"max_conns"`
+	AcquireCount         int64         `json:"acquire_count"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration_ns"`
 }
 
-func NewRepository(db *sql.DB) *Repository {
+func NewRepository(pool *pgxpool.Pool) *Repository {
 	return &Repository{
-		Queries: models.New(db),
+		Queries: models.New(pool),
+		pool:    pool,
+	}
+}
+
+// Ping verifies the database is reachable.
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.pool.Ping(ctx)
+}
+
+// Stats returns the current connection pool statistics.
+func (r *Repository) Stats() PoolStats {
+	s := r.pool.Stat()
+	return PoolStats{
+		TotalConns:           s.TotalConns(),
+		IdleConns:            s.IdleConns(),
+		AcquiredConns:        s.AcquiredConns(),
+		ConstructingConns:    s.ConstructingConns(),
+		MaxConns:             s.MaxConns(),
+		AcquireCount:         s.AcquireCount(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+		AcquireDuration:      s.AcquireDuration(),
+	}
+}
+
+// WithTx runs fn inside a serializable transaction. The transaction is
+// committed when fn returns nil and rolled back otherwise. Serialization
+// failures and deadlocks are retried with backoff, so fn must be safe to
+// run more than once.
+func (r *Repository) WithTx(ctx context.Context, fn func(*models.Queries) error) error {
+	var err error
+	backoff := txRetryBackoff
+
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = r.runTx(ctx, fn)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	return fmt.Errorf("transaction failed after %d attempts: %w", maxTxAttempts, err)
+}
+
+func (r *Repository) runTx(ctx context.Context, fn func(*models.Queries) error) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
 	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(r.Queries.WithTx(tx)); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == codeSerializationFailure || pgErr.Code == codeDeadlockDetected
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint,
+// such as registering an email that already exists.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == codeUniqueViolation
 }
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/flaviogonzalez/e-commerce/auth/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fakePool hands out fakeTxs, failing to begin with beginErr and commits
+// with the errors in commitErrs, in order.
+type fakePool struct {
+	beginErr   error
+	commitErrs []error
+	txs        []*fakeTx
+}
+
+func (p *fakePool) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	if p.beginErr != nil {
+		return nil, p.beginErr
+	}
+	if opts.IsoLevel != pgx.Serializable {
+		return nil, fmt.Errorf("isolation level %q, want serializable", opts.IsoLevel)
+	}
+
+	tx := &fakeTx{}
+	if len(p.commitErrs) > 0 {
+		tx.commitErr, p.commitErrs = p.commitErrs[0], p.commitErrs[1:]
+	}
+	p.txs = append(p.txs, tx)
+	return tx, nil
+}
+
+func (p *fakePool) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("query outside a transaction")
+}
+
+func (p *fakePool) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return nil, errors.New("query outside a transaction")
+}
+
+func (p *fakePool) QueryRow(context.Context, string, ...any) pgx.Row {
+	return nil
+}
+
+func (p *fakePool) Ping(context.Context) error { return nil }
+
+func (p *fakePool) Stat() *pgxpool.Stat { return nil }
+
+// fakeTx records whether it was committed or rolled back. Rollback after
+// Commit is a no-op, as it is for pgx.
+type fakeTx struct {
+	pgx.Tx
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTx) Commit(context.Context) error {
+	if tx.commitErr != nil {
+		tx.rolledBack = true
+		return tx.commitErr
+	}
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(context.Context) error {
+	if !tx.committed {
+		tx.rolledBack = true
+	}
+	return nil
+}
+
+func newRepository(p *fakePool) *Repository {
+	return &Repository{Queries: models.New(p), pool: p}
+}
+
+func TestWithTxCommits(t *testing.T) {
+	p := &fakePool{}
+	calls := 0
+	err := newRepository(p).WithTx(context.Background(), func(q *models.Queries) error {
+		calls++
+		return nil
+	})
+
+	if err != nil || calls != 1 {
+		t.Fatalf("err %v after %d calls", err, calls)
+	}
+	if len(p.txs) != 1 || !p.txs[0].committed || p.txs[0].rolledBack {
+		t.Errorf("transaction %+v, want it committed", p.txs[0])
+	}
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	p := &fakePool{}
+	want := errors.New("email taken")
+	calls := 0
+	err := newRepository(p).WithTx(context.Background(), func(q *models.Queries) error {
+		calls++
+		return want
+	})
+
+	if !errors.Is(err, want) || calls != 1 {
+		t.Fatalf("err %v after %d calls, want %v once", err, calls, want)
+	}
+	if len(p.txs) != 1 || p.txs[0].committed || !p.txs[0].rolledBack {
+		t.Errorf("transaction %+v, want it rolled back", p.txs[0])
+	}
+}
+
+func TestWithTxRollsBackOnPanic(t *testing.T) {
+	p := &fakePool{}
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want the panic to propagate", r)
+		}
+		if len(p.txs) != 1 || p.txs[0].committed || !p.txs[0].rolledBack {
+			t.Errorf("transaction %+v, want it rolled back", p.txs[0])
+		}
+	}()
+
+	newRepository(p).WithTx(context.Background(), func(q *models.Queries) error {
+		panic("boom")
+	})
+}
+
+func TestWithTxRetriesSerializationFailures(t *testing.T) {
+	for _, code := range []string{codeSerializationFailure, codeDeadlockDetected} {
+		t.Run(code, func(t *testing.T) {
+			// The first attempt fails in fn, the second on commit
+			p := &fakePool{commitErrs: []error{nil, &pgconn.PgError{Code: code}}}
+			calls := 0
+			err := newRepository(p).WithTx(context.Background(), func(q *models.Queries) error {
+				calls++
+				if calls == 1 {
+					return fmt.Errorf("insert: %w", &pgconn.PgError{Code: code})
+				}
+				return nil
+			})
+
+			if err != nil || calls != 3 {
+				t.Fatalf("err %v after %d calls, want success on the third", err, calls)
+			}
+			if !p.txs[2].committed {
+				t.Error("last attempt not committed")
+			}
+		})
+	}
+}
+
+func TestWithTxGivesUp(t *testing.T) {
+	p := &fakePool{}
+	calls := 0
+	err := newRepository(p).WithTx(context.Background(), func(q *models.Queries) error {
+		calls++
+		return &pgconn.PgError{Code: codeSerializationFailure}
+	})
+
+	if calls != maxTxAttempts || !isRetryable(err) {
+		t.Errorf("err %v after %d calls, want a serialization failure after %d", err, calls, maxTxAttempts)
+	}
+}
+
+func TestWithTxStopsWithContext(t *testing.T) {
+	p := &fakePool{}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := newRepository(p).WithTx(ctx, func(q *models.Queries) error {
+		calls++
+		cancel()
+		return &pgconn.PgError{Code: codeDeadlockDetected}
+	})
+
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("err %v after %d calls, want context.Canceled after 1", err, calls)
+	}
+}
+
+func TestWithTxBeginFails(t *testing.T) {
+	want := errors.New("pool closed")
+	err := newRepository(&fakePool{beginErr: want}).WithTx(context.Background(), func(q *models.Queries) error {
+		t.Error("fn ran without a transaction")
+		return nil
+	})
+	if !errors.Is(err, want) {
+		t.Errorf("err %v, want %v", err, want)
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: codeUniqueViolation}, true},
+		{fmt.Errorf("create user: %w", &pgconn.PgError{Code: codeUniqueViolation}), true},
+		{&pgconn.PgError{Code: "23502"}, false}, // not null violation
+		{&pgconn.PgError{Code: codeSerializationFailure}, false},
+		{errors.New("23505"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsUniqueViolation(tt.err); got != tt.want {
+			t.Errorf("IsUniqueViolation(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
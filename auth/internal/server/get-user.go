@@ -1,35 +1,43 @@
 package server
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"net/http"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts"
 	"github.com/flaviogonzalez/e-commerce/auth/internal/helpers"
+	"github.com/flaviogonzalez/e-commerce/auth/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 func (s *Server) GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	if idStr == "" {
-		helpers.ErrorJSON(w, http.StatusBadRequest, "Missing user ID")
+	user, err := s.getUser(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.errorJSON(w, r, err)
 		return
 	}
 
+	helpers.WriteJSON(w, http.StatusOK, user, nil)
+}
+
+func (s *Server) getUser(ctx context.Context, idStr string) (models.GetUserByIDRow, error) {
+	if idStr == "" {
+		return models.GetUserByIDRow{}, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Missing user ID").WithField("id", "id is required")
+	}
+
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		helpers.ErrorJSON(w, http.StatusBadRequest, "Invalid user ID format")
-		return
+		return models.GetUserByIDRow{}, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Invalid user ID format").WithField("id", "id must be a UUID")
 	}
 
-	user, err := s.Repository.GetUserByID(r.Context(), id)
+	user, err := s.Repository.GetUserByID(ctx, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			helpers.ErrorJSON(w, http.StatusNotFound, "User not found")
-			return
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user, contracts.NewError(http.StatusNotFound, contracts.ErrCodeNotFound, "User not found")
 		}
-		helpers.ErrorJSON(w, http.StatusInternalServerError, "Error fetching user: "+err.Error())
-		return
+		return user, err
 	}
-
-	helpers.WriteJSON(w, http.StatusOK, user, nil)
+	return user, nil
 }
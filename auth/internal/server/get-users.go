@@ -1,25 +1,69 @@
 package server
 
 import (
+	"context"
 	"net/http"
+	"strconv"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts"
 	"github.com/flaviogonzalez/e-commerce/auth/internal/helpers"
 	"github.com/flaviogonzalez/e-commerce/auth/models"
 )
 
+// maxUsersPage caps how many users one list request returns.
+const maxUsersPage = 100
+
 func (s *Server) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
-	users, err := s.Repository.ListUsers(r.Context(), models.ListUsersParams{
-		Limit:  100,
-		Offset: 0,
-	})
+	limit, err := pageParam(r, "limit")
+	if err != nil {
+		s.errorJSON(w, r, err)
+		return
+	}
+	offset, err := pageParam(r, "offset")
 	if err != nil {
-		helpers.ErrorJSON(w, http.StatusInternalServerError, "Error fetching users: "+err.Error())
+		s.errorJSON(w, r, err)
 		return
 	}
 
-	if users == nil {
-		users = []models.ListUsersRow{}
+	users, err := s.listUsers(r.Context(), limit, offset)
+	if err != nil {
+		s.errorJSON(w, r, err)
+		return
 	}
 
 	helpers.WriteJSON(w, http.StatusOK, users, nil)
 }
+
+// pageParam reads a non-negative paging parameter; absent means 0.
+func pageParam(r *http.Request, name string) (int32, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil || n < 0 {
+		return 0, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Invalid paging parameter").
+			WithField(name, name+" must be a non-negative integer")
+	}
+	return int32(n), nil
+}
+
+// listUsers returns a page of users, newest first. A limit of 0 or above
+// maxUsersPage returns maxUsersPage users.
+func (s *Server) listUsers(ctx context.Context, limit, offset int32) ([]models.ListUsersRow, error) {
+	if limit <= 0 || limit > maxUsersPage {
+		limit = maxUsersPage
+	}
+	users, err := s.Repository.ListUsers(ctx, models.ListUsersParams{
+		Limit:  limit,
+		Offset: max(offset, 0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if users == nil {
+		users = []models.ListUsersRow{}
+	}
+	return users, nil
+}
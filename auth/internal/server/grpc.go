@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	"github.com/Flaviogonzalez/e-commerce/contracts/grpcutil"
+	"github.com/Flaviogonzalez/e-commerce/contracts/logger"
+	authv1 "github.com/Flaviogonzalez/e-commerce/contracts/proto/auth/v1"
+	"github.com/flaviogonzalez/e-commerce/auth/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPC builds the gRPC server for the same operations as Routes. Calls
+// continue the caller's trace and are logged like HTTP requests.
+func (s *Server) GRPC() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		grpcutil.UnaryServerInterceptor(),
+		s.logCalls,
+	))
+	authv1.RegisterAuthServiceServer(srv, &authService{s: s})
+	return srv
+}
+
+// logCalls logs every call with its outcome and caller. It runs inside
+// the grpcutil interceptor, so the context carries the call's span.
+func (s *Server) logCalls(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if s.Logger == nil {
+		return resp, err
+	}
+
+	code := grpcstatus.Code(grpcutil.Status(err))
+	caller, _ := grpcutil.Caller(ctx)
+	opts := []logger.Option{
+		logger.WithContext(ctx),
+		logger.WithDuration(time.Since(start)),
+		logger.WithField("caller", caller),
+		logger.WithField("code", code.String()),
+	}
+	if err != nil {
+		s.Logger.Warn("gRPC "+info.FullMethod, append(opts, logger.WithError(err))...)
+	} else {
+		s.Logger.Info("gRPC "+info.FullMethod, opts...)
+	}
+	return resp, err
+}
+
+// authService implements authv1.AuthServiceServer on top of the handlers'
+// logic. Errors are *contracts.Error values, encoded by the interceptor.
+type authService struct {
+	authv1.UnimplementedAuthServiceServer
+	s *Server
+}
+
+func (a *authService) Register(ctx context.Context, req *authv1.RegisterRequest) (*authv1.RegisterResponse, error) {
+	err := a.s.register(ctx, &contracts.AuthRegisterRequest{
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: req.Password,
+		Policy:   req.Policy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &authv1.RegisterResponse{Message: "User registered successfully"}, nil
+}
+
+func (a *authService) Login(ctx context.Context, req *authv1.LoginRequest) (*authv1.LoginResponse, error) {
+	userID, retryAfter, err := a.s.login(ctx, &contracts.AuthLoginRequest{
+		Email:     req.Email,
+		Password:  req.Password,
+		Challenge: req.Challenge,
+	}, a.s.callerIP(ctx))
+	if err != nil {
+		if retryAfter > 0 {
+			return nil, grpcutil.StatusRetryAfter(err, retryAfter)
+		}
+		return nil, err
+	}
+	return &authv1.LoginResponse{Message: "Logged in successfully", UserId: userID}, nil
+}
+
+func (a *authService) GetUser(ctx context.Context, req *authv1.GetUserRequest) (*authv1.User, error) {
+	u, err := a.s.getUser(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return protoUser(u), nil
+}
+
+func (a *authService) ListUsers(ctx context.Context, req *authv1.ListUsersRequest) (*authv1.ListUsersResponse, error) {
+	users, err := a.s.listUsers(ctx, req.GetLimit(), req.GetOffset())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &authv1.ListUsersResponse{Users: make([]*authv1.User, len(users))}
+	for i, u := range users {
+		resp.Users[i] = protoUser(models.GetUserByIDRow(u))
+	}
+	return resp, nil
+}
+
+// protoUser converts a user row; the list query selects the same columns,
+// so its rows convert to GetUserByIDRow.
+func protoUser(u models.GetUserByIDRow) *authv1.User {
+	return &authv1.User{
+		Id:            u.ID.String(),
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Phone:         u.Phone,
+		PhoneVerified: u.PhoneVerified,
+		AvatarUrl:     u.AvatarUrl,
+		Status:        u.Status,
+		Role:          u.Role,
+		LastLoginAt:   timestamp(u.LastLoginAt),
+		CreatedAt:     timestamppb.New(u.CreatedAt),
+		UpdatedAt:     timestamppb.New(u.UpdatedAt),
+	}
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// callerIP is helpers.ClientIP for gRPC: the address relayed in the
+// metadata when the peer is a trusted proxy, else the peer's.
+func (s *Server) callerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	var relayed string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(clientip.Key); len(v) > 0 {
+		relayed = v[0]
+	}
+	return s.Proxies.Resolve(p.Addr.String(), relayed)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/helpers"
+)
+
+// HealthzHandler reports liveness: the process is up and serving HTTP.
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"}, nil)
+}
+
+// ReadyzHandler reports readiness: the database answers a ping and the
+// server is not shutting down.
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown.Load() {
+		s.errorJSON(w, r, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "Shutting down"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := s.Repository.Ping(ctx); err != nil {
+		s.errorJSON(w, r, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "Database unavailable"))
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, map[string]string{"status": "ready"}, nil)
+}
+
+// PoolStatsHandler exposes the database connection pool statistics.
+func (s *Server) PoolStatsHandler(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, http.StatusOK, s.Repository.Stats(), nil)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/helpers"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/validation"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when the account does not exist so that
+// unknown emails take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+func errInvalidCredentials() *contracts.Error {
+	return contracts.NewError(http.StatusUnauthorized, contracts.ErrCodeInvalidCredentials, "Invalid credentials")
+}
+
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var loginPayload contracts.AuthLoginRequest
+
+	err := helpers.ReadJSON(w, r, &loginPayload)
+	if err != nil {
+		s.errorJSON(w, r, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Invalid request payload"))
+		return
+	}
+
+	userID, retryAfter, err := s.login(r.Context(), &loginPayload, helpers.ClientIP(r, s.Proxies))
+	if err != nil {
+		if retryAfter > 0 {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+		s.errorJSON(w, r, err)
+		return
+	}
+
+	var response contracts.AuthLoginResponse
+	response.Error = false
+	response.Message = "Logged in successfully"
+	response.UserID = userID
+
+	helpers.WriteJSON(w, http.StatusOK, response, nil)
+}
+
+// login checks credentials for a client at ip and returns the user's ID.
+// Throttled attempts fail with the time after which the client may retry.
+func (s *Server) login(ctx context.Context, req *contracts.AuthLoginRequest, ip string) (string, time.Duration, error) {
+	if verr := validation.Login(req); verr != nil {
+		return "", 0, verr
+	}
+	email := req.Email
+
+	decision := s.Throttler.Check(email, ip)
+	if !decision.Allowed {
+		return "", decision.RetryAfter, contracts.NewError(http.StatusTooManyRequests, contracts.ErrCodeRateLimited, "Too many login attempts, try again later")
+	}
+
+	if decision.ChallengeRequired && s.Verifier != nil {
+		ok, err := s.Verifier.Verify(ctx, req.Challenge, ip)
+		if err != nil {
+			return "", 0, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "Unable to verify challenge")
+		}
+		if !ok {
+			return "", 0, contracts.NewError(http.StatusForbidden, contracts.ErrCodeChallengeRequired, "Challenge required")
+		}
+	}
+
+	user, err := s.Repository.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", 0, err
+		}
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(req.Password))
+		s.Throttler.Failure(email, ip)
+		return "", 0, errInvalidCredentials()
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		s.Throttler.Failure(email, ip)
+		return "", 0, errInvalidCredentials()
+	}
+
+	s.Throttler.Success(email)
+	return user.ID.String(), 0, nil
+}
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"context"
 	"net/http"
-	"strings"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts"
 	"github.com/flaviogonzalez/e-commerce/auth/internal/helpers"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/repository"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/validation"
 	"github.com/flaviogonzalez/e-commerce/auth/models"
-	"github.com/flaviogonzalez/e-commerce/contracts"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,34 +17,41 @@ func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) { // cr
 
 	err := helpers.ReadJSON(w, r, &registerPayload)
 	if err != nil {
-		helpers.ErrorJSON(w, http.StatusBadRequest, "Invalid request payload")
+		s.errorJSON(w, r, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Invalid request payload"))
 		return
 	}
 
-	email := strings.ToLower(strings.TrimSpace(registerPayload.Email))
-	if email == "" || registerPayload.Password == "" {
-		helpers.ErrorJSON(w, http.StatusBadRequest, "Name, email, and password are required")
+	if err := s.register(r.Context(), &registerPayload); err != nil {
+		s.errorJSON(w, r, err)
 		return
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(registerPayload.Password), bcrypt.DefaultCost)
+	var response contracts.AuthRegisterResponse
+	response.Error = false
+	response.Message = "User registered successfully"
+
+	helpers.WriteJSON(w, http.StatusOK, response, nil)
+}
+
+func (s *Server) register(ctx context.Context, req *contracts.AuthRegisterRequest) error {
+	if verr := validation.Register(req); verr != nil {
+		return verr
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		helpers.ErrorJSON(w, http.StatusInternalServerError, "Error processing password")
-		return
+		return err
 	}
 
-	_, err = s.Repository.CreateUser(r.Context(), models.CreateUserParams{
-		Email:        registerPayload.Email,
+	_, err = s.Repository.CreateUser(ctx, models.CreateUserParams{
+		Email:        req.Email,
 		PasswordHash: string(passwordHash),
 	})
 	if err != nil {
-		helpers.ErrorJSON(w, http.StatusInternalServerError, "Error creating user: "+err.Error())
-		return
+		if repository.IsUniqueViolation(err) {
+			return contracts.NewError(http.StatusConflict, contracts.ErrCodeEmailTaken, "Email is already registered")
+		}
+		return err
 	}
-
-	var response contracts.AuthRegisterResponse
-	response.Error = false
-	response.Message = "User registered successfully"
-
-	helpers.WriteJSON(w, http.StatusOK, response, nil)
+	return nil
 }
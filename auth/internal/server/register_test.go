@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/repository"
+	"github.com/flaviogonzalez/e-commerce/auth/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// failingDB fails every query with err.
+type failingDB struct {
+	err error
+}
+
+func (db failingDB) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, db.err
+}
+
+func (db failingDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return nil, db.err
+}
+
+func (db failingDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	return failingRow{db.err}
+}
+
+type failingRow struct {
+	err error
+}
+
+func (r failingRow) Scan(...any) error {
+	return r.err
+}
+
+func register(t *testing.T, dbErr error) (int, contracts.ErrorPayload) {
+	t.Helper()
+
+	s := &Server{Repository: &repository.Repository{Queries: models.New(failingDB{dbErr})}}
+	body := `{"email":"Alice@Example.com","password":"correct horse"}`
+	rec := httptest.NewRecorder()
+	s.RegisterHandler(rec, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+
+	var payload contracts.ErrorPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode response: %v: %s", err, rec.Body)
+	}
+	return rec.Code, payload
+}
+
+func TestRegisterDuplicateEmail(t *testing.T) {
+	status, payload := register(t, &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"})
+	if status != http.StatusConflict || payload.Code != contracts.ErrCodeEmailTaken {
+		t.Errorf("got %d %s, want 409 %s", status, payload.Code, contracts.ErrCodeEmailTaken)
+	}
+}
+
+func TestRegisterHidesDatabaseErrors(t *testing.T) {
+	status, payload := register(t, errors.New(`relation "users" does not exist`))
+	if status != http.StatusInternalServerError || payload.Code != contracts.ErrCodeInternal {
+		t.Errorf("got %d %s, want 500 %s", status, payload.Code, contracts.ErrCodeInternal)
+	}
+	if strings.Contains(payload.Message, "relation") {
+		t.Errorf("message %q leaks the database error", payload.Message)
+	}
+
+	// Other constraint violations are no conflict the client can fix
+	if status, _ := register(t, &pgconn.PgError{Code: "23502"}); status != http.StatusInternalServerError {
+		t.Errorf("not-null violation: status %d, want 500", status)
+	}
+}
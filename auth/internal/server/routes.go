@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -11,8 +12,10 @@ import (
 func (s *Server) Routes() http.Handler {
 	mux := chi.NewRouter()
 
-	mux.Use(middleware.Logger)
 	mux.Use(middleware.Recoverer)
+	mux.Use(metrics.Middleware(func(r *http.Request) string {
+		return chi.RouteContext(r.Context()).RoutePattern()
+	}))
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -22,9 +25,34 @@ func (s *Server) Routes() http.Handler {
 		MaxAge:           300,
 	}))
 
-	mux.Post("/register", s.RegisterHandler)
-	mux.Get("/users", s.GetUsersHandler)
-	mux.Get("/users/{id}", s.GetUserHandler)
+	// Probes are registered before the logging middleware to keep them out of the logs
+	mux.Get("/healthz", s.HealthzHandler)
+	mux.Get("/readyz", s.ReadyzHandler)
+	mux.Handle(metrics.Path, metrics.Handler())
+
+	mux.Group(func(r chi.Router) {
+		if s.Logger != nil {
+			r.Use(s.Logger.Middleware)
+		} else {
+			r.Use(middleware.Logger)
+		}
+
+		r.Post("/register", s.RegisterHandler)
+		r.Post("/login", s.LoginHandler)
+		r.Get("/users", s.GetUsersHandler)
+		r.Get("/users/{id}", s.GetUserHandler)
+	})
+
+	return mux
+}
+
+// AdminRoutes serves operator-only endpoints. They are unauthenticated, so
+// they belong on a listener the public cannot reach.
+func (s *Server) AdminRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(middleware.Recoverer)
+	mux.Get("/debug/pool", s.PoolStatsHandler)
 
 	return mux
 }
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestPoolStatsOnlyOnAdminRoutes(t *testing.T) {
+	s := &Server{}
+
+	rec := httptest.NewRecorder()
+	s.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pool", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("public /debug/pool: %d, want 404", rec.Code)
+	}
+
+	admin := s.AdminRoutes().(chi.Routes)
+	if !admin.Match(chi.NewRouteContext(), http.MethodGet, "/debug/pool") {
+		t.Error("admin routes do not serve /debug/pool")
+	}
+	if admin.Match(chi.NewRouteContext(), http.MethodGet, "/users") {
+		t.Error("admin routes serve the public API")
+	}
+}
@@ -1,17 +1,65 @@
 package server
 
 import (
-	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	"github.com/Flaviogonzalez/e-commerce/contracts/logger"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/helpers"
 	"github.com/flaviogonzalez/e-commerce/auth/internal/repository"
+	"github.com/flaviogonzalez/e-commerce/auth/internal/throttle"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Server struct {
 	Repository *repository.Repository
+	Logger     *logger.Logger
+	Throttler  *throttle.Throttler
+	Verifier   throttle.Verifier // optional; enables login challenges when set
+
+	// Proxies relay the client address of the requests they pass on; it
+	// keys login throttling per subnet. Nobody is trusted by default.
+	Proxies clientip.Proxies
+
+	shuttingDown atomic.Bool
 }
 
-func NewServer(db *sql.DB) *Server {
+func NewServer(pool *pgxpool.Pool, log *logger.Logger) *Server {
 	return &Server{
-		Repository: repository.NewRepository(db),
+		Repository: repository.NewRepository(pool),
+		Logger:     log,
+		Throttler: throttle.New(throttle.Config{
+			ChallengeAfter: 3,
+		}),
+	}
+}
+
+// SetShuttingDown makes /readyz report not-ready so load balancers stop
+// routing new requests while in-flight ones drain.
+func (s *Server) SetShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+// errorJSON answers with err like helpers.ErrorJSON. Errors that are not a
+// *contracts.Error reach the client as a bare 500, so they are logged here
+// with the request's trace, or nobody would ever see them.
+func (s *Server) errorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *contracts.Error
+	if !errors.As(err, &apiErr) {
+		if s.Logger != nil {
+			s.Logger.Error("Request failed",
+				logger.WithContext(r.Context()),
+				logger.WithError(err),
+				logger.WithField("method", r.Method),
+				logger.WithField("path", r.URL.Path),
+			)
+		} else {
+			log.Printf("%s %s failed: %v", r.Method, r.URL.Path, err)
+		}
 	}
+	helpers.ErrorJSON(w, err)
 }
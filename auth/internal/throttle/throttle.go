@@ -0,0 +1,189 @@
+package throttle
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Throttler tracks failed login attempts per account and per client subnet
+// and applies an exponential backoff once a key crosses its failure limit.
+// It is independent of the broker's per-IP request limiter: credential
+// stuffing spread over many addresses still accumulates on the account key.
+type Throttler struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	cfg       Config
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type entry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+type Config struct {
+	EmailLimit     int           // failures per account before backoff starts
+	SubnetLimit    int           // failures per subnet before backoff starts
+	ChallengeAfter int           // failures on either key before a challenge is required (0 disables)
+	BaseDelay      time.Duration // first backoff delay
+	MaxDelay       time.Duration // backoff ceiling
+	Window         time.Duration // how long failures are remembered
+	IPv4Prefix     int           // subnet size used for IPv4 clients
+	IPv6Prefix     int           // subnet size used for IPv6 clients
+}
+
+// Decision is the outcome of a Check.
+type Decision struct {
+	Allowed           bool
+	RetryAfter        time.Duration
+	ChallengeRequired bool
+}
+
+func New(cfg Config) *Throttler {
+	if cfg.EmailLimit <= 0 {
+		cfg.EmailLimit = 5
+	}
+	if cfg.SubnetLimit <= 0 {
+		cfg.SubnetLimit = 50
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = time.Second
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 15 * time.Minute
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Hour
+	}
+	if cfg.IPv4Prefix <= 0 {
+		cfg.IPv4Prefix = 24
+	}
+	if cfg.IPv6Prefix <= 0 {
+		cfg.IPv6Prefix = 64
+	}
+
+	return &Throttler{
+		entries: make(map[string]*entry),
+		cfg:     cfg,
+		now:     time.Now,
+	}
+}
+
+// Check reports whether a login attempt for email from ip may proceed.
+func (t *Throttler) Check(email, ip string) Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	decision := Decision{Allowed: true}
+
+	for _, key := range t.keys(email, ip) {
+		e, ok := t.entries[key]
+		if !ok || t.expired(e, now) {
+			continue
+		}
+
+		if wait := e.blockedUntil.Sub(now); wait > 0 {
+			decision.Allowed = false
+			if wait > decision.RetryAfter {
+				decision.RetryAfter = wait
+			}
+		}
+
+		if t.cfg.ChallengeAfter > 0 && e.failures >= t.cfg.ChallengeAfter {
+			decision.ChallengeRequired = true
+		}
+	}
+
+	return decision
+}
+
+// Failure records a failed attempt against both the account and the subnet.
+func (t *Throttler) Failure(email, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+
+	t.record(emailKey(email), t.cfg.EmailLimit, now)
+	if subnetKey := t.subnetKey(ip); subnetKey != "" {
+		t.record(subnetKey, t.cfg.SubnetLimit, now)
+	}
+}
+
+// Success clears the account's failure history. The subnet history is kept
+// so that one valid account cannot be used to reset a stuffing run.
+func (t *Throttler) Success(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, emailKey(email))
+}
+
+func (t *Throttler) record(key string, limit int, now time.Time) {
+	e, ok := t.entries[key]
+	if !ok || t.expired(e, now) {
+		e = &entry{}
+		t.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures >= limit {
+		e.blockedUntil = now.Add(t.backoff(e.failures - limit))
+	}
+}
+
+func (t *Throttler) backoff(step int) time.Duration {
+	delay := float64(t.cfg.BaseDelay) * math.Pow(2, float64(step))
+	if delay > float64(t.cfg.MaxDelay) {
+		return t.cfg.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+func (t *Throttler) expired(e *entry, now time.Time) bool {
+	return now.Sub(e.lastFailure) > t.cfg.Window && now.After(e.blockedUntil)
+}
+
+// sweep drops expired entries at most once per window so the map cannot
+// grow without bound under a distributed attack.
+func (t *Throttler) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.cfg.Window {
+		return
+	}
+	t.lastSweep = now
+
+	for key, e := range t.entries {
+		if t.expired(e, now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+func (t *Throttler) keys(email, ip string) []string {
+	return []string{emailKey(email), t.subnetKey(ip)}
+}
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func (t *Throttler) subnetKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return "subnet:" + v4.Mask(net.CIDRMask(t.cfg.IPv4Prefix, 32)).String()
+	}
+	return "subnet:" + parsed.Mask(net.CIDRMask(t.cfg.IPv6Prefix, 128)).String()
+}
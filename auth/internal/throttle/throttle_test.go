@@ -0,0 +1,170 @@
+package throttle
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for the throttler.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func newThrottler(cfg Config) (*Throttler, *clock) {
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	t := New(cfg)
+	t.now = c.Now
+	return t, c
+}
+
+func TestAccountLimit(t *testing.T) {
+	th, clk := newThrottler(Config{EmailLimit: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second})
+
+	// Spread over many subnets, the failures still add up on the account
+	for i := range 2 {
+		th.Failure("Alice@example.com", fmt.Sprintf("198.51.%d.1", i))
+	}
+	if d := th.Check("alice@example.com", "203.0.113.1"); !d.Allowed {
+		t.Fatalf("blocked below the limit: %+v", d)
+	}
+
+	th.Failure(" alice@example.com ", "198.51.9.1")
+	d := th.Check("alice@example.com", "203.0.113.1")
+	if d.Allowed || d.RetryAfter != time.Second {
+		t.Fatalf("at the limit: %+v, want blocked for 1s", d)
+	}
+	if d := th.Check("bob@example.com", "203.0.113.1"); !d.Allowed {
+		t.Errorf("other account blocked: %+v", d)
+	}
+
+	clk.Advance(time.Second)
+	if d := th.Check("alice@example.com", "203.0.113.1"); !d.Allowed {
+		t.Errorf("still blocked after the backoff: %+v", d)
+	}
+
+	th.Success("alice@example.com")
+	th.Failure("alice@example.com", "203.0.113.1")
+	if d := th.Check("alice@example.com", "203.0.113.1"); !d.Allowed {
+		t.Errorf("success did not reset the account: %+v", d)
+	}
+}
+
+func TestSubnetLimit(t *testing.T) {
+	th, _ := newThrottler(Config{EmailLimit: 100, SubnetLimit: 4})
+
+	// Credential stuffing: one failure each for many accounts, one /24
+	for i := range 4 {
+		th.Failure(fmt.Sprintf("user%d@example.com", i), fmt.Sprintf("198.51.100.%d", i+1))
+	}
+
+	if d := th.Check("new@example.com", "198.51.100.200"); d.Allowed {
+		t.Errorf("subnet not blocked after 4 failures: %+v", d)
+	}
+	if d := th.Check("new@example.com", "198.51.101.1"); !d.Allowed {
+		t.Errorf("neighbouring subnet blocked: %+v", d)
+	}
+
+	// IPv6 clients are grouped by /64
+	for i := range 4 {
+		th.Failure(fmt.Sprintf("v6user%d@example.com", i), fmt.Sprintf("2001:db8:0:1::%x", i+1))
+	}
+	if d := th.Check("new@example.com", "2001:db8:0:1:ffff::1"); d.Allowed {
+		t.Errorf("IPv6 /64 not blocked: %+v", d)
+	}
+	if d := th.Check("new@example.com", "2001:db8:0:2::1"); !d.Allowed {
+		t.Errorf("other IPv6 /64 blocked: %+v", d)
+	}
+}
+
+func TestUnknownClientIsNotGrouped(t *testing.T) {
+	th, _ := newThrottler(Config{EmailLimit: 100, SubnetLimit: 2})
+
+	// Logins whose client address is unknown must not share one bucket,
+	// or a few failures would lock everybody out
+	for i := range 10 {
+		th.Failure(fmt.Sprintf("user%d@example.com", i), "")
+	}
+	if d := th.Check("new@example.com", ""); !d.Allowed {
+		t.Errorf("unknown clients blocked together: %+v", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	th, clk := newThrottler(Config{EmailLimit: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second, Window: time.Hour})
+
+	want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		th.Failure("alice@example.com", "")
+		d := th.Check("alice@example.com", "")
+		if d.RetryAfter != w || d.Allowed != (w == 0) {
+			t.Errorf("after %d failures: %+v, want retry after %v", i+1, d, w)
+		}
+	}
+
+	// Failures are forgotten once the window has passed
+	clk.Advance(time.Hour + time.Minute)
+	th.Failure("alice@example.com", "")
+	if d := th.Check("alice@example.com", ""); !d.Allowed {
+		t.Errorf("old failures still counted: %+v", d)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	th, _ := newThrottler(Config{EmailLimit: 10, ChallengeAfter: 2})
+
+	th.Failure("alice@example.com", "198.51.100.1")
+	if d := th.Check("alice@example.com", "198.51.100.1"); d.ChallengeRequired {
+		t.Errorf("challenge after one failure: %+v", d)
+	}
+	th.Failure("alice@example.com", "198.51.100.1")
+	if d := th.Check("alice@example.com", "198.51.100.1"); !d.Allowed || !d.ChallengeRequired {
+		t.Errorf("after two failures: %+v, want allowed with a challenge", d)
+	}
+}
+
+func TestConcurrentCheckAndFailure(t *testing.T) {
+	const (
+		workers  = 16
+		attempts = 50
+		limit    = 100
+	)
+	th, _ := newThrottler(Config{EmailLimit: limit, SubnetLimit: 1 << 20})
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range attempts {
+				th.Check("alice@example.com", fmt.Sprintf("198.51.100.%d", w))
+				th.Failure("alice@example.com", fmt.Sprintf("198.51.100.%d", w))
+			}
+		}()
+	}
+	wg.Wait()
+
+	th.mu.Lock()
+	failures := th.entries[emailKey("alice@example.com")].failures
+	th.mu.Unlock()
+	if failures != workers*attempts {
+		t.Errorf("%d failures recorded, want %d", failures, workers*attempts)
+	}
+	if d := th.Check("alice@example.com", "203.0.113.1"); d.Allowed {
+		t.Errorf("not blocked after %d concurrent failures: %+v", workers*attempts, d)
+	}
+}
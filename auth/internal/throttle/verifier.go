@@ -0,0 +1,25 @@
+package throttle
+
+import (
+	"context"
+	"crypto/subtle"
+)
+
+// Verifier validates a proof-of-work or CAPTCHA token presented by a client
+// once the throttler has flagged its attempts as suspicious.
+type Verifier interface {
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// FakeVerifier accepts a single fixed token. It stands in for a real
+// CAPTCHA provider in development and tests.
+type FakeVerifier struct {
+	Token string
+}
+
+func (f FakeVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	if f.Token == "" || token == "" {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(f.Token), []byte(token)) == 1, nil
+}
@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	maxEmailLength     = 254 // RFC 5321 forward-path limit
+	maxLocalPartLength = 64
+)
+
+var (
+	ErrEmailRequired = errors.New("email is required")
+	ErrEmailInvalid  = errors.New("email is not a valid address")
+	ErrEmailTooLong  = errors.New("email is too long")
+)
+
+// idnaProfile converts internationalized domains to their ASCII form and
+// rejects labels that are not valid under IDNA 2008.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.ValidateLabels(true),
+	idna.StrictDomainName(true),
+	idna.Transitional(false),
+)
+
+// NormalizeEmail parses raw as a bare RFC 5322 address and returns its
+// canonical form: Unicode NFC, lowercase local part and the domain encoded
+// as lowercase punycode. Two inputs that reach the same mailbox normalize
+// to the same string, which is what the unique index relies on.
+func NormalizeEmail(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrEmailRequired
+	}
+
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Name != "" || addr.Address != raw {
+		// Display names and comments ("Bob <bob@example.com>") are not accepted
+		return "", ErrEmailInvalid
+	}
+
+	at := strings.LastIndexByte(addr.Address, '@')
+	if at <= 0 || at == len(addr.Address)-1 {
+		return "", ErrEmailInvalid
+	}
+
+	local := norm.NFC.String(addr.Address[:at])
+	domain := addr.Address[at+1:]
+
+	asciiDomain, err := idnaProfile.ToASCII(domain)
+	if err != nil || !strings.Contains(asciiDomain, ".") {
+		return "", ErrEmailInvalid
+	}
+
+	if len(local) > maxLocalPartLength {
+		return "", ErrEmailTooLong
+	}
+
+	email := strings.ToLower(local) + "@" + strings.ToLower(asciiDomain)
+	if len(email) > maxEmailLength {
+		return "", ErrEmailTooLong
+	}
+
+	return email, nil
+}
@@ -0,0 +1,67 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		// Case folding and surrounding space
+		{"Alice@Example.COM", "alice@example.com", nil},
+		{"  bob@example.com\t", "bob@example.com", nil},
+		{"\u00c9LODIE@example.com", "\u00e9lodie@example.com", nil},
+		// Decomposed and composed forms of é reach the same mailbox
+		{"e\u0301lodie@example.com", "\u00e9lodie@example.com", nil},
+		// Internationalized domains become punycode
+		{"user@bücher.example", "user@xn--bcher-kva.example", nil},
+		{"user@BÜCHER.example", "user@xn--bcher-kva.example", nil},
+		{"user@xn--bcher-kva.example", "user@xn--bcher-kva.example", nil},
+		{"user@例え.テスト", "user@xn--r8jz45g.xn--zckzah", nil},
+		{"first.last+tag@example.com", "first.last+tag@example.com", nil},
+
+		{"", "", ErrEmailRequired},
+		{"   ", "", ErrEmailRequired},
+		// Display names, comments and angle brackets
+		{"Bob <bob@example.com>", "", ErrEmailInvalid},
+		{"<bob@example.com>", "", ErrEmailInvalid},
+		{"bob@example.com (Bob)", "", ErrEmailInvalid},
+		{`"Bob" <bob@example.com>`, "", ErrEmailInvalid},
+		// Malformed addresses and domains
+		{"bob", "", ErrEmailInvalid},
+		{"bob@", "", ErrEmailInvalid},
+		{"@example.com", "", ErrEmailInvalid},
+		{"bob@localhost", "", ErrEmailInvalid},
+		{"bob@exa mple.com", "", ErrEmailInvalid},
+		{"bob@-example.com", "", ErrEmailInvalid},
+		{"bob@example..com", "", ErrEmailInvalid},
+		{"a@b@example.com", "", ErrEmailInvalid},
+		// Length limits
+		{strings.Repeat("a", 64) + "@example.com", strings.Repeat("a", 64) + "@example.com", nil},
+		{strings.Repeat("a", 65) + "@example.com", "", ErrEmailTooLong},
+		{"a@" + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 63) + "." + strings.Repeat("e", 57) + ".com", "", ErrEmailTooLong},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeEmail(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("NormalizeEmail(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestNormalizedEmailIsStable(t *testing.T) {
+	for _, in := range []string{"Alice@Example.com", "user@bücher.example", "e\u0301lodie@example.com"} {
+		once, err := NormalizeEmail(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if twice, err := NormalizeEmail(once); err != nil || twice != once {
+			t.Errorf("NormalizeEmail(%q) = %q, %v; want it unchanged", once, twice, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package validation
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt ignores anything past 72 bytes
+)
+
+// Register validates a registration request and normalizes its email in
+// place. It returns a validation error listing every invalid field.
+func Register(req *contracts.AuthRegisterRequest) *contracts.Error {
+	verr := newValidationError()
+
+	email, err := NormalizeEmail(req.Email)
+	if err != nil {
+		verr.WithField("email", err.Error())
+	}
+	req.Email = email
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	switch {
+	case req.Password == "":
+		verr.WithField("password", "password is required")
+	case len(req.Password) < minPasswordLength:
+		verr.WithField("password", "password must be at least 8 characters")
+	case len(req.Password) > maxPasswordLength:
+		verr.WithField("password", "password must be at most 72 bytes")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// Login validates a login request and normalizes its email in place.
+// Password strength is not checked here so that old passwords keep working.
+func Login(req *contracts.AuthLoginRequest) *contracts.Error {
+	verr := newValidationError()
+
+	email, err := NormalizeEmail(req.Email)
+	if err != nil {
+		verr.WithField("email", err.Error())
+	}
+	req.Email = email
+
+	if req.Password == "" {
+		verr.WithField("password", "password is required")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func newValidationError() *contracts.Error {
+	return contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Request validation failed")
+}
@@ -5,10 +5,10 @@
 package models
 
 import (
+	"net/netip"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/sqlc-dev/pqtype"
 )
 
 type User struct {
@@ -24,7 +24,7 @@ type User struct {
 	FailedLoginAttempts int32       `json:"failed_login_attempts"`
 	LockedUntil         *time.Time  `json:"locked_until"`
 	LastLoginAt         *time.Time  `json:"last_login_at"`
-	LastLoginIp         pqtype.Inet `json:"last_login_ip"`
+	LastLoginIp         *netip.Addr `json:"last_login_ip"`
 	PasswordChangedAt   *time.Time  `json:"password_changed_at"`
 	CreatedAt           time.Time   `json:"created_at"`
 	UpdatedAt           time.Time   `json:"updated_at"`
@@ -7,11 +7,11 @@ package models
 
 import (
 	"context"
-	"database/sql"
+	"net/netip"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/sqlc-dev/pqtype"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 const countUsers = `-- name: CountUsers :one
@@ -19,7 +19,7 @@ SELECT COUNT(*) FROM users
 `
 
 func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
-	row := q.db.QueryRowContext(ctx, countUsers)
+	row := q.db.QueryRow(ctx, countUsers)
 	var count int64
 	err := row.Scan(&count)
 	return count, err
@@ -42,7 +42,7 @@ type CreateUserParams struct {
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.PasswordHash)
+	row := q.db.QueryRow(ctx, createUser, arg.Email, arg.PasswordHash)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -72,17 +72,17 @@ WHERE id = $1
 `
 
 func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteUser, id)
+	_, err := q.db.Exec(ctx, deleteUser, id)
 	return err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
 SELECT id, email, email_verified, password_hash, phone, phone_verified, avatar_url, status, role, failed_login_attempts, locked_until, last_login_at, last_login_ip, password_changed_at, created_at, updated_at, deleted_at FROM users
-WHERE email = $1 LIMIT 1
+WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL LIMIT 1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -138,7 +138,7 @@ type GetUserByIDRow struct {
 }
 
 func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error) {
-	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	row := q.db.QueryRow(ctx, getUserByID, id)
 	var i GetUserByIDRow
 	err := row.Scan(
 		&i.ID,
@@ -194,7 +194,7 @@ type ListUsersRow struct {
 }
 
 func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
-	rows, err := q.db.QueryContext(ctx, listUsers, arg.Limit, arg.Offset)
+	rows, err := q.db.Query(ctx, listUsers, arg.Limit, arg.Offset)
 	if err != nil {
 		return nil, err
 	}
@@ -219,9 +219,6 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUse
 		}
 		items = append(items, i)
 	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
@@ -249,23 +246,23 @@ RETURNING id, email, email_verified, password_hash, phone, phone_verified, avata
 `
 
 type UpdateUserParams struct {
-	ID                  uuid.UUID     `json:"id"`
-	Email               *string       `json:"email"`
-	EmailVerified       sql.NullBool  `json:"email_verified"`
-	Phone               *string       `json:"phone"`
-	PhoneVerified       sql.NullBool  `json:"phone_verified"`
-	AvatarUrl           *string       `json:"avatar_url"`
-	Status              *string       `json:"status"`
-	Role                *string       `json:"role"`
-	FailedLoginAttempts sql.NullInt32 `json:"failed_login_attempts"`
-	LockedUntil         *time.Time    `json:"locked_until"`
-	LastLoginAt         *time.Time    `json:"last_login_at"`
-	LastLoginIp         pqtype.Inet   `json:"last_login_ip"`
-	PasswordChangedAt   *time.Time    `json:"password_changed_at"`
+	ID                  uuid.UUID   `json:"id"`
+	Email               *string     `json:"email"`
+	EmailVerified       pgtype.Bool `json:"email_verified"`
+	Phone               *string     `json:"phone"`
+	PhoneVerified       pgtype.Bool `json:"phone_verified"`
+	AvatarUrl           *string     `json:"avatar_url"`
+	Status              *string     `json:"status"`
+	Role                *string     `json:"role"`
+	FailedLoginAttempts pgtype.Int4 `json:"failed_login_attempts"`
+	LockedUntil         *time.Time  `json:"locked_until"`
+	LastLoginAt         *time.Time  `json:"last_login_at"`
+	LastLoginIp         *netip.Addr `json:"last_login_ip"`
+	PasswordChangedAt   *time.Time  `json:"password_changed_at"`
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser,
+	row := q.db.QueryRow(ctx, updateUser,
 		arg.ID,
 		arg.Email,
 		arg.EmailVerified,
@@ -317,6 +314,6 @@ type UpdateUserPasswordParams struct {
 }
 
 func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
-	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.PasswordHash)
+	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.PasswordHash)
 	return err
 }
@@ -1,22 +1,57 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/gateway"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/graph"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/jobs"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/ratelimit"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
 	"github.com/Flaviogonzalez/e-commerce/broker/internal/server"
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
 	"github.com/Flaviogonzalez/e-commerce/contracts/logger"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
-	defaultPort     = "8080"
-	defaultExchange = "app_exchange"
+	defaultPort          = "8080"
+	defaultExchange      = "app_exchange"
+	routesReloadInterval = 5 * time.Second
+	defaultWSTopics      = "user.#,order.#,product.#"
+	wsTicketTTL          = 30 * time.Second
+
+	// Sync routes wait up to their own timeout (30s by default) before
+	// writing, so the write timeout must stay above the longest one
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultShutdownTimeout   = 20 * time.Second
+	defaultShutdownDelay     = 5 * time.Second
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Consumers and watchers run until shutdown has drained the HTTP side
+	bg, cancelBg := context.WithCancel(context.Background())
+	defer cancelBg()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = defaultPort
@@ -49,18 +84,19 @@ func main() {
 		defer appLogger.Close()
 	}
 
-	// Connect to RabbitMQ
-	conn, err := event.ConnectToRabbit(rabbitURL)
+	// Connect to RabbitMQ; the manager reconnects on its own if the
+	// connection drops later
+	rabbitManager, err := rabbit.Dial(rabbit.Config{URL: rabbitURL})
 	if err != nil {
 		if appLogger != nil {
 			appLogger.Fatal("Failed to connect to RabbitMQ", logger.WithError(err))
 		}
 		log.Fatal("Failed to connect to RabbitMQ:", err)
 	}
-	defer conn.Close()
+	defer rabbitManager.Close()
 
 	// Create emitter
-	emitter, err := event.NewEmitter(conn, exchange)
+	emitter, err := event.NewEmitter(rabbitManager, exchange)
 	if err != nil {
 		if appLogger != nil {
 			appLogger.Fatal("Failed to create emitter", logger.WithError(err))
@@ -69,18 +105,357 @@ func main() {
 	}
 	defer emitter.Close()
 
+	// Load route table
+	routesFile := os.Getenv("BROKER_ROUTES_FILE")
+	table, err := loadRouteTable(routesFile)
+	if err != nil {
+		if appLogger != nil {
+			appLogger.Fatal("Failed to load route table", logger.WithError(err))
+		}
+		log.Fatal("Failed to load route table:", err)
+	}
+
 	// Create server
-	srv := server.NewServer(emitter, appLogger)
+	srv, err := server.NewServer(emitter, appLogger, table)
+	if err != nil {
+		if appLogger != nil {
+			appLogger.Fatal("Failed to build routes", logger.WithError(err))
+		}
+		log.Fatal("Failed to build routes:", err)
+	}
+	if tokens := os.Getenv("BROKER_API_TOKENS"); tokens != "" {
+		srv.Auth = brokermw.NewTokenAuthenticator(brokermw.ParseTokens(tokens))
+	}
+	defer srv.Close()
+
+	if err := configureGraph(srv); err != nil {
+		if appLogger != nil {
+			appLogger.Fatal("Invalid GraphQL configuration", logger.WithError(err))
+		}
+		log.Fatal("Invalid GraphQL configuration:", err)
+	}
+
+	if err := configureRateLimits(&srv.RateLimits); err != nil {
+		if appLogger != nil {
+			appLogger.Fatal("Invalid rate limit configuration", logger.WithError(err))
+		}
+		log.Fatal("Invalid rate limit configuration:", err)
+	}
+
+	// Replicas share job state when given a database; otherwise jobs live
+	// in this process and only this replica can answer for them
+	if dsn := os.Getenv("BROKER_JOBS_DATABASE_URL"); dsn != "" {
+		pool, err := connectJobStore(ctx, srv, dsn)
+		if err != nil {
+			if appLogger != nil {
+				appLogger.Fatal("Failed to open job store", logger.WithError(err))
+			}
+			log.Fatal("Failed to open job store:", err)
+		}
+		defer pool.Close()
+	}
+
+	// Replies to async routes arrive on this replica's durable queue and
+	// complete jobs
+	if err := emitter.ConsumeJobReplies(bg, event.JobReplyQueue(replicaID()), srv.CompleteJob); err != nil {
+		if appLogger != nil {
+			appLogger.Fatal("Failed to consume job replies", logger.WithError(err))
+		}
+		log.Fatal("Failed to consume job replies:", err)
+	}
+
+	// WebSocket gateway for the dashboard
+	hub := gateway.NewHub(wsOrigins())
+	srv.Gateway = hub
+	if key := os.Getenv("BROKER_WS_TICKET_KEY"); key != "" {
+		srv.Tickets = brokermw.NewTickets([]byte(key), wsTicketTTL)
+		srv.Tickets.SetLedger(srv.TicketLedger())
+	}
+	go hub.Run(bg)
+	if _, err := emitter.Subscribe(bg, wsTopics(), hub.Event); err != nil {
+		log.Printf("Gateway event subscription failed: %v", err)
+	}
+	go gateway.SubscribeLogs(bg, hub, gateway.LogsConfig{
+		Brokers: strings.Split(kafkaBrokers, ","),
+		Topic:   "logs",
+		GroupID: wsLogsGroup(),
+	})
+
+	// Domain events invalidate cached responses
+	cacheEvents, err := emitter.Subscribe(bg, srv.Cache.Topics(), srv.Cache.Event)
+	if err != nil {
+		log.Printf("Cache invalidation subscription failed: %v", err)
+	}
+
+	if routesFile != "" {
+		go routing.Watch(bg, routesFile, routesReloadInterval, func(t *routing.Table) {
+			if err := srv.SetTable(t); err != nil {
+				log.Printf("Route table %s not applied: %v", routesFile, err)
+				return
+			}
+			if cacheEvents != nil {
+				if err := cacheEvents.SetTopics(srv.Cache.Topics()); err != nil {
+					log.Printf("Failed to update cache invalidation topics: %v", err)
+				}
+			}
+		})
+	}
+
+	timeouts, err := serverTimeouts()
+	if err != nil {
+		if appLogger != nil {
+			appLogger.Fatal("Invalid server timeout configuration", logger.WithError(err))
+		}
+		log.Fatal("Invalid server timeout configuration:", err)
+	}
+
+	httpServer := &http.Server{
+		Addr:              ":" + port,
+		Handler:           srv.Routes(),
+		ReadHeaderTimeout: timeouts.readHeader,
+		ReadTimeout:       timeouts.read,
+		WriteTimeout:      timeouts.write,
+		IdleTimeout:       timeouts.idle,
+	}
 
+	serverErr := make(chan error, 1)
+	go func() {
+		if appLogger != nil {
+			appLogger.Info("Broker service starting", logger.WithField("port", port))
+		}
+		log.Printf("Broker service starting on port %s", port)
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			if appLogger != nil {
+				appLogger.Error("Server failed", logger.WithError(err))
+			}
+			log.Printf("Server failed: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	// Fail readiness first and keep serving while load balancers notice,
+	// then stop accepting connections
+	log.Printf("Shutting down, reporting not ready for %s...", timeouts.shutdownDelay)
 	if appLogger != nil {
-		appLogger.Info("Broker service starting", logger.WithField("port", port))
+		appLogger.Info("Broker service shutting down")
+	}
+	srv.SetShuttingDown()
+	time.Sleep(timeouts.shutdownDelay)
+
+	log.Println("Draining in-flight requests...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeouts.shutdown)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown failed: %v", err)
 	}
-	log.Printf("Broker service starting on port %s", port)
 
-	if err := http.ListenAndServe(":"+port, srv.Routes()); err != nil {
+	// Handlers are done, but RPCs whose clients went away may still be
+	// waiting on replies; give them what is left of the deadline
+	if abandoned := emitter.Drain(shutdownCtx); abandoned > 0 {
+		log.Printf("Abandoned %d RPCs still waiting for replies", abandoned)
 		if appLogger != nil {
-			appLogger.Fatal("Server failed", logger.WithError(err))
+			appLogger.Warn("Abandoned pending RPCs on shutdown", logger.WithField("pending", abandoned))
 		}
-		log.Fatal("Server failed:", err)
 	}
+	cancelBg()
+
+	log.Println("Broker service stopped")
+}
+
+type timeouts struct {
+	readHeader, read, write, idle, shutdown time.Duration
+
+	// shutdownDelay is how long /readyz fails before the listener closes
+	shutdownDelay time.Duration
+}
+
+// serverTimeouts reads BROKER_READ_HEADER_TIMEOUT, BROKER_READ_TIMEOUT,
+// BROKER_WRITE_TIMEOUT, BROKER_IDLE_TIMEOUT and BROKER_SHUTDOWN_TIMEOUT.
+func serverTimeouts() (timeouts, error) {
+	t := timeouts{
+		readHeader: defaultReadHeaderTimeout,
+		read:       defaultReadTimeout,
+		write:      defaultWriteTimeout,
+		idle:       defaultIdleTimeout,
+		shutdown:   defaultShutdownTimeout,
+
+		shutdownDelay: defaultShutdownDelay,
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"BROKER_READ_HEADER_TIMEOUT": &t.readHeader,
+		"BROKER_READ_TIMEOUT":        &t.read,
+		"BROKER_WRITE_TIMEOUT":       &t.write,
+		"BROKER_IDLE_TIMEOUT":        &t.idle,
+		"BROKER_SHUTDOWN_TIMEOUT":    &t.shutdown,
+	} {
+		spec := os.Getenv(name)
+		if spec == "" {
+			continue
+		}
+		v, err := time.ParseDuration(spec)
+		if err != nil || v <= 0 {
+			return t, fmt.Errorf("%s: invalid duration %q", name, spec)
+		}
+		*d = v
+	}
+
+	// A zero delay is allowed, for replicas nothing routes to by readiness
+	if spec := os.Getenv("BROKER_SHUTDOWN_DELAY"); spec != "" {
+		v, err := time.ParseDuration(spec)
+		if err != nil || v < 0 {
+			return t, fmt.Errorf("BROKER_SHUTDOWN_DELAY: invalid duration %q", spec)
+		}
+		t.shutdownDelay = v
+	}
+	return t, nil
+}
+
+// configureRateLimits applies BROKER_RATE_LIMIT (per-client limit, e.g.
+// "1000/s"), BROKER_RATE_LIMIT_SUBJECTS ("subject=limit,...") and
+// BROKER_TRUSTED_PROXIES (CIDRs whose X-Forwarded-For is believed).
+func configureRateLimits(rl *server.RateLimits) error {
+	if spec := os.Getenv("BROKER_RATE_LIMIT"); spec != "" {
+		limit, err := ratelimit.ParseLimit(spec)
+		if err != nil {
+			return err
+		}
+		rl.Client = limit
+	}
+
+	if spec := os.Getenv("BROKER_RATE_LIMIT_SUBJECTS"); spec != "" {
+		rl.Subjects = make(map[string]ratelimit.Limit)
+		for _, pair := range strings.Split(spec, ",") {
+			subject, limitSpec, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return fmt.Errorf("rate limit override %q: want subject=limit", pair)
+			}
+			limit, err := ratelimit.ParseLimit(limitSpec)
+			if err != nil {
+				return err
+			}
+			rl.Subjects[subject] = limit
+		}
+	}
+
+	if spec := os.Getenv("BROKER_TRUSTED_PROXIES"); spec != "" {
+		proxies, err := clientip.ParseProxies(spec)
+		if err != nil {
+			return fmt.Errorf("trusted proxies: %w", err)
+		}
+		rl.IPs = brokermw.NewIPResolver(proxies)
+	}
+
+	return nil
+}
+
+// configureGraph applies BROKER_GRAPHQL_MAX_DEPTH and
+// BROKER_GRAPHQL_MAX_COMPLEXITY to the GraphQL endpoint.
+func configureGraph(srv *server.Server) error {
+	var cfg graph.Config
+	for name, limit := range map[string]*int{
+		"BROKER_GRAPHQL_MAX_DEPTH":      &cfg.MaxDepth,
+		"BROKER_GRAPHQL_MAX_COMPLEXITY": &cfg.MaxComplexity,
+	} {
+		spec := os.Getenv(name)
+		if spec == "" {
+			continue
+		}
+		n, err := strconv.Atoi(spec)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%s: invalid limit %q", name, spec)
+		}
+		*limit = n
+	}
+
+	h, err := srv.NewGraph(cfg)
+	if err != nil {
+		return err
+	}
+	srv.Graph = h
+	return nil
+}
+
+// wsTopics returns the routing keys streamed to WebSocket clients, from
+// the comma-separated BROKER_WS_TOPICS. They should name domain events
+// such as user.created, not the command topics of the route table, like
+// auth.register, whose bodies carry what clients sent.
+func wsTopics() []string {
+	spec := os.Getenv("BROKER_WS_TOPICS")
+	if spec == "" {
+		spec = defaultWSTopics
+	}
+
+	var topics []string
+	for _, t := range strings.Split(spec, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// wsOrigins returns the origins, besides the broker's own, whose pages may
+// open WebSockets, from the comma-separated BROKER_WS_ORIGINS.
+func wsOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("BROKER_WS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// wsLogsGroup names the Kafka consumer group for the log stream. It must be
+// unique per replica, or replicas would split the log between them.
+func wsLogsGroup() string {
+	return "broker-ws-" + replicaID()
+}
+
+// replicaID names this broker replica, from BROKER_REPLICA_ID or else the
+// host name. It must be unique and should survive restarts, so the
+// replica finds the job replies that arrived while it was down.
+func replicaID() string {
+	if id := os.Getenv("BROKER_REPLICA_ID"); id != "" {
+		return id
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "local"
+	}
+	return host
+}
+
+// connectJobStore keeps the server's jobs in the Postgres database at dsn.
+func connectJobStore(ctx context.Context, srv *server.Server, dsn string) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	initCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	store, err := jobs.NewPostgresStore(initCtx, pool, 0)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	srv.Jobs = store
+	return pool, nil
+}
+
+func loadRouteTable(path string) (*routing.Table, error) {
+	if path == "" {
+		return routing.Default()
+	}
+	return routing.Load(path)
 }
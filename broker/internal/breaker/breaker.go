@@ -0,0 +1,237 @@
+// Package breaker protects the broker from slow or failing downstream
+// services: a circuit breaker per topic stops sending requests to a
+// failing service, and a bulkhead caps how many requests wait on it.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOpen         = errors.New("circuit breaker is open")
+	ErrBulkheadFull = errors.New("too many concurrent requests")
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is the outcome of a request let through by a breaker.
+type Result int
+
+const (
+	Success Result = iota
+	Failure
+	// Ignored requests say nothing about the service's health, e.g.
+	// because the client gave up first.
+	Ignored
+)
+
+type Config struct {
+	FailureRatio     float64       // failure share that opens the breaker
+	MinRequests      int           // requests in a window before the ratio counts
+	Window           time.Duration // how long counts accumulate while closed
+	OpenTimeout      time.Duration // how long the breaker stays open
+	HalfOpenRequests int           // successful probes needed to close again
+	MaxConcurrent    int           // bulkhead size; 0 disables it
+}
+
+func (c *Config) applyDefaults() {
+	if c.FailureRatio <= 0 {
+		c.FailureRatio = 0.5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 10
+	}
+	if c.Window <= 0 {
+		c.Window = 30 * time.Second
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 15 * time.Second
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = 3
+	}
+}
+
+// Breaker is a circuit breaker with a bulkhead. While closed it counts
+// results per window and opens when the failure ratio is reached; while
+// open it rejects everything; after OpenTimeout it lets HalfOpenRequests
+// probes through and closes if they all succeed.
+type Breaker struct {
+	name string
+	cfg  Config
+
+	mu         sync.Mutex
+	state      State
+	generation uint64    // bumped on every state change or new window
+	expiry     time.Time // end of the window (closed) or of the open period
+	openedAt   time.Time
+	requests   int
+	failures   int
+	successes  int
+	inFlight   int
+	rejected   uint64
+
+	now func() time.Time
+}
+
+func New(name string, cfg Config) *Breaker {
+	cfg.applyDefaults()
+	b := &Breaker{name: name, cfg: cfg, now: time.Now}
+	b.setState(StateClosed, b.now())
+	return b
+}
+
+// Allow asks to send one request. On success the caller must report the
+// request's result through done. When rejected, retryAfter says when
+// trying again makes sense.
+func (b *Breaker) Allow() (done func(Result), retryAfter time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.advance(now)
+
+	switch {
+	case b.state == StateOpen:
+		b.reject("open")
+		return nil, b.expiry.Sub(now), ErrOpen
+	case b.state == StateHalfOpen && b.requests >= b.cfg.HalfOpenRequests:
+		b.reject("half_open")
+		return nil, time.Second, ErrOpen
+	case b.cfg.MaxConcurrent > 0 && b.inFlight >= b.cfg.MaxConcurrent:
+		b.reject("bulkhead")
+		return nil, time.Second, ErrBulkheadFull
+	}
+
+	b.inFlight++
+	b.requests++
+	generation := b.generation
+
+	var once sync.Once
+	return func(r Result) {
+		once.Do(func() { b.done(generation, r) })
+	}, 0, nil
+}
+
+func (b *Breaker) reject(reason string) {
+	b.rejected++
+	rejections.WithLabelValues(b.name, reason).Inc()
+}
+
+func (b *Breaker) done(generation uint64, r Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inFlight--
+
+	now := b.now()
+	b.advance(now)
+	// Results from an earlier window or state don't describe the current one
+	if generation != b.generation {
+		return
+	}
+
+	switch r {
+	case Ignored:
+		b.requests--
+	case Success:
+		b.successes++
+		if b.state == StateHalfOpen && b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	case Failure:
+		b.failures++
+		switch b.state {
+		case StateHalfOpen:
+			b.setState(StateOpen, now)
+		case StateClosed:
+			if b.requests >= b.cfg.MinRequests &&
+				float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio {
+				b.setState(StateOpen, now)
+			}
+		}
+	}
+}
+
+// advance applies the transitions that happen with time alone.
+// Half-open lasts until the probes decide.
+func (b *Breaker) advance(now time.Time) {
+	if b.state == StateHalfOpen || now.Before(b.expiry) {
+		return
+	}
+	if b.state == StateOpen {
+		b.setState(StateHalfOpen, now)
+	} else {
+		b.setState(StateClosed, now)
+	}
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	stateGauge.WithLabelValues(b.name).Set(float64(state))
+	b.generation++
+	b.requests, b.failures, b.successes = 0, 0, 0
+
+	switch state {
+	case StateClosed:
+		b.expiry = now.Add(b.cfg.Window)
+	case StateOpen:
+		b.openedAt = now
+		b.expiry = now.Add(b.cfg.OpenTimeout)
+	case StateHalfOpen:
+		b.expiry = time.Time{}
+	}
+}
+
+// Status is a snapshot of a breaker for metrics and the admin endpoint.
+type Status struct {
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Requests int        `json:"requests"`
+	Failures int        `json:"failures"`
+	InFlight int        `json:"in_flight"`
+	Rejected uint64     `json:"rejected"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(b.now())
+
+	s := Status{
+		Name:     b.name,
+		State:    b.state.String(),
+		Requests: b.requests,
+		Failures: b.failures,
+		InFlight: b.inFlight,
+		Rejected: b.rejected,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+	return s
+}
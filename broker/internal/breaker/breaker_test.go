@@ -0,0 +1,181 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newBreaker(name string, cfg Config) (*Breaker, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := New(name, cfg)
+	b.now = func() time.Time { return now }
+	b.setState(StateClosed, now)
+	return b, &now
+}
+
+// send lets one request through b and reports r, failing the test when b
+// rejects it.
+func send(t *testing.T, b *Breaker, r Result) {
+	t.Helper()
+	done, _, err := b.Allow()
+	if err != nil {
+		t.Fatalf("request rejected in state %s: %v", b.Status().State, err)
+	}
+	done(r)
+}
+
+func TestBreakerStates(t *testing.T) {
+	b, now := newBreaker("test.states", Config{
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		Window:           time.Minute,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenRequests: 2,
+	})
+
+	// Below MinRequests even failures only keep it closed
+	send(t, b, Failure)
+	send(t, b, Failure)
+	send(t, b, Success)
+	if s := b.Status(); s.State != "closed" {
+		t.Fatalf("opened before MinRequests: %+v", s)
+	}
+
+	send(t, b, Failure) // 3 of 4 failed
+	if s := b.Status(); s.State != "open" || s.OpenedAt == nil {
+		t.Fatalf("still %+v after 3 of 4 failures", s)
+	}
+	if got := testutil.ToFloat64(stateGauge.WithLabelValues("test.states")); got != float64(StateOpen) {
+		t.Errorf("state gauge = %v, want %v", got, float64(StateOpen))
+	}
+
+	*now = now.Add(4 * time.Second)
+	if _, retryAfter, err := b.Allow(); !errors.Is(err, ErrOpen) || retryAfter != 6*time.Second {
+		t.Errorf("open breaker: retry after %v, %v; want 6s, ErrOpen", retryAfter, err)
+	}
+
+	// After OpenTimeout only HalfOpenRequests probes go through
+	*now = now.Add(6 * time.Second)
+	probe1, _, err := b.Allow()
+	if err != nil {
+		t.Fatalf("first probe rejected: %v", err)
+	}
+	probe2, _, err := b.Allow()
+	if err != nil {
+		t.Fatalf("second probe rejected: %v", err)
+	}
+	if _, _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("third request while half-open: %v, want ErrOpen", err)
+	}
+	if s := b.Status(); s.State != "half-open" {
+		t.Errorf("state %s, want half-open", s.State)
+	}
+
+	probe1(Success)
+	probe2(Success)
+	if s := b.Status(); s.State != "closed" {
+		t.Errorf("state %s after successful probes, want closed", s.State)
+	}
+	if got := testutil.ToFloat64(stateGauge.WithLabelValues("test.states")); got != float64(StateClosed) {
+		t.Errorf("state gauge = %v, want %v", got, float64(StateClosed))
+	}
+}
+
+func TestFailedProbeReopens(t *testing.T) {
+	b, now := newBreaker("test.probe", Config{MinRequests: 1, OpenTimeout: time.Second, HalfOpenRequests: 3})
+
+	send(t, b, Failure)
+	*now = now.Add(time.Second)
+	send(t, b, Success)
+	send(t, b, Failure)
+	if s := b.Status(); s.State != "open" {
+		t.Errorf("state %s after a failed probe, want open", s.State)
+	}
+}
+
+func TestWindowResetsCounts(t *testing.T) {
+	b, now := newBreaker("test.window", Config{MinRequests: 4, Window: time.Minute})
+
+	send(t, b, Failure)
+	send(t, b, Failure)
+	send(t, b, Failure)
+	*now = now.Add(time.Minute)
+	send(t, b, Failure)
+	if s := b.Status(); s.State != "closed" || s.Requests != 1 {
+		t.Errorf("failures from the last window counted: %+v", s)
+	}
+}
+
+func TestStaleResultsAreIgnored(t *testing.T) {
+	b, now := newBreaker("test.stale", Config{MinRequests: 1, OpenTimeout: time.Second, HalfOpenRequests: 1})
+
+	slow, _, _ := b.Allow()
+	send(t, b, Failure)
+	*now = now.Add(time.Second)
+	b.Status() // half-open
+
+	// A success sent before the breaker opened says nothing about now
+	slow(Success)
+	if s := b.Status(); s.State != "half-open" {
+		t.Errorf("stale success moved the breaker to %s", s.State)
+	}
+}
+
+func TestIgnoredResults(t *testing.T) {
+	b, _ := newBreaker("test.ignored", Config{MinRequests: 2})
+
+	send(t, b, Failure)
+	send(t, b, Ignored)
+	send(t, b, Ignored)
+	if s := b.Status(); s.State != "closed" || s.Requests != 1 {
+		t.Errorf("ignored results counted: %+v", s)
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	b, _ := newBreaker("test.bulkhead", Config{MaxConcurrent: 2})
+	before := testutil.ToFloat64(rejections.WithLabelValues("test.bulkhead", "bulkhead"))
+
+	first, _, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	if _, retryAfter, err := b.Allow(); !errors.Is(err, ErrBulkheadFull) || retryAfter <= 0 {
+		t.Fatalf("third concurrent request: %v, retry after %v; want ErrBulkheadFull", err, retryAfter)
+	}
+	if s := b.Status(); s.InFlight != 2 || s.Rejected != 1 {
+		t.Errorf("status %+v, want 2 in flight and 1 rejected", s)
+	}
+	if got := testutil.ToFloat64(rejections.WithLabelValues("test.bulkhead", "bulkhead")) - before; got != 1 {
+		t.Errorf("%v bulkhead rejections counted, want 1", got)
+	}
+
+	// Reporting twice frees only one slot
+	first(Success)
+	first(Success)
+	if _, _, err := b.Allow(); err != nil {
+		t.Errorf("slot not freed: %v", err)
+	}
+	if _, _, err := b.Allow(); !errors.Is(err, ErrBulkheadFull) {
+		t.Errorf("double report freed two slots: %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet(Config{})
+	if s.Get("b") != s.Get("b") {
+		t.Error("Get returned a new breaker for a known name")
+	}
+	s.Get("a")
+
+	snap := s.Snapshot()
+	if len(snap) != 2 || snap[0].Name != "a" || snap[1].Name != "b" {
+		t.Errorf("snapshot %+v, want a and b in order", snap)
+	}
+}
@@ -0,0 +1,18 @@
+package breaker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	stateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "broker_circuit_breaker_state",
+		Help: "Circuit breaker state by topic: 0 closed, 1 half-open, 2 open.",
+	}, []string{"topic"})
+
+	rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_circuit_breaker_rejections_total",
+		Help: "Requests turned away by a circuit breaker or bulkhead, by topic and reason.",
+	}, []string{"topic", "reason"})
+)
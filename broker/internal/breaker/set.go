@@ -0,0 +1,49 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+)
+
+// Set holds one breaker per downstream topic, created on first use with a
+// shared config.
+type Set struct {
+	cfg Config
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewSet(cfg Config) *Set {
+	return &Set{cfg: cfg, breakers: make(map[string]*Breaker)}
+}
+
+// Get returns the breaker for name, creating it if needed.
+func (s *Set) Get(name string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[name]
+	if !ok {
+		b = New(name, s.cfg)
+		s.breakers[name] = b
+	}
+	return b
+}
+
+// Snapshot returns the status of every breaker, sorted by name.
+func (s *Set) Snapshot() []Status {
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(breakers))
+	for _, b := range breakers {
+		statuses = append(statuses, b.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
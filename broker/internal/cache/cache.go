@@ -0,0 +1,325 @@
+// Package cache serves repeated reads from memory: cached GET responses
+// with ETags, coalescing of concurrent identical requests into one
+// upstream call, and invalidation driven by domain events.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/go-chi/chi/v5"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultMaxEntries = 10000
+
+// Cache holds responses of cacheable routes, keyed by route, URL and, for
+// authenticated routes, the caller.
+type Cache struct {
+	maxEntries int
+	group      singleflight.Group
+
+	mu      sync.RWMutex
+	entries map[string]*entry
+	topics  map[string][]routing.Route // event topic -> routes it invalidates
+}
+
+type entry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	etag    string
+	expires time.Time
+	route   string
+	params  map[string]string
+}
+
+func New(maxEntries int) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	return &Cache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*entry),
+		topics:     make(map[string][]routing.Route),
+	}
+}
+
+// SetTable records which events invalidate which routes.
+func (c *Cache) SetTable(t *routing.Table) {
+	topics := make(map[string][]routing.Route)
+	for _, route := range t.Routes {
+		for _, topic := range route.Cache.InvalidatedBy {
+			topics[topic] = append(topics[topic], route)
+		}
+	}
+
+	c.mu.Lock()
+	c.topics = topics
+	c.mu.Unlock()
+}
+
+// Topics lists the event topics the cache needs to hear about.
+func (c *Cache) Topics() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// Middleware caches successful responses of route for its TTL. Concurrent
+// misses for the same key share one upstream call, which runs detached from
+// the first caller's context so its departure doesn't fail the others.
+func (c *Cache) Middleware(route routing.Route) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if noCache(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			params := make(map[string]string, len(route.PathParams))
+			for _, name := range route.PathParams {
+				params[name] = chi.URLParam(r, name)
+			}
+			key := cacheKey(route, r)
+
+			if e := c.get(key); e != nil {
+				serve(w, r, route, e, "HIT")
+				return
+			}
+
+			v, _, _ := c.group.Do(key, func() (any, error) {
+				rec := newRecorder()
+				next.ServeHTTP(rec, r.WithContext(context.WithoutCancel(r.Context())))
+				if rec.status == 0 {
+					rec.status = http.StatusOK
+				}
+
+				e := &entry{
+					status:  rec.status,
+					header:  rec.header,
+					body:    rec.body.Bytes(),
+					etag:    etag(rec.body.Bytes()),
+					expires: time.Now().Add(route.Cache.TTL),
+					route:   route.ID(),
+					params:  params,
+				}
+				if e.status == http.StatusOK {
+					c.put(key, e)
+				}
+				return e, nil
+			})
+
+			serve(w, r, route, v.(*entry), "MISS")
+		})
+	}
+}
+
+func (c *Cache) get(key string) *entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil
+	}
+	return e
+}
+
+func (c *Cache) put(key string, e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.maxEntries {
+		c.evict()
+	}
+	c.entries[key] = e
+}
+
+// evict drops expired entries, or an arbitrary one if none has expired.
+func (c *Cache) evict() {
+	now := time.Now()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	for key := range c.entries {
+		delete(c.entries, key)
+		return
+	}
+}
+
+// Event invalidates cached responses affected by a domain event delivered
+// from the exchange.
+func (c *Cache) Event(msg amqp.Delivery) {
+	c.Invalidate(msg.RoutingKey, eventFields(msg.Body))
+}
+
+// Invalidate drops the responses of every route invalidated by topic. When
+// fields carries all of a route's path params, only the entries for those
+// params go; otherwise the whole route does.
+func (c *Cache) Invalidate(topic string, fields map[string]any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, route := range c.topics[topic] {
+		match := make(map[string]string, len(route.PathParams))
+		for _, name := range route.PathParams {
+			if v, ok := fields[name]; ok {
+				match[name] = fmt.Sprint(v)
+			}
+		}
+		if len(match) < len(route.PathParams) {
+			match = nil
+		}
+
+		for key, e := range c.entries {
+			if e.route == route.ID() && paramsMatch(e.params, match) {
+				delete(c.entries, key)
+			}
+		}
+	}
+}
+
+func paramsMatch(params, match map[string]string) bool {
+	for name, v := range match {
+		if params[name] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// eventFields extracts the data of an event, which is usually wrapped in
+// an EventPayload.
+func eventFields(body []byte) map[string]any {
+	var payload struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Data != nil {
+		return payload.Data
+	}
+
+	var fields map[string]any
+	json.Unmarshal(body, &fields)
+	return fields
+}
+
+func cacheKey(route routing.Route, r *http.Request) string {
+	key := route.ID() + "|" + r.URL.Path + "?" + r.URL.Query().Encode()
+	if route.Auth != routing.AuthNone {
+		if id, ok := brokermw.IdentityFrom(r.Context()); ok {
+			key += "|" + id.Subject
+		}
+	}
+	return key
+}
+
+func noCache(r *http.Request) bool {
+	cc := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store")
+}
+
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// serve writes e, or 304 when the client already holds its ETag.
+func serve(w http.ResponseWriter, r *http.Request, route routing.Route, e *entry, status string) {
+	h := w.Header()
+	for name, values := range e.header {
+		h[name] = slices.Clone(values)
+	}
+	if e.status != http.StatusOK {
+		w.WriteHeader(e.status)
+		w.Write(e.body)
+		return
+	}
+
+	maxAge := int(time.Until(e.expires).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	scope := "public"
+	if route.Auth != routing.AuthNone {
+		scope = "private"
+	}
+	h.Set("Cache-Control", scope+", max-age="+strconv.Itoa(maxAge))
+	h.Set("ETag", e.etag)
+	h.Set("X-Cache", status)
+
+	if etagMatches(r.Header.Get("If-None-Match"), e.etag) {
+		h.Del("Content-Length")
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// recorder captures the upstream response for the cache.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header)}
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
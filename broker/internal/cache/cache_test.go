@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/go-chi/chi/v5"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	userRoute = routing.Route{
+		Method:     http.MethodGet,
+		Path:       "/users/{id}",
+		PathParams: []string{"id"},
+		Cache:      routing.CachePolicy{TTL: time.Minute, InvalidatedBy: []string{"user.updated"}},
+	}
+	usersRoute = routing.Route{
+		Method: http.MethodGet,
+		Path:   "/users",
+		Cache:  routing.CachePolicy{TTL: time.Minute, InvalidatedBy: []string{"user.updated"}},
+	}
+)
+
+// upstream counts the requests reaching it and answers with their path.
+type upstream struct {
+	calls atomic.Int64
+	gate  chan struct{} // when set, requests wait for it to close
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.calls.Add(1)
+	if u.gate != nil {
+		<-u.gate
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+}
+
+func newRouter(c *Cache, next http.Handler, routes ...routing.Route) http.Handler {
+	c.SetTable(&routing.Table{Routes: routes})
+
+	router := chi.NewRouter()
+	for _, route := range routes {
+		router.Method(route.Method, route.Path, c.Middleware(route)(next))
+	}
+	return router
+}
+
+func get(h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNotModified(t *testing.T) {
+	up := &upstream{}
+	h := newRouter(New(0), up, userRoute)
+
+	first := get(h, "/users/1", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: status %d, ETag %q, X-Cache %q", first.Code, etag, first.Header().Get("X-Cache"))
+	}
+
+	rec := get(h, "/users/1", http.Header{"If-None-Match": {`"other", ` + etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status %d, want 304", rec.Code)
+	}
+	if rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("304 with body %q, ETag %q, X-Cache %q", rec.Body, rec.Header().Get("ETag"), rec.Header().Get("X-Cache"))
+	}
+
+	if rec := get(h, "/users/1", http.Header{"If-None-Match": {`"stale"`}}); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("stale ETag: status %d, body %q", rec.Code, rec.Body)
+	}
+	if n := up.calls.Load(); n != 1 {
+		t.Errorf("%d upstream calls, want 1", n)
+	}
+}
+
+func TestConcurrentMissesShareOneCall(t *testing.T) {
+	up := &upstream{gate: make(chan struct{})}
+	c := New(0)
+	h := newRouter(c, up, userRoute)
+
+	const clients = 20
+	var arrived sync.WaitGroup
+	arrived.Add(clients)
+	arriving := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		h.ServeHTTP(w, r)
+	})
+
+	var wg sync.WaitGroup
+	codes := make([]int, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = get(arriving, "/users/1", nil).Code
+		}()
+	}
+
+	// Let every client reach the cache before the upstream answers
+	arrived.Wait()
+	time.Sleep(20 * time.Millisecond)
+	close(up.gate)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("client %d: status %d", i, code)
+		}
+	}
+	if n := up.calls.Load(); n != 1 {
+		t.Errorf("%d upstream calls for %d concurrent misses, want 1", n, clients)
+	}
+}
+
+func TestErrorsAreNotCached(t *testing.T) {
+	var calls atomic.Int64
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	h := newRouter(New(0), failing, userRoute)
+
+	for range 2 {
+		if rec := get(h, "/users/1", nil); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("status %d, want 503", rec.Code)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d upstream calls, want 2", n)
+	}
+}
+
+func TestEventInvalidatesMatchingEntries(t *testing.T) {
+	up := &upstream{}
+	c := New(0)
+	h := newRouter(c, up, userRoute, usersRoute)
+
+	for _, path := range []string{"/users/1", "/users/2", "/users"} {
+		get(h, path, nil)
+	}
+
+	// Events of other topics leave the cache alone
+	c.Event(amqp.Delivery{RoutingKey: "user.created", Body: []byte(`{"name":"created","data":{"id":"1"}}`)})
+	c.Event(amqp.Delivery{RoutingKey: "user.updated", Body: []byte(`{"name":"updated","data":{"id":"1"}}`)})
+
+	for path, want := range map[string]string{
+		"/users/1": "MISS", // matching path params
+		"/users/2": "HIT",  // other ID
+		"/users":   "MISS", // the route has no params, so all of it goes
+	} {
+		if got := get(h, path, nil).Header().Get("X-Cache"); got != want {
+			t.Errorf("%s after user.updated: X-Cache %q, want %q", path, got, want)
+		}
+	}
+
+	// Without the params the event drops the whole route
+	c.Invalidate("user.updated", nil)
+	if got := get(h, "/users/2", nil).Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("/users/2 after a user.updated without an ID: X-Cache %q, want MISS", got)
+	}
+}
+
+func TestNoCacheBypasses(t *testing.T) {
+	up := &upstream{}
+	h := newRouter(New(0), up, userRoute)
+
+	get(h, "/users/1", nil)
+	rec := get(h, "/users/1", http.Header{"Cache-Control": {"no-cache"}})
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "" {
+		t.Errorf("no-cache: status %d, X-Cache %q", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if n := up.calls.Load(); n != 2 {
+		t.Errorf("%d upstream calls, want 2", n)
+	}
+}
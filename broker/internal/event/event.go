@@ -1,88 +1,368 @@
 package event
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math"
 	"sync"
 	"time"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// directReplyQueue is RabbitMQ's pseudo-queue for direct reply-to. Replies
+// published to it are delivered straight to the consumer on the channel
+// that sent the request, without declaring a queue per request.
+const directReplyQueue = "amq.rabbitmq.reply-to"
+
+const defaultRPCTimeout = 30 * time.Second
+
+// publishAttempts bounds how often a nacked publish is retried.
+const publishAttempts = 3
+
+const drainPollInterval = 50 * time.Millisecond
+
+var (
+	ErrEmitterClosed = errors.New("emitter closed")
+	ErrTimeout       = errors.New("timeout waiting for response")
+)
+
+// Channel is the subset of *amqp.Channel used by the Emitter. It lets tests
+// and benchmarks run the RPC client against an in-memory transport.
+type Channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Confirm(noWait bool) error
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error)
+	Close() error
+}
+
 type Emitter struct {
-	conn     *amqp.Connection
+	manager  *rabbit.Manager // nil when built on a fixed channel
 	exchange string
-	mu       sync.RWMutex
-	channel  *amqp.Channel
+	timeout  time.Duration
+
+	mu         sync.RWMutex
+	channel    Channel
+	generation uint64 // bumped every time a new reply channel is installed
+	jobReplies string // queue receiving job replies, once consumed
+
+	// amqp channels are not safe for concurrent publishing
+	publishMu sync.Mutex
+
+	pendingMu sync.Mutex
+	pending   map[string]*waiter
+	closed    bool
 }
 
-func ConnectToRabbit(url string) (*amqp.Connection, error) {
-	var counts int64
-	var backoff time.Duration
+// waiter is an RPC waiting for its reply on the channel of a given
+// generation. returned receives the request itself if no queue took it.
+type waiter struct {
+	reply      chan amqp.Delivery
+	returned   chan amqp.Return
+	generation uint64
+}
 
-	for {
-		connection, err := amqp.Dial(url)
-		if err != nil {
-			log.Printf("RabbitMQ not ready, attempt %d, retrying...", counts+1)
-			counts++
-		} else {
-			log.Println("Connected to RabbitMQ")
-			return connection, nil
-		}
+// NewEmitter builds an Emitter on a managed connection. The reply consumer
+// is re-established automatically after the manager reconnects.
+func NewEmitter(manager *rabbit.Manager, exchange string) (*Emitter, error) {
+	e := newEmitter(exchange)
+	e.manager = manager
 
-		if counts > 10 {
-			return nil, fmt.Errorf("failed to connect to RabbitMQ after %d attempts", counts)
-		}
+	if err := manager.DeclareTopology(exchangeTopology(exchange)); err != nil {
+		return nil, err
+	}
 
-		backoff = time.Duration(math.Pow(float64(counts), 2)) * time.Second
-		time.Sleep(backoff)
+	if err := e.connect(); err != nil {
+		return nil, err
 	}
+
+	manager.OnReconnect(func() {
+		if err := e.connect(); err != nil {
+			log.Printf("Emitter: re-establish reply consumer: %v", err)
+		}
+	})
+
+	return e, nil
 }
 
-func NewEmitter(conn *amqp.Connection, exchange string) (*Emitter, error) {
-	e := &Emitter{
-		conn:     conn,
-		exchange: exchange,
+// NewEmitterWithChannel builds an Emitter on an already open channel. The
+// Emitter stops for good when that channel closes.
+func NewEmitterWithChannel(ch Channel, exchange string) (*Emitter, error) {
+	e := newEmitter(exchange)
+
+	if err := ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("declare exchange: %w", err)
 	}
 
-	if err := e.setup(); err != nil {
+	if err := e.setup(ch); err != nil {
 		return nil, err
 	}
 
 	return e, nil
 }
 
-func (e *Emitter) setup() error {
-	ch, err := e.conn.Channel()
+func newEmitter(exchange string) *Emitter {
+	return &Emitter{
+		exchange: exchange,
+		timeout:  defaultRPCTimeout,
+		pending:  make(map[string]*waiter),
+	}
+}
+
+func exchangeTopology(exchange string) rabbit.Topology {
+	return func(ch rabbit.Declarer) error {
+		err := ch.ExchangeDeclare(
+			exchange,
+			"topic",
+			true,  // durable
+			false, // auto-deleted
+			false, // internal
+			false, // no-wait
+			nil,
+		)
+		if err != nil {
+			return fmt.Errorf("declare exchange: %w", err)
+		}
+		return nil
+	}
+}
+
+// connect opens a dedicated channel for RPCs on the managed connection.
+func (e *Emitter) connect() error {
+	ch, err := e.manager.Channel()
 	if err != nil {
-		return fmt.Errorf("open channel: %w", err)
+		return err
 	}
 
-	err = ch.ExchangeDeclare(
-		e.exchange,
-		"topic",
-		true,  // durable
-		false, // auto-deleted
-		false, // internal
+	if err := e.setup(ch); err != nil {
+		ch.Close()
+		return err
+	}
+	return nil
+}
+
+func (e *Emitter) setup(ch Channel) error {
+	// Confirms tell a publish the broker has the request; mandatory
+	// publishing makes it hand back requests no queue is bound for, so
+	// they fail at once instead of running into the RPC timeout
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("enable publisher confirms: %w", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 16))
+
+	// One long-lived consumer receives every RPC reply; direct reply-to
+	// requires auto-ack and publishing on the same channel.
+	replies, err := ch.Consume(
+		directReplyQueue,
+		"",    // consumer tag
+		true,  // auto-ack
+		false, // exclusive
+		false, // no-local
 		false, // no-wait
 		nil,
 	)
 	if err != nil {
-		ch.Close()
-		return fmt.Errorf("declare exchange: %w", err)
+		return fmt.Errorf("consume replies: %w", err)
 	}
 
+	e.mu.Lock()
 	e.channel = ch
+	e.generation++
+	generation := e.generation
+	e.mu.Unlock()
+
+	go e.dispatchReplies(ch, generation, replies)
+	go e.dispatchReturns(returns)
 	return nil
 }
 
+// dispatchReturns hands returned requests to their waiters. It stops when
+// the channel closes, which closes returns.
+func (e *Emitter) dispatchReturns(returns <-chan amqp.Return) {
+	for ret := range returns {
+		e.pendingMu.Lock()
+		w, ok := e.pending[ret.CorrelationId]
+		if ok {
+			delete(e.pending, ret.CorrelationId)
+		}
+		e.pendingMu.Unlock()
+
+		if ok {
+			w.returned <- ret
+		}
+	}
+}
+
+// dispatchReplies routes each reply to the waiter registered under its
+// correlation ID. Replies nobody is waiting for (late or duplicated) are
+// dropped.
+func (e *Emitter) dispatchReplies(ch Channel, generation uint64, replies <-chan amqp.Delivery) {
+	for msg := range replies {
+		e.pendingMu.Lock()
+		w, ok := e.pending[msg.CorrelationId]
+		if ok {
+			delete(e.pending, msg.CorrelationId)
+		}
+		e.pendingMu.Unlock()
+
+		if ok {
+			w.reply <- msg
+		} else {
+			rpcRepliesDiscarded.Inc()
+		}
+	}
+
+	// The channel is gone. Until a reconnect installs a new one, publishes
+	// fail fast and outstanding requests are released instead of waiting
+	// for their timeout.
+	e.mu.Lock()
+	if e.channel == ch {
+		e.channel = nil
+	}
+	e.mu.Unlock()
+
+	e.pendingMu.Lock()
+	if e.manager == nil {
+		e.closed = true
+	}
+	for id, w := range e.pending {
+		// Requests published after a reconnect belong to the new channel
+		if w.generation != generation {
+			continue
+		}
+		close(w.reply)
+		delete(e.pending, id)
+	}
+	e.pendingMu.Unlock()
+}
+
+// register adds a waiter for correlationID. It receives exactly one reply
+// or return, or its reply channel is closed if the reply consumer stops.
+func (e *Emitter) register(correlationID string) (*waiter, error) {
+	e.mu.RLock()
+	generation := e.generation
+	e.mu.RUnlock()
+
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+
+	if e.closed {
+		return nil, ErrEmitterClosed
+	}
+
+	w := &waiter{
+		reply:      make(chan amqp.Delivery, 1),
+		returned:   make(chan amqp.Return, 1),
+		generation: generation,
+	}
+	e.pending[correlationID] = w
+	return w, nil
+}
+
+func (e *Emitter) unregister(correlationID string) {
+	e.pendingMu.Lock()
+	delete(e.pending, correlationID)
+	e.pendingMu.Unlock()
+}
+
+// Connected reports whether the reply consumer is up, so RPCs can be
+// published.
+func (e *Emitter) Connected() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.channel != nil
+}
+
+// Drain refuses new RPCs and waits until the outstanding ones got their
+// reply or ctx ends. It returns how many were still waiting then.
+func (e *Emitter) Drain(ctx context.Context) int {
+	e.pendingMu.Lock()
+	e.closed = true
+	e.pendingMu.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		n := e.Pending()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return n
+		}
+	}
+}
+
+// Pending returns the number of RPCs waiting for a reply.
+func (e *Emitter) Pending() int {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+	return len(e.pending)
+}
+
+// publish sends msg as mandatory and waits for the broker's confirm,
+// retrying nacks. A return for the message reaches its waiter, if any;
+// without a correlated waiter nobody learns the message was dropped.
+func (e *Emitter) publish(ctx context.Context, key string, msg amqp.Publishing) error {
+	return rabbit.RetryNacked(ctx, publishAttempts, func() (bool, error) {
+		confirm, err := e.publishOnce(ctx, key, msg)
+		if err != nil {
+			return false, err
+		}
+		if confirm == nil {
+			// Channel not in confirm mode
+			return true, nil
+		}
+		return confirm.WaitContext(ctx)
+	})
+}
+
+func (e *Emitter) publishOnce(ctx context.Context, key string, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	e.mu.RLock()
+	ch := e.channel
+	e.mu.RUnlock()
+
+	if ch == nil {
+		return nil, rabbit.ErrNotConnected
+	}
+
+	// Only the publish is serialized; confirms are awaited concurrently
+	e.publishMu.Lock()
+	defer e.publishMu.Unlock()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		e.exchange,
+		key,   // routing key = topic
+		true,  // mandatory
+		false, // immediate
+		msg,
+	)
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil, fmt.Errorf("%w: %v", rabbit.ErrNotConnected, err)
+	}
+	return confirm, err
+}
+
 func (e *Emitter) Close() error {
+	e.pendingMu.Lock()
+	e.closed = true
+	e.pendingMu.Unlock()
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	if e.channel != nil {
-		return e.channel.Close()
+		err := e.channel.Close()
+		e.channel = nil
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,152 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// jobReplyPrefix names the queues receiving the replies to asynchronous
+// commands, one per broker replica. Unlike RPC replies they are real
+// queues, so replies survive a restart or a reconnect while the command is
+// still running, and each reply reaches the replica that accepted the job.
+const jobReplyPrefix = "broker_job_replies."
+
+// jobReplyExpiry deletes the reply queue of a replica that has been gone
+// this long; by then its jobs have expired too.
+const jobReplyExpiry = 24 * time.Hour
+
+// JobReplyQueue returns the job reply queue of the named replica.
+func JobReplyQueue(replica string) string {
+	return jobReplyPrefix + replica
+}
+
+// PushJob publishes an event whose reply is delivered to the queue of
+// ConsumeJobReplies, correlated by jobID, instead of being waited for. It returns once the
+// broker confirmed the event, or rabbit.ErrUnroutable if no queue is bound
+// for the topic.
+func (e *Emitter) PushJob(ctx context.Context, payload contracts.TopicPayload, jobID string) error {
+	body, err := json.Marshal(payload.Event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	msg := amqp.Publishing{
+		ContentType:   "application/json",
+		MessageId:     messageIDFrom(ctx),
+		Headers:       rabbit.InjectClientIP(ctx, rabbit.InjectTrace(ctx, nil)),
+		CorrelationId: jobID,
+		ReplyTo:       e.jobReplyQueue(),
+		Body:          body,
+	}
+
+	if e.manager != nil {
+		return e.manager.PublishConfirmed(ctx, e.exchange, payload.Name, msg)
+	}
+	return e.publish(ctx, payload.Name, msg)
+}
+
+// ConsumeJobReplies declares queue, names it as the reply queue of the
+// jobs pushed from now on, and calls fn for every reply until ctx ends,
+// resubscribing after connection losses. It needs a managed connection.
+func (e *Emitter) ConsumeJobReplies(ctx context.Context, queue string, fn func(jobID string, reply *contracts.Reply)) error {
+	if e.manager == nil {
+		return fmt.Errorf("job replies require a managed connection")
+	}
+
+	err := e.manager.DeclareTopology(func(ch rabbit.Declarer) error {
+		_, err := ch.QueueDeclare(
+			queue,
+			true,  // durable
+			false, // auto-delete
+			false, // exclusive
+			false, // no-wait
+			amqp.Table{"x-expires": jobReplyExpiry.Milliseconds()},
+		)
+		if err != nil {
+			return fmt.Errorf("declare job reply queue: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.jobReplies = queue
+	e.mu.Unlock()
+
+	go func() {
+		for {
+			err := e.consumeJobReplies(ctx, queue, fn)
+			if ctx.Err() != nil || errors.Is(err, rabbit.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("Job reply consumer stopped: %v", err)
+			}
+
+			time.Sleep(time.Second)
+			if err := e.manager.WaitConnected(ctx); err != nil {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (e *Emitter) consumeJobReplies(ctx context.Context, queue string, fn func(string, *contracts.Reply)) error {
+	ch, err := e.manager.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	msgs, err := ch.Consume(
+		queue,
+		"",    // consumer tag
+		false, // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("consume job replies: %w", err)
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+
+			reply, err := decodeReply(msg)
+			if err != nil {
+				log.Printf("Dropping malformed job reply %s: %v", msg.CorrelationId, err)
+				msg.Nack(false, false)
+				continue
+			}
+
+			fn(msg.CorrelationId, reply)
+			msg.Ack(false)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (e *Emitter) jobReplyQueue() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.jobReplies
+}
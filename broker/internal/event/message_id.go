@@ -0,0 +1,16 @@
+package event
+
+import "context"
+
+type messageIDKey struct{}
+
+// WithMessageID returns a context whose published events carry id as their
+// AMQP MessageId, letting listeners recognize redelivered commands.
+func WithMessageID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, messageIDKey{}, id)
+}
+
+func messageIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(messageIDKey{}).(string)
+	return id
+}
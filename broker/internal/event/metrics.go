@@ -0,0 +1,52 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "broker_rpc_duration_seconds",
+		Help:    "Time from publishing an RPC until it completed, by topic and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic", "outcome"})
+
+	rpcTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_rpc_timeouts_total",
+		Help: "RPCs that got no reply before their deadline, by topic.",
+	}, []string{"topic"})
+
+	rpcHedges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_rpc_hedges_total",
+		Help: "Second copies of slow idempotent RPCs, by topic.",
+	}, []string{"topic"})
+
+	rpcRepliesDiscarded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "broker_rpc_replies_discarded_total",
+		Help: "Replies whose correlation ID nobody waited for anymore: duplicates of hedged or redelivered requests, or late replies.",
+	})
+)
+
+// observeRPC records the outcome of one Push.
+func observeRPC(topic string, start time.Time, err error) {
+	outcome := "ok"
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrTimeout):
+		outcome = "timeout"
+		rpcTimeouts.WithLabelValues(topic).Inc()
+	case errors.Is(err, rabbit.ErrUnroutable):
+		outcome = "unroutable"
+	case errors.Is(err, context.Canceled):
+		outcome = "canceled"
+	default:
+		outcome = "error"
+	}
+	rpcDuration.WithLabelValues(topic, outcome).Observe(time.Since(start).Seconds())
+}
@@ -4,130 +4,141 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
 	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// Push sends an event to RabbitMQ and waits for a response
+// Push sends an event to RabbitMQ and writes the reply as the HTTP
+// response. See Call for how long it waits.
 func (e *Emitter) Push(ctx context.Context, w http.ResponseWriter, payload contracts.TopicPayload) error {
-	e.mu.RLock()
-	ch := e.channel
-	e.mu.RUnlock()
-
-	if ch == nil {
-		return fmt.Errorf("channel not initialized")
+	reply, err := e.Call(ctx, payload)
+	if err != nil {
+		return err
 	}
+	return WriteReply(w, reply)
+}
+
+// Call sends an event to RabbitMQ and waits for the reply. The wait is
+// bounded by the context's deadline, or the emitter's default RPC timeout
+// when the context has none; the deadline travels with the event so the
+// consumer can honour it too. An event no queue is bound for fails at once
+// with rabbit.ErrUnroutable.
+func (e *Emitter) Call(ctx context.Context, payload contracts.TopicPayload) (*contracts.Reply, error) {
+	return e.CallHedged(ctx, payload, Hedge{})
+}
+
+// Hedge configures the hedged request of CallHedged.
+type Hedge struct {
+	After time.Duration // send a copy when no reply came by then; 0 never does
+	Allow func() bool   // asked right before sending, e.g. to charge a budget; nil allows
+}
+
+// CallHedged is Call for idempotent events. When no reply came within
+// hedge.After, it publishes a second copy of the request, which another
+// consumer may answer sooner than a stalled one. Both copies carry the same
+// correlation ID: the first reply completes the call and the other one is
+// discarded like any reply nobody waits for.
+func (e *Emitter) CallHedged(ctx context.Context, payload contracts.TopicPayload, hedge Hedge) (reply *contracts.Reply, err error) {
+	start := time.Now()
+	defer func() { observeRPC(payload.Name, start, err) }()
 
 	body, err := json.Marshal(payload.Event)
 	if err != nil {
-		return fmt.Errorf("marshal event: %w", err)
+		return nil, fmt.Errorf("marshal event: %w", err)
 	}
 
-	// Declare exclusive reply queue
-	replyQueue, err := ch.QueueDeclare(
-		"",    // auto-generate name
-		false, // durable
-		true,  // auto-delete
-		true,  // exclusive
-		false, // no-wait
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("declare reply queue: %w", err)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
 	}
 
 	correlationID := uuid.New().String()
 
-	// Set up consumer before publishing to avoid race
-	replyChan := make(chan []byte, 1)
-	errChan := make(chan error, 1)
-
-	go func() {
-		msgs, err := ch.Consume(
-			replyQueue.Name,
-			"",    // consumer tag
-			true,  // auto-ack
-			true,  // exclusive
-			false, // no-local
-			false, // no-wait
-			nil,
-		)
-		if err != nil {
-			errChan <- fmt.Errorf("consume reply: %w", err)
-			return
-		}
-
-		for msg := range msgs {
-			if msg.CorrelationId == correlationID {
-				replyChan <- msg.Body
-				return
-			}
-		}
-	}()
-
-	// Publish message
-	err = ch.PublishWithContext(
-		ctx,
-		e.exchange,
-		payload.Name, // routing key = topic
-		false,        // mandatory
-		false,        // immediate
-		amqp.Publishing{
-			ContentType:   "application/json",
-			CorrelationId: correlationID,
-			ReplyTo:       replyQueue.Name,
-			Body:          body,
-		},
-	)
+	// Register before publishing so a fast reply cannot be missed
+	waiter, err := e.register(correlationID)
 	if err != nil {
-		return fmt.Errorf("publish: %w", err)
+		return nil, err
+	}
+	defer e.unregister(correlationID)
+
+	msg := amqp.Publishing{
+		ContentType:   "application/json",
+		MessageId:     messageIDFrom(ctx),
+		Headers:       rabbit.InjectClientIP(ctx, rabbit.InjectDeadline(ctx, rabbit.InjectTrace(ctx, nil))),
+		CorrelationId: correlationID,
+		ReplyTo:       directReplyQueue,
+		Body:          body,
+	}
+	if err := e.publish(ctx, payload.Name, msg); err != nil {
+		return nil, fmt.Errorf("publish: %w", err)
 	}
 
-	// Wait for response with timeout
-	timeout := 30 * time.Second
-	select {
-	case response := <-replyChan:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, err = w.Write(response)
-		return err
-	case err := <-errChan:
-		return err
-	case <-time.After(timeout):
-		return fmt.Errorf("timeout waiting for response")
-	case <-ctx.Done():
-		return ctx.Err()
+	var hedgeAfter <-chan time.Time
+	if hedge.After > 0 {
+		timer := time.NewTimer(hedge.After)
+		defer timer.Stop()
+		hedgeAfter = timer.C
+	}
+
+	for {
+		select {
+		case <-hedgeAfter:
+			hedgeAfter = nil
+			if hedge.Allow != nil && !hedge.Allow() {
+				continue
+			}
+			rpcHedges.WithLabelValues(payload.Name).Inc()
+			// The first copy may still be answered, so keep waiting while
+			// the second one is confirmed
+			go func() {
+				if err := e.publish(ctx, payload.Name, msg); err != nil && ctx.Err() == nil {
+					log.Printf("Emitter: publish hedged %s: %v", payload.Name, err)
+				}
+			}()
+		case ret := <-waiter.returned:
+			return nil, rabbit.UnroutableError(ret)
+		case msg, ok := <-waiter.reply:
+			if !ok {
+				if e.manager == nil {
+					return nil, fmt.Errorf("reply consumer stopped: %w", ErrEmitterClosed)
+				}
+				return nil, fmt.Errorf("%w: connection lost while waiting for reply", rabbit.ErrNotConnected)
+			}
+			return decodeReply(msg)
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimeout
+			}
+			return nil, ctx.Err()
+		}
 	}
 }
 
 // PushAsync sends an event without waiting for response (fire-and-forget)
 func (e *Emitter) PushAsync(ctx context.Context, payload contracts.TopicPayload) error {
-	e.mu.RLock()
-	ch := e.channel
-	e.mu.RUnlock()
-
-	if ch == nil {
-		return fmt.Errorf("channel not initialized")
-	}
-
 	body, err := json.Marshal(payload.Event)
 	if err != nil {
 		return fmt.Errorf("marshal event: %w", err)
 	}
 
-	return ch.PublishWithContext(
-		ctx,
-		e.exchange,
-		payload.Name,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
-	)
+	msg := amqp.Publishing{
+		ContentType: "application/json",
+		MessageId:   messageIDFrom(ctx),
+		Headers:     rabbit.InjectClientIP(ctx, rabbit.InjectTrace(ctx, nil)),
+		Body:        body,
+	}
+
+	// Fire-and-forget publishes don't need the reply channel, so they use
+	// the pool and don't contend with RPCs for its publish lock
+	if e.manager != nil {
+		return e.manager.PublishConfirmed(ctx, e.exchange, payload.Name, msg)
+	}
+	return e.publish(ctx, payload.Name, msg)
 }
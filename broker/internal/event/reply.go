@@ -0,0 +1,72 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// decodeReply extracts the reply envelope from a listener message. Reply
+// envelopes keep the downstream status, headers and typed error; anything
+// else is an untyped body from an older listener and is wrapped as a 200
+// reply.
+func decodeReply(msg amqp.Delivery) (*contracts.Reply, error) {
+	if msg.Type != contracts.ReplyMessageType {
+		return &contracts.Reply{Status: http.StatusOK, Body: msg.Body}, nil
+	}
+
+	var reply contracts.Reply
+	if err := json.Unmarshal(msg.Body, &reply); err != nil {
+		return nil, fmt.Errorf("decode reply: %w", err)
+	}
+	return &reply, nil
+}
+
+// WriteReply writes a decoded reply envelope as an HTTP response. Error
+// replies are answered as problem details, like every error the broker
+// answers with.
+func WriteReply(w http.ResponseWriter, reply *contracts.Reply) error {
+	for name, value := range reply.Headers {
+		w.Header().Set(name, value)
+	}
+
+	status := reply.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if reply.Error != nil {
+		apiErr := *reply.Error
+		apiErr.Status = status
+		if status < 400 {
+			apiErr.Status = http.StatusBadGateway
+		}
+		return WriteProblem(w, &apiErr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if len(reply.Body) == 0 || status == http.StatusNoContent || status == http.StatusNotModified {
+		return nil
+	}
+	_, err := w.Write(reply.Body)
+	return err
+}
+
+// WriteProblem answers with apiErr as problem details (RFC 9457), the one
+// error format of the broker's API.
+func WriteProblem(w http.ResponseWriter, apiErr *contracts.Error) error {
+	body, err := json.Marshal(apiErr.ToProblem())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return fmt.Errorf("encode problem: %w", err)
+	}
+
+	w.Header().Set("Content-Type", contracts.ProblemContentType)
+	w.WriteHeader(apiErr.Status)
+	_, err = w.Write(body)
+	return err
+}
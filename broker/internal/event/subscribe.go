@@ -0,0 +1,142 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const resubscribeDelay = time.Second
+
+// Subscription delivers events published on the exchange under a set of
+// topic patterns to a callback. It consumes from a private, server-named
+// queue, so every broker replica sees every event, and rebinds the queue
+// after reconnects.
+type Subscription struct {
+	emitter *Emitter
+	fn      func(amqp.Delivery)
+
+	mu     sync.Mutex
+	topics []string
+	ch     *amqp.Channel // nil while disconnected
+	queue  string
+}
+
+// Subscribe starts delivering events matching topics to fn until ctx ends.
+// It needs a managed connection.
+func (e *Emitter) Subscribe(ctx context.Context, topics []string, fn func(amqp.Delivery)) (*Subscription, error) {
+	if e.manager == nil {
+		return nil, fmt.Errorf("subscriptions require a managed connection")
+	}
+
+	s := &Subscription{emitter: e, fn: fn, topics: slices.Clone(topics)}
+	go s.run(ctx)
+	return s, nil
+}
+
+// SetTopics changes the topic patterns, rebinding the live queue at once.
+func (s *Subscription) SetTopics(topics []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.topics
+	s.topics = slices.Clone(topics)
+	if s.ch == nil {
+		// Applied on the next (re)subscribe
+		return nil
+	}
+
+	var errs []error
+	for _, topic := range topics {
+		if !slices.Contains(old, topic) {
+			errs = append(errs, s.ch.QueueBind(s.queue, topic, s.emitter.exchange, false, nil))
+		}
+	}
+	for _, topic := range old {
+		if !slices.Contains(topics, topic) {
+			errs = append(errs, s.ch.QueueUnbind(s.queue, topic, s.emitter.exchange, nil))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Subscription) run(ctx context.Context) {
+	manager := s.emitter.manager
+	for {
+		err := s.consume(ctx)
+		if ctx.Err() != nil || errors.Is(err, rabbit.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Subscription stopped: %v", err)
+		}
+
+		time.Sleep(resubscribeDelay)
+		if err := manager.WaitConnected(ctx); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Subscription) consume(ctx context.Context) error {
+	ch, err := s.emitter.manager.Channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		s.mu.Lock()
+		s.ch = nil
+		s.mu.Unlock()
+		ch.Close()
+	}()
+
+	msgs, err := s.bind(ch)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			s.fn(msg)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Subscription) bind(ch *amqp.Channel) (<-chan amqp.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Server-named, exclusive and auto-deleted: the queue goes away with
+	// the channel and is declared afresh after a reconnect
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("declare subscription queue: %w", err)
+	}
+	for _, topic := range s.topics {
+		if err := ch.QueueBind(q.Name, topic, s.emitter.exchange, false, nil); err != nil {
+			return nil, fmt.Errorf("bind subscription queue to %s: %w", topic, err)
+		}
+	}
+
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("consume subscription queue: %w", err)
+	}
+
+	s.ch = ch
+	s.queue = q.Name
+	return msgs, nil
+}
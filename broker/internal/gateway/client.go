@@ -0,0 +1,198 @@
+package gateway
+
+import (
+	"encoding/json"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// maxClientMessage bounds what clients may send; they only send filters.
+const maxClientMessage = 4096
+
+// clientMessage is a filter update sent by the client, e.g.
+//
+//	{"action": "subscribe", "topics": ["order", "auth.*"]}
+//
+// Patterns match either the message type or its topic using path.Match
+// syntax. A client without patterns receives everything.
+type clientMessage struct {
+	Action string   `json:"action"` // subscribe | unsubscribe
+	Topics []string `json:"topics"`
+}
+
+type client struct {
+	id        string
+	hub       *Hub
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	connected time.Time
+
+	mu      sync.RWMutex
+	filters map[string]bool
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	strikes atomic.Uint64 // drops since the last delivered message
+}
+
+func newClient(h *Hub, conn *websocket.Conn) *client {
+	return &client{
+		id:        uuid.New().String(),
+		hub:       h,
+		conn:      conn,
+		send:      make(chan []byte, sendBuffer),
+		done:      make(chan struct{}),
+		connected: time.Now().UTC(),
+		filters:   make(map[string]bool),
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *client) matches(msg Message) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.filters) == 0 {
+		return true
+	}
+	for pattern := range c.filters {
+		if ok, _ := path.Match(pattern, msg.Type); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, msg.Topic); ok && msg.Topic != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// readPump applies filter updates and keeps the read deadline moving with
+// each pong. It returns when the connection fails or closes.
+func (c *client) readPump() {
+	c.conn.SetReadLimit(maxClientMessage)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var msg clientMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				c.hub.deliver(c, c.errorMessage("Messages must be JSON"))
+				continue
+			}
+			return
+		}
+
+		switch msg.Action {
+		case "subscribe":
+			c.setFilters(msg.Topics, true)
+		case "unsubscribe":
+			c.setFilters(msg.Topics, false)
+		default:
+			c.hub.deliver(c, c.errorMessage("Unknown action "+msg.Action))
+			continue
+		}
+		c.hub.deliver(c, c.statsMessage(c.hub.Stats().Clients))
+	}
+}
+
+func (c *client) setFilters(patterns []string, add bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			continue
+		}
+		if add {
+			c.filters[p] = true
+		} else {
+			delete(c.filters, p)
+		}
+	}
+}
+
+// writePump is the connection's only writer: it drains the send queue and
+// pings the client so dead peers are noticed.
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+			return
+		}
+	}
+}
+
+// statsMessage describes this client's connection for the dashboard.
+func (c *client) statsMessage(clients int) []byte {
+	c.mu.RLock()
+	filters := make([]string, 0, len(c.filters))
+	for p := range c.filters {
+		filters = append(filters, p)
+	}
+	c.mu.RUnlock()
+
+	return c.encode(Message{
+		Type: TypeSystem,
+		Data: mustJSON(map[string]any{
+			"event":        "stats",
+			"client_id":    c.id,
+			"connected_at": c.connected,
+			"clients":      clients,
+			"sent":         c.sent.Load(),
+			"dropped":      c.dropped.Load(),
+			"filters":      filters,
+		}),
+	})
+}
+
+func (c *client) errorMessage(text string) []byte {
+	return c.encode(Message{
+		Type: TypeError,
+		Data: mustJSON(map[string]string{"message": text}),
+	})
+}
+
+func (c *client) encode(msg Message) []byte {
+	msg.Timestamp = time.Now().UTC()
+	data, _ := json.Marshal(msg)
+	return data
+}
+
+func mustJSON(v any) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
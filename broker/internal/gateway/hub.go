@@ -0,0 +1,209 @@
+// Package gateway fans out RabbitMQ events and service logs to WebSocket
+// clients such as the dashboard's monitoring page.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message types understood by the dashboard.
+const (
+	TypeOrder   = "order"
+	TypeUser    = "user"
+	TypeProduct = "product"
+	TypeSystem  = "system"
+	TypeError   = "error"
+)
+
+const (
+	sendBuffer    = 256              // messages queued per client before dropping
+	maxDrops      = 1024             // consecutive drops before a slow client is cut off
+	writeWait     = 10 * time.Second // time allowed to write one frame
+	pongWait      = 60 * time.Second // time allowed between pongs
+	pingPeriod    = pongWait * 9 / 10
+	statsInterval = 30 * time.Second
+)
+
+// Message is one event delivered to clients.
+type Message struct {
+	Type      string          `json:"type"`
+	Topic     string          `json:"topic,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Stats summarizes the hub's traffic.
+type Stats struct {
+	Clients int    `json:"clients"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Hub tracks connected clients and broadcasts messages to them. Each client
+// has its own bounded queue, so a slow reader loses messages instead of
+// stalling the sources or other clients.
+type Hub struct {
+	mu       sync.RWMutex
+	clients  map[*client]struct{}
+	upgrader websocket.Upgrader
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// NewHub returns a hub accepting connections from pages served by the
+// broker's own origin or one of origins (e.g. "https://admin.example.com").
+func NewHub(origins []string) *Hub {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+
+	return &Hub{
+		clients: make(map[*client]struct{}),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 4096,
+			CheckOrigin:     checkOrigin(allowed),
+		},
+	}
+}
+
+// checkOrigin admits handshakes from allowed origins and the broker's
+// own. CORS does not apply to WebSocket handshakes, so this is what keeps
+// other sites' pages from connecting with a visitor's credentials.
+// Clients that send no Origin are not browsers and are admitted.
+func checkOrigin(allowed map[string]bool) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host) || allowed[strings.ToLower(u.Scheme+"://"+u.Host)]
+	}
+}
+
+// Broadcast queues msg for every client whose filters match it.
+func (h *Hub) Broadcast(msg Message) {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now().UTC()
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Gateway: failed to encode message: %v", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.clients {
+		if c.matches(msg) {
+			h.deliver(c, data)
+		}
+	}
+}
+
+func (h *Hub) deliver(c *client, data []byte) {
+	select {
+	case c.send <- data:
+		h.sent.Add(1)
+		c.sent.Add(1)
+		c.strikes.Store(0)
+	default:
+		h.dropped.Add(1)
+		c.dropped.Add(1)
+		if c.strikes.Add(1) >= maxDrops {
+			c.close()
+		}
+	}
+}
+
+// Stats reports the hub's current traffic.
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	clients := len(h.clients)
+	h.mu.RUnlock()
+
+	return Stats{
+		Clients: clients,
+		Sent:    h.sent.Load(),
+		Dropped: h.dropped.Load(),
+	}
+}
+
+// Run periodically sends each client its connection stats until ctx ends.
+func (h *Hub) Run(ctx context.Context) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.sendStats()
+		case <-ctx.Done():
+			h.mu.RLock()
+			for c := range h.clients {
+				c.close()
+			}
+			h.mu.RUnlock()
+			return
+		}
+	}
+}
+
+func (h *Hub) sendStats() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.clients {
+		h.deliver(c, c.statsMessage(len(h.clients)))
+	}
+}
+
+// ServeWS upgrades the request and serves the client until it disconnects.
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already answered the client
+		return
+	}
+
+	c := newClient(h, conn)
+	h.register(c)
+	defer h.unregister(c)
+
+	go c.writePump()
+	c.readPump()
+}
+
+func (h *Hub) register(c *client) {
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	clients := len(h.clients)
+	h.mu.Unlock()
+
+	h.deliver(c, c.statsMessage(clients))
+}
+
+func (h *Hub) unregister(c *client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+
+	c.close()
+}
@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientFilters(t *testing.T) {
+	c := newClient(NewHub(nil), nil)
+
+	order := Message{Type: TypeOrder, Topic: "order.created"}
+	user := Message{Type: TypeUser, Topic: "auth.registered"}
+	system := Message{Type: TypeSystem}
+
+	if !c.matches(order) || !c.matches(system) {
+		t.Fatal("client without filters must receive everything")
+	}
+
+	c.setFilters([]string{"auth.*", "[", TypeSystem}, true)
+	for _, tc := range []struct {
+		msg  Message
+		want bool
+	}{
+		{order, false},
+		{user, true}, // by topic
+		{system, true},
+		{Message{Type: TypeError, Topic: ""}, false},
+	} {
+		if got := c.matches(tc.msg); got != tc.want {
+			t.Errorf("matches(%+v) = %v, want %v", tc.msg, got, tc.want)
+		}
+	}
+	if c.filters["["] {
+		t.Error("malformed pattern was kept")
+	}
+
+	c.setFilters([]string{"auth.*"}, false)
+	if c.matches(user) {
+		t.Error("unsubscribed pattern still matches")
+	}
+}
+
+func TestSlowClientLosesMessages(t *testing.T) {
+	h := NewHub(nil)
+	c := newClient(h, nil) // nobody drains c.send
+	h.clients[c] = struct{}{}
+
+	for range sendBuffer + 10 {
+		h.Broadcast(Message{Type: TypeOrder})
+	}
+
+	s := h.Stats()
+	if s.Sent != sendBuffer || s.Dropped != 10 {
+		t.Errorf("stats = %+v, want %d sent and 10 dropped", s, sendBuffer)
+	}
+	if c.dropped.Load() != 10 {
+		t.Errorf("client dropped %d, want 10", c.dropped.Load())
+	}
+	select {
+	case <-c.done:
+		t.Fatal("client cut off before maxDrops")
+	default:
+	}
+
+	// Other clients keep receiving
+	fast := newClient(h, nil)
+	h.clients[fast] = struct{}{}
+	h.Broadcast(Message{Type: TypeOrder})
+	if len(fast.send) != 1 {
+		t.Errorf("fast client got %d messages, want 1", len(fast.send))
+	}
+}
+
+func TestSlowClientIsCutOff(t *testing.T) {
+	h := NewHub(nil)
+	c := newClient(h, nil)
+	h.clients[c] = struct{}{}
+
+	for range sendBuffer + maxDrops - 1 {
+		h.Broadcast(Message{Type: TypeOrder})
+	}
+	select {
+	case <-c.done:
+		t.Fatal("client cut off before maxDrops")
+	default:
+	}
+
+	h.Broadcast(Message{Type: TypeOrder})
+	select {
+	case <-c.done:
+	default:
+		t.Fatalf("client still connected after %d consecutive drops", maxDrops)
+	}
+}
+
+func TestDeliveryResetsStrikes(t *testing.T) {
+	h := NewHub(nil)
+	c := newClient(h, nil)
+	h.clients[c] = struct{}{}
+
+	for range sendBuffer + maxDrops - 1 {
+		h.Broadcast(Message{Type: TypeOrder})
+	}
+	<-c.send // the client catches up a little
+	for range maxDrops - 1 {
+		h.Broadcast(Message{Type: TypeOrder})
+	}
+	select {
+	case <-c.done:
+		t.Fatal("drops before the last delivery were counted")
+	default:
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	h := NewHub([]string{"https://admin.example.com/"})
+	srv := httptest.NewServer(http.HandlerFunc(h.ServeWS))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	for _, tc := range []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols}, // not a browser
+		{srv.URL, http.StatusSwitchingProtocols},
+		{"https://admin.example.com", http.StatusSwitchingProtocols},
+		{"https://ADMIN.example.com", http.StatusSwitchingProtocols},
+		{"http://admin.example.com", http.StatusForbidden},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	} {
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("origin %q: %v", tc.origin, err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("origin %q: status %d, want %d", tc.origin, resp.StatusCode, tc.want)
+		}
+	}
+}
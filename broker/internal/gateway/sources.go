@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/kafka-go"
+)
+
+const resubscribeDelay = time.Second
+
+// Event broadcasts an event delivered from the exchange; pass it to
+// Emitter.Subscribe with the topics to stream. Clients get a projection
+// of the event, never its body, so nothing but identifiers and states can
+// reach them even if a topic carrying credentials is streamed by mistake.
+func (h *Hub) Event(msg amqp.Delivery) {
+	h.Broadcast(eventMessage(msg))
+}
+
+func eventMessage(msg amqp.Delivery) Message {
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now().UTC()
+	}
+
+	return Message{
+		Type:      typeForTopic(msg.RoutingKey),
+		Topic:     msg.RoutingKey,
+		Timestamp: timestamp,
+		Data:      project(msg.RoutingKey, msg.Body),
+	}
+}
+
+// typeForTopic maps a routing key onto a dashboard message type by its
+// first segment.
+func typeForTopic(topic string) string {
+	domain, _, _ := strings.Cut(topic, ".")
+	switch domain {
+	case "order", "orders":
+		return TypeOrder
+	case "product", "products", "catalog":
+		return TypeProduct
+	case "auth", "user", "users":
+		return TypeUser
+	default:
+		return TypeSystem
+	}
+}
+
+// project reduces an event to what the dashboard shows: its name and
+// topic, plus the ids and status among its data. Only scalar fields named
+// id, status or ending in _id are kept.
+func project(topic string, body []byte) json.RawMessage {
+	var payload struct {
+		Name string                     `json:"name"`
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	json.Unmarshal(body, &payload)
+
+	out := map[string]any{"topic": topic}
+	if payload.Name != "" {
+		out["event"] = payload.Name
+	}
+	for key, raw := range payload.Data {
+		if key != "id" && key != "status" && !strings.HasSuffix(key, "_id") {
+			continue
+		}
+		var v any
+		if json.Unmarshal(raw, &v) != nil {
+			continue
+		}
+		switch v.(type) {
+		case string, float64, bool:
+			out[key] = v
+		}
+	}
+	return mustJSON(out)
+}
+
+// LogsConfig selects the Kafka log topic streamed to clients.
+type LogsConfig struct {
+	Brokers []string
+	Topic   string
+	GroupID string // unique per broker replica, so each sees every entry
+}
+
+// SubscribeLogs broadcasts entries from the Kafka log topic, starting at
+// the newest offset, until ctx ends. ERROR and FATAL entries are sent as
+// error messages, everything else as system messages.
+func SubscribeLogs(ctx context.Context, h *Hub, cfg LogsConfig) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.Brokers,
+		Topic:       cfg.Topic,
+		GroupID:     cfg.GroupID,
+		StartOffset: kafka.LastOffset,
+		MaxWait:     time.Second,
+	})
+	defer reader.Close()
+
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Gateway log subscription: %v", err)
+			time.Sleep(resubscribeDelay)
+			continue
+		}
+
+		var entry contracts.LogEntry
+		if err := json.Unmarshal(msg.Value, &entry); err != nil {
+			continue
+		}
+
+		msgType := TypeSystem
+		if entry.Level == contracts.LogError || entry.Level == contracts.LogFatal {
+			msgType = TypeError
+		}
+
+		h.Broadcast(Message{
+			Type:      msgType,
+			Topic:     cfg.Topic,
+			Timestamp: entry.Timestamp,
+			Data:      msg.Value,
+		})
+	}
+}
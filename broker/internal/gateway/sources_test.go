@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestEventsAreProjected(t *testing.T) {
+	tests := []struct {
+		name, topic, body string
+		want              map[string]any
+	}{
+		{
+			"domain event", "user.updated",
+			`{"name":"user.updated","data":{"id":"u1","status":"active","order_id":7,"email":"a@example.com"}}`,
+			map[string]any{"topic": "user.updated", "event": "user.updated", "id": "u1", "status": "active", "order_id": float64(7)},
+		},
+		{
+			// A command streamed by mistake shows up without its data
+			"credentials", "auth.login",
+			`{"name":"login","data":{"email":"a@example.com","password":"hunter2","challenge":"x"}}`,
+			map[string]any{"topic": "auth.login", "event": "login"},
+		},
+		{
+			"nested values", "order.created",
+			`{"name":"order.created","data":{"id":{"secret":"x"},"status":["a"],"customer_id":null}}`,
+			map[string]any{"topic": "order.created", "event": "order.created"},
+		},
+		{"not an event", "order.created", `"plain text"`, map[string]any{"topic": "order.created"}},
+		{"not JSON", "order.created", `password=hunter2`, map[string]any{"topic": "order.created"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := eventMessage(amqp.Delivery{RoutingKey: tt.topic, Body: []byte(tt.body)})
+
+			var got map[string]any
+			if err := json.Unmarshal(msg.Data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("data %v, want %v", got, tt.want)
+			}
+			if msg.Timestamp.IsZero() {
+				t.Error("no timestamp")
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/ast"
+)
+
+// costResolver is a second binding of the schema whose root fields only
+// add up what the query asks for and resolve to nothing, so a query can be
+// costed by executing it: graphql-go parses and validates it, flattens
+// fragments, applies argument defaults and substitutes variables, and no
+// RPC is made.
+//
+// Every field costs 1 and list fields multiply the cost of their
+// selections by the page size they may return, taken from their first
+// argument (or its default) and defaultList otherwise. Each root field is
+// costed separately, aliases included; below the root graphql-go reports
+// selections by field name, so aliases of one nested field are costed once.
+type costResolver struct {
+	schema      *ast.Schema
+	defaultList int
+}
+
+type costKey struct{}
+
+// tally sums the cost of one query's root fields, which graphql-go may
+// resolve concurrently.
+type tally struct {
+	mu   sync.Mutex
+	cost int
+}
+
+// Complexity estimates the work a query asks for before any resolver runs.
+// Queries that do not validate are returned as an error.
+func (h *Handler) Complexity(ctx context.Context, query, operationName string, variables map[string]any) (int, error) {
+	t := &tally{}
+	resp := h.costing.Exec(context.WithValue(ctx, costKey{}, t), query, operationName, variables)
+	if len(resp.Errors) > 0 {
+		return 0, resp.Errors[0]
+	}
+	return t.cost, nil
+}
+
+func (c *costResolver) Users(ctx context.Context, args struct{ First int32 }) ([]*userResolver, error) {
+	return []*userResolver{}, c.charge(ctx, "users", int(args.First))
+}
+
+func (c *costResolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	return nil, c.charge(ctx, "user", 1)
+}
+
+// charge adds the cost of the root field name, which returns up to size
+// items when it is a list.
+func (c *costResolver) charge(ctx context.Context, name string, size int) error {
+	t, ok := ctx.Value(costKey{}).(*tally)
+	if !ok {
+		return errors.New("complexity: no tally in context")
+	}
+
+	cost := 1
+	if def := c.field(c.schema.RootOperationTypes["query"].TypeName(), name); def != nil {
+		typeName, list := unwrap(def.Type)
+		if !list {
+			size = 1
+		}
+		cost = add(cost, c.selections(ctx, typeName, clamp(int64(size))))
+	}
+
+	t.mu.Lock()
+	t.cost = add(t.cost, cost)
+	t.mu.Unlock()
+	return nil
+}
+
+// selections costs the fields selected under the current resolver, whose
+// items are of type typeName and of which there are up to size.
+func (c *costResolver) selections(ctx context.Context, typeName string, size int) int {
+	type parent struct {
+		typeName string
+		size     int // instances of the parent's items per query
+	}
+	parents := map[string]parent{"": {typeName, size}}
+
+	total := 0
+	// Parents come before their children in the list
+	for _, path := range graphql.SelectedFieldNames(ctx) {
+		parentPath, name := "", path
+		if i := strings.LastIndexByte(path, '.'); i >= 0 {
+			parentPath, name = path[:i], path[i+1:]
+		}
+		p, ok := parents[parentPath]
+		if !ok {
+			continue
+		}
+		total = add(total, p.size)
+
+		def := c.field(p.typeName, name)
+		if def == nil {
+			continue
+		}
+		childType, list := unwrap(def.Type)
+		childSize := p.size
+		if list {
+			childSize = mul(childSize, c.pageSize(ctx, def, path))
+		}
+		parents[path] = parent{childType, childSize}
+	}
+	return total
+}
+
+// field finds a field on an object or interface type; unknown types yield
+// nil and are costed as plain fields.
+func (c *costResolver) field(typeName, name string) *ast.FieldDefinition {
+	switch t := c.schema.Types[typeName].(type) {
+	case *ast.ObjectTypeDefinition:
+		return t.Fields.Get(name)
+	case *ast.InterfaceTypeDefinition:
+		return t.Fields.Get(name)
+	}
+	return nil
+}
+
+// pageSize is how many items the list field at path may return.
+func (c *costResolver) pageSize(ctx context.Context, def *ast.FieldDefinition, path string) int {
+	arg := def.Arguments.Get("first")
+	if arg == nil {
+		return c.defaultList
+	}
+
+	var args struct{ First *int32 }
+	if ok, err := graphql.DecodeSelectedFieldArgs(ctx, path, &args); ok && err == nil && args.First != nil {
+		return clamp(int64(*args.First))
+	}
+	if arg.Default != nil {
+		if n, ok := arg.Default.Deserialize(nil).(int32); ok {
+			return clamp(int64(n))
+		}
+	}
+	return c.defaultList
+}
+
+// unwrap returns the named type under list and non-null wrappers and
+// whether a list wraps it.
+func unwrap(t ast.Type) (name string, list bool) {
+	for {
+		switch w := t.(type) {
+		case *ast.NonNull:
+			t = w.OfType
+		case *ast.List:
+			list = true
+			t = w.OfType
+		case ast.NamedType:
+			return w.TypeName(), list
+		default:
+			return "", list
+		}
+	}
+}
+
+// Saturating arithmetic: nested page sizes can overflow an int, and any
+// cost past MaxInt32 is over every sensible limit anyway.
+func add(a, b int) int { return clamp(int64(a) + int64(b)) }
+
+func mul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if int64(a) > math.MaxInt32/int64(b) {
+		return math.MaxInt32
+	}
+	return a * b
+}
+
+func clamp(n int64) int {
+	switch {
+	case n < 0:
+		return 0
+	case n > math.MaxInt32:
+		return math.MaxInt32
+	}
+	return int(n)
+}
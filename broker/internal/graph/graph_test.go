@@ -0,0 +1,242 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// fakeCaller answers the auth routes the way the broker would, recording
+// the paths it is asked for.
+type fakeCaller struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (f *fakeCaller) Get(ctx context.Context, r *http.Request, path string) (*contracts.Reply, error) {
+	f.mu.Lock()
+	f.paths = append(f.paths, path)
+	f.mu.Unlock()
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Path == "/api/v1/users":
+		limit, _ := strconv.Atoi(u.Query().Get("limit"))
+		var users []contracts.AuthUser
+		for i := 1; i <= min(limit, 3); i++ {
+			id := strconv.Itoa(i)
+			users = append(users, contracts.AuthUser{ID: id, Email: id + "@example.com"})
+		}
+		body, _ := json.Marshal(users)
+		return &contracts.Reply{Status: http.StatusOK, Body: body}, nil
+	case strings.HasPrefix(u.Path, "/api/v1/users/"):
+		id := strings.TrimPrefix(u.Path, "/api/v1/users/")
+		if id == "missing" {
+			return &contracts.Reply{Status: http.StatusNotFound, Error: contracts.NewError(http.StatusNotFound, contracts.ErrCodeNotFound, "User not found")}, nil
+		}
+		body, _ := json.Marshal(contracts.AuthUser{ID: id, Email: id + "@example.com"})
+		return &contracts.Reply{Status: http.StatusOK, Body: body}, nil
+	}
+	return &contracts.Reply{Status: http.StatusNotFound, Error: contracts.ErrorFromStatus(http.StatusNotFound, "")}, nil
+}
+
+func (f *fakeCaller) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, p := range f.paths {
+		if strings.HasPrefix(p, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func serve(t *testing.T, h *Handler, query string) (int, map[string]any) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"query": query})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/graphql", strings.NewReader(string(body))))
+
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v: %s", err, rec.Body)
+	}
+	return rec.Code, resp
+}
+
+func TestUserLookupsAreBatched(t *testing.T) {
+	calls := &fakeCaller{}
+	h, err := New(calls, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := serve(t, h, `{
+		a: user(id: "1") { id email }
+		b: user(id: "1") { id }
+		c: user(id: "2") { id }
+		d: user(id: "missing") { id }
+		users(first: 2) { id }
+	}`)
+	if status != http.StatusOK || resp["errors"] != nil {
+		t.Fatalf("status %d, errors %v", status, resp["errors"])
+	}
+
+	data := resp["data"].(map[string]any)
+	if got := data["a"].(map[string]any)["email"]; got != "1@example.com" {
+		t.Errorf("a.email = %v", got)
+	}
+	if data["d"] != nil {
+		t.Errorf("unknown user resolved to %v, want null", data["d"])
+	}
+	if n := len(data["users"].([]any)); n != 2 {
+		t.Errorf("users returned %d items, want 2", n)
+	}
+	// Users may be primed by the list, so at most one event per distinct ID
+	if n := calls.count("/api/v1/users/"); n > 3 {
+		t.Errorf("%d user lookups for 3 distinct IDs", n)
+	}
+	if n := calls.count("/api/v1/users?limit=2"); n != 1 {
+		t.Errorf("users(first: 2) asked for pages %v, want one of limit 2", calls.paths)
+	}
+}
+
+func TestUsersZeroAsksForNothing(t *testing.T) {
+	calls := &fakeCaller{}
+	h, err := New(calls, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := serve(t, h, `{ users(first: 0) { id } }`)
+	if status != http.StatusOK || resp["errors"] != nil {
+		t.Fatalf("status %d, errors %v", status, resp["errors"])
+	}
+	if len(calls.paths) != 0 {
+		t.Errorf("users(first: 0) called %v", calls.paths)
+	}
+}
+
+func TestNegativeFirst(t *testing.T) {
+	h, err := New(&fakeCaller{}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := serve(t, h, `{ users(first: -1) { id } }`)
+	if status != http.StatusOK || resp["errors"] == nil {
+		t.Errorf("negative first: status %d, errors %v", status, resp["errors"])
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	calls := &fakeCaller{}
+	h, err := New(calls, Config{MaxComplexity: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, query := range map[string]string{
+		"complexity": `{ users(first: 200) { id } }`,
+		"fragments":  `query { ...F } fragment F on Query { users(first: 60) { id email role } }`,
+		"aliases":    `query { a: users { id } b: users { id } }`,
+		"depth":      `{ __schema { types { fields { type { ofType { ofType { ofType { ofType { name } } } } } } } } }`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			status, resp := serve(t, h, query)
+			if status != http.StatusBadRequest || resp["errors"] == nil {
+				t.Errorf("status %d, want 400 with errors: %v", status, resp)
+			}
+		})
+	}
+
+	// Rejected queries make no calls
+	if len(calls.paths) != 0 {
+		t.Errorf("rejected queries called %v", calls.paths)
+	}
+
+	if status, resp := serve(t, h, `{ users(first: 10) { id email } }`); status != http.StatusOK {
+		t.Errorf("cheap query: status %d: %v", status, resp)
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	calls := &fakeCaller{}
+	h, err := New(calls, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		vars  map[string]any
+		want  int
+	}{
+		{"object", `{ user(id: "1") { id email } }`, nil, 3},
+		{"list", `{ users(first: 10) { id email } }`, nil, 21},
+		{"default page size", `{ users { id } }`, nil, 51},
+		{"variable", `query Q($n: Int!) { users(first: $n) { id } }`, map[string]any{"n": float64(4)}, 5},
+		{"empty page", `{ users(first: 0) { id email } }`, nil, 1},
+		{"fragments", `{ ... on Query { user(id: "1") { ...U } } } fragment U on User { id role }`, nil, 3},
+		{"nested fragments", `{ users(first: 2) { ...A } } fragment A on User { id ...B } fragment B on User { email }`, nil, 5},
+		{"fields named twice", `{ users(first: 2) { id id ... on User { id } } }`, nil, 3},
+		{"aliases", `{ a: users(first: 2) { id } b: users(first: 3) { id } c: user(id: "1") { id } }`, nil, 9},
+		{"typename is free", `{ user(id: "1") { __typename id } }`, nil, 2},
+		{"skipped field", `{ user(id: "1") { id email @skip(if: true) } }`, nil, 2},
+		{"comments and commas", "# comment\n{ user(id: \"1\", ) @include(if: true) { id, email } }", nil, 3},
+		{"saturates", `{ users(first: 2147483647) { id email } }`, nil, math.MaxInt32},
+		{"introspection", `{ __schema { queryType { name } } }`, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.Complexity(context.Background(), tt.query, "", tt.vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("complexity %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("operation name", func(t *testing.T) {
+		query := `query A { user(id: "1") { id } } query B { users(first: 5) { id } }`
+		for op, want := range map[string]int{"A": 2, "B": 6} {
+			if got, err := h.Complexity(context.Background(), query, op, nil); err != nil || got != want {
+				t.Errorf("operation %s: complexity %d, %v; want %d", op, got, err, want)
+			}
+		}
+	})
+
+	for name, query := range map[string]string{
+		"syntax":        `{ users(first: 2) { id }`,
+		"unknown field": `{ users { id nope } }`,
+		"wrong type":    `{ users(first: "two") { id } }`,
+		"no operation":  `fragment U on User { id }`,
+	} {
+		t.Run("invalid "+name, func(t *testing.T) {
+			if _, err := h.Complexity(context.Background(), query, "", nil); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+
+	if len(calls.paths) != 0 {
+		t.Errorf("costing called %v", calls.paths)
+	}
+}
@@ -0,0 +1,147 @@
+// Package graph serves the broker's GraphQL endpoint, a read model over the
+// REST routes. Resolvers call the routes through a Caller, batching lookups
+// per request, and queries are bounded by depth and by an estimate of
+// their cost before anything runs.
+package graph
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+//go:embed schema.graphql
+var schemaSDL string
+
+// Config bounds the queries the endpoint accepts.
+type Config struct {
+	MaxDepth       int           // nesting of selections (default 8)
+	MaxComplexity  int           // see Complexity (default 1000)
+	MaxQueryLength int           // query size in bytes (default 8 KiB)
+	Timeout        time.Duration // whole query, all RPCs included (default 15s)
+}
+
+func (c *Config) applyDefaults() {
+	if c.MaxDepth <= 0 {
+		c.MaxDepth = 8
+	}
+	if c.MaxComplexity <= 0 {
+		c.MaxComplexity = 1000
+	}
+	if c.MaxQueryLength <= 0 {
+		c.MaxQueryLength = 8 << 10
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 15 * time.Second
+	}
+}
+
+const (
+	// maxBodyOverhead leaves room for variables next to the longest query
+	maxBodyOverhead = 64 << 10
+	// defaultListSize is the cost multiplier of list fields without a
+	// first argument
+	defaultListSize = 50
+)
+
+// Handler answers GraphQL queries over HTTP POST.
+type Handler struct {
+	schema   *graphql.Schema
+	costing  *graphql.Schema // the schema bound to costResolver
+	resolver *resolver
+	cfg      Config
+}
+
+// New parses the schema and binds it to resolvers calling through calls.
+func New(calls Caller, cfg Config) (*Handler, error) {
+	cfg.applyDefaults()
+
+	opts := []graphql.SchemaOpt{
+		graphql.MaxDepth(cfg.MaxDepth),
+		graphql.MaxQueryLength(cfg.MaxQueryLength),
+	}
+	r := &resolver{calls: calls}
+	schema, err := graphql.ParseSchema(schemaSDL, r, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("graphql schema: %w", err)
+	}
+	costing, err := graphql.ParseSchema(schemaSDL, &costResolver{schema: schema.ASTSchema(), defaultList: defaultListSize}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("graphql schema: %w", err)
+	}
+	return &Handler{schema: schema, costing: costing, resolver: r, cfg: cfg}, nil
+}
+
+type request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// ServeHTTP runs one query. Requests that cannot run at all, because they
+// are malformed, invalid or too costly, are answered with 400; executed
+// queries with 200, errors from single fields included.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	body := http.MaxBytesReader(w, r.Body, int64(h.cfg.MaxQueryLength)+maxBodyOverhead)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeErrors(w, http.StatusRequestEntityTooLarge, requestError(contracts.ErrCodePayloadTooLarge, "Request body is too large"))
+			return
+		}
+		writeErrors(w, http.StatusBadRequest, requestError(contracts.ErrCodeInvalidPayload, "Request body must be a JSON object with a query"))
+		return
+	}
+	if req.Query == "" {
+		writeErrors(w, http.StatusBadRequest, requestError(contracts.ErrCodeInvalidPayload, "query is required"))
+		return
+	}
+
+	if errs := h.schema.ValidateWithVariables(req.Query, req.Variables); len(errs) > 0 {
+		writeErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	cost, err := h.Complexity(r.Context(), req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		writeErrors(w, http.StatusBadRequest, requestError(contracts.ErrCodeInvalidPayload, err.Error()))
+		return
+	}
+	if cost > h.cfg.MaxComplexity {
+		writeErrors(w, http.StatusBadRequest, requestError(contracts.ErrCodeQueryTooComplex,
+			fmt.Sprintf("query complexity %d exceeds the limit of %d", cost, h.cfg.MaxComplexity)))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.Timeout)
+	defer cancel()
+	// Loaders batch under ctx, so it must carry the request first
+	ctx = context.WithValue(ctx, requestKey{}, r)
+	ctx = context.WithValue(ctx, loadersKey{}, h.resolver.newLoaders(ctx))
+
+	resp := h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func requestError(code contracts.ErrorCode, message string) *gqlerrors.QueryError {
+	return &gqlerrors.QueryError{Message: message, Extensions: map[string]any{"code": code}}
+}
+
+func writeErrors(w http.ResponseWriter, status int, errs ...*gqlerrors.QueryError) {
+	writeJSON(w, status, &graphql.Response{Errors: errs})
+}
+
+func writeJSON(w http.ResponseWriter, status int, resp *graphql.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,140 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchWait = 2 * time.Millisecond
+	defaultMaxBatch  = 100
+)
+
+var errNoResult = errors.New("batch returned no result for key")
+
+// Result is a batch function's outcome for one key.
+type Result[V any] struct {
+	Value V
+	Err   error
+}
+
+// BatchFunc fetches many keys at once. It returns one result per key, in
+// the order of keys.
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) []Result[V]
+
+// Loader coalesces the loads resolvers issue concurrently into batches and
+// caches every result for the lifetime of one request, so a query naming
+// the same entity many times fetches it once. Loads arriving within wait of
+// the first one share its batch.
+type Loader[K comparable, V any] struct {
+	ctx      context.Context
+	fetch    BatchFunc[K, V]
+	wait     time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	cache   map[K]*pending[V]
+	current *batch[K, V]
+}
+
+type pending[V any] struct {
+	done chan struct{}
+	Result[V]
+}
+
+type batch[K comparable, V any] struct {
+	keys    []K
+	results []*pending[V]
+}
+
+// NewLoader returns a loader whose batches run under ctx, normally the
+// request's.
+func NewLoader[K comparable, V any](ctx context.Context, fetch BatchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{
+		ctx:      ctx,
+		fetch:    fetch,
+		wait:     defaultBatchWait,
+		maxBatch: defaultMaxBatch,
+		cache:    make(map[K]*pending[V]),
+	}
+}
+
+// Load returns the value for key, waiting for the batch it joins.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	p, ok := l.cache[key]
+	if !ok {
+		p = &pending[V]{done: make(chan struct{})}
+		l.cache[key] = p
+		l.enqueue(key, p)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return p.Value, p.Err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// Prime caches a value fetched some other way, such as an entity found in
+// a list, so later loads of its key need no round trip. Keys already
+// loaded or being loaded keep their result.
+func (l *Loader[K, V]) Prime(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.cache[key]; ok {
+		return
+	}
+	p := &pending[V]{done: make(chan struct{}), Result: Result[V]{Value: value}}
+	close(p.done)
+	l.cache[key] = p
+}
+
+// enqueue adds key to the open batch, opening one if needed. Called with
+// l.mu held.
+func (l *Loader[K, V]) enqueue(key K, p *pending[V]) {
+	if l.current == nil {
+		b := &batch[K, V]{}
+		l.current = b
+		time.AfterFunc(l.wait, func() { l.dispatch(b) })
+	}
+
+	b := l.current
+	b.keys = append(b.keys, key)
+	b.results = append(b.results, p)
+	if len(b.keys) >= l.maxBatch {
+		l.current = nil
+		go l.run(b)
+	}
+}
+
+// dispatch runs b when its wait is over, unless it already ran full.
+func (l *Loader[K, V]) dispatch(b *batch[K, V]) {
+	l.mu.Lock()
+	if l.current != b {
+		l.mu.Unlock()
+		return
+	}
+	l.current = nil
+	l.mu.Unlock()
+
+	l.run(b)
+}
+
+func (l *Loader[K, V]) run(b *batch[K, V]) {
+	results := l.fetch(l.ctx, b.keys)
+	for i, p := range b.results {
+		if i < len(results) {
+			p.Result = results[i]
+		} else {
+			p.Err = errNoResult
+		}
+		close(p.done)
+	}
+}
@@ -0,0 +1,173 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// Caller serves GET requests for route table paths on behalf of the
+// GraphQL request r, with its credentials, so resolvers get the same auth,
+// cache, timeouts, circuit breakers and retries as REST calls. Failures
+// are replies with an Error; the error return is for a cancelled ctx.
+type Caller interface {
+	Get(ctx context.Context, r *http.Request, path string) (*contracts.Reply, error)
+}
+
+// resolver is the Query root.
+type resolver struct {
+	calls Caller
+}
+
+// loaders batch one request's entity lookups.
+type loaders struct {
+	users *Loader[string, *contracts.AuthUser]
+}
+
+type loadersKey struct{}
+
+// requestKey holds the GraphQL request resolvers call routes for.
+type requestKey struct{}
+
+func (r *resolver) newLoaders(ctx context.Context) *loaders {
+	return &loaders{
+		users: NewLoader(ctx, r.fetchUsers),
+	}
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+func (r *resolver) Users(ctx context.Context, args struct{ First int32 }) ([]*userResolver, error) {
+	if args.First < 0 {
+		return nil, errors.New("first must not be negative")
+	}
+	if args.First == 0 {
+		return []*userResolver{}, nil
+	}
+
+	// auth pages the list itself; it may return fewer than asked for
+	var users []*contracts.AuthUser
+	if err := r.call(ctx, "/api/v1/users?"+url.Values{"limit": {strconv.Itoa(int(args.First))}}.Encode(), &users); err != nil {
+		return nil, err
+	}
+
+	// Users fetched in the list need no second trip when also asked for
+	// by ID in the same query
+	load := loadersFrom(ctx).users
+	for _, u := range users {
+		load.Prime(u.ID, u)
+	}
+
+	if len(users) > int(args.First) {
+		users = users[:args.First]
+	}
+	out := make([]*userResolver, len(users))
+	for i, u := range users {
+		out[i] = &userResolver{u}
+	}
+	return out, nil
+}
+
+func (r *resolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	u, err := loadersFrom(ctx).users.Load(ctx, string(args.ID))
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return &userResolver{u}, nil
+}
+
+// fetchUsers loads users by ID. auth answers one user per request, so a
+// batch makes one request per distinct ID, concurrently; the
+// loader still saves the trips for repeated IDs. Unknown IDs resolve to
+// nil.
+func (r *resolver) fetchUsers(ctx context.Context, ids []string) []Result[*contracts.AuthUser] {
+	results := make([]Result[*contracts.AuthUser], len(ids))
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var u contracts.AuthUser
+			err := r.call(ctx, "/api/v1/users/"+url.PathEscape(id), &u)
+			var apiErr *upstreamError
+			switch {
+			case errors.As(err, &apiErr) && apiErr.err.Status == http.StatusNotFound:
+			case err != nil:
+				results[i].Err = err
+			default:
+				results[i].Value = &u
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// call GETs a route table path and decodes a successful reply's body into
+// out.
+func (r *resolver) call(ctx context.Context, path string, out any) error {
+	reply, err := r.calls.Get(ctx, ctx.Value(requestKey{}).(*http.Request), path)
+	if err != nil {
+		return err
+	}
+	if reply.Error != nil {
+		return &upstreamError{reply.Error}
+	}
+	if reply.Status >= http.StatusBadRequest {
+		return &upstreamError{contracts.ErrorFromStatus(reply.Status, "")}
+	}
+
+	if err := json.Unmarshal(reply.Body, out); err != nil {
+		return &upstreamError{contracts.NewError(http.StatusBadGateway, contracts.ErrCodeBadGateway, "Unexpected reply for "+path)}
+	}
+	return nil
+}
+
+// upstreamError carries a typed error into the GraphQL response, where
+// its code and status show up under the error's extensions.
+type upstreamError struct {
+	err *contracts.Error
+}
+
+func (e *upstreamError) Error() string {
+	return e.err.Message
+}
+
+func (e *upstreamError) Extensions() map[string]any {
+	return map[string]any{"code": e.err.Code, "status": e.err.Status}
+}
+
+// userResolver exposes contracts.AuthUser as the User type.
+type userResolver struct {
+	u *contracts.AuthUser
+}
+
+func (r *userResolver) ID() graphql.ID          { return graphql.ID(r.u.ID) }
+func (r *userResolver) Email() string           { return r.u.Email }
+func (r *userResolver) EmailVerified() bool     { return r.u.EmailVerified }
+func (r *userResolver) Phone() *string          { return r.u.Phone }
+func (r *userResolver) PhoneVerified() bool     { return r.u.PhoneVerified }
+func (r *userResolver) AvatarURL() *string      { return r.u.AvatarURL }
+func (r *userResolver) Status() string          { return r.u.Status }
+func (r *userResolver) Role() string            { return r.u.Role }
+func (r *userResolver) CreatedAt() graphql.Time { return graphql.Time{Time: r.u.CreatedAt} }
+func (r *userResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: r.u.UpdatedAt} }
+
+func (r *userResolver) LastLoginAt() *graphql.Time {
+	if r.u.LastLoginAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.u.LastLoginAt}
+}
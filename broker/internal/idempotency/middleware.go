@@ -0,0 +1,182 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	DefaultTTL   = 24 * time.Hour
+
+	// leaseMargin is how much longer than the handler's timeout a running
+	// request holds its key, covering the time spent around the RPC
+	leaseMargin    = 5 * time.Second
+	defaultTimeout = 30 * time.Second
+)
+
+// replayedHeaders are the response headers stored with a record; the rest
+// are set by middleware again on replay.
+var replayedHeaders = []string{"Content-Type", "Location", "Retry-After", "ETag", "Cache-Control"}
+
+// Middleware dedupes requests carrying an Idempotency-Key header. The
+// first request with a key runs and its response is stored; retries with
+// the same body get that response replayed, retries with a different body
+// are rejected with 422, and retries arriving while the first is still
+// running get 409. Keys are scoped to the authenticated subject, and the
+// scoped key is published as the AMQP MessageId so listeners can dedupe
+// too. Requests without the header pass through untouched.
+//
+// A running request holds its key for timeout, the longest next may take,
+// plus a margin, so a broker replica dying mid-request blocks retries for
+// no longer than that. Stored responses are kept for ttl.
+func Middleware(store Store, timeout, ttl time.Duration) func(http.Handler) http.Handler {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	lease := timeout + leaseMargin
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderKey)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Idempotency-Key is too long").
+					WithField(HeaderKey, "must be at most 255 characters"))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Failed to read body"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			if id, ok := brokermw.IdentityFrom(r.Context()); ok {
+				key = id.Subject + ":" + key
+			}
+			fingerprint := fingerprint(r, body)
+
+			existing, reserved, err := store.Reserve(r.Context(), key, Record{Fingerprint: fingerprint}, lease)
+			if err != nil {
+				log.Printf("Idempotency store unavailable: %v", err)
+				w.Header().Set("Retry-After", "1")
+				event.WriteProblem(w, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "Idempotency store unavailable"))
+				return
+			}
+
+			if !reserved {
+				switch {
+				case existing.Fingerprint != fingerprint:
+					event.WriteProblem(w, contracts.NewError(http.StatusUnprocessableEntity, contracts.ErrCodeIdempotencyReused,
+						"Idempotency-Key was already used for a different request"))
+				case !existing.Done:
+					w.Header().Set("Retry-After", "1")
+					event.WriteProblem(w, contracts.NewError(http.StatusConflict, contracts.ErrCodeRequestInProgress,
+						"A request with this Idempotency-Key is still in progress"))
+				default:
+					replay(w, existing)
+				}
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(event.WithMessageID(r.Context(), key)))
+
+			// Failures that may succeed on retry give the key back, so the
+			// retry runs instead of replaying the failure. Listeners see the
+			// same MessageId on the retry and can dedupe the first attempt.
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError || rec.status == http.StatusTooManyRequests {
+				if err := store.Release(ctx, key); err != nil {
+					log.Printf("Failed to release idempotency key: %v", err)
+				}
+				return
+			}
+
+			saved := Record{
+				Fingerprint: fingerprint,
+				Done:        true,
+				Status:      rec.status,
+				Header:      make(http.Header),
+				Body:        rec.body.Bytes(),
+			}
+			for _, name := range replayedHeaders {
+				if v := w.Header().Values(name); len(v) > 0 {
+					saved.Header[name] = v
+				}
+			}
+			if err := store.Save(ctx, key, saved, ttl); err != nil {
+				log.Printf("Failed to save idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+// fingerprint identifies a request by method, path and body, so a key
+// reused for anything else is detected.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	h.Write([]byte{0})
+	io.WriteString(h, r.URL.Path)
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, rec *Record) {
+	for name, values := range rec.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+// recorder passes the response through while keeping a copy to store.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
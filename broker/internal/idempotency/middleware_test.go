@@ -0,0 +1,287 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// send runs one POST with key and body through h.
+func send(h http.Handler, key, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(body))
+	r.Header.Set(HeaderKey, key)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) contracts.ErrorCode {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != contracts.ProblemContentType {
+		t.Errorf("Content-Type %q, want %s", ct, contracts.ProblemContentType)
+	}
+	var payload contracts.ProblemPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode error: %v: %s", err, rec.Body)
+	}
+	return payload.Code
+}
+
+// counting answers 201 with a body naming the call, counting calls.
+func counting(calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Location", "/api/v1/users/1")
+		w.Header().Set("X-Not-Stored", "1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int32{"call": n})
+	})
+}
+
+func TestReplay(t *testing.T) {
+	var calls atomic.Int32
+	h := Middleware(NewMemoryStore(), 0, 0)(counting(&calls))
+
+	first := send(h, "k1", `{"a":1}`)
+	if first.Code != http.StatusCreated || first.Header().Get(HeaderReplayed) != "" {
+		t.Fatalf("first: status %d, replayed %q", first.Code, first.Header().Get(HeaderReplayed))
+	}
+
+	second := send(h, "k1", `{"a":1}`)
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replay: %d %s, want %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if got := second.Header().Get(HeaderReplayed); got != "true" {
+		t.Errorf("replay: %s = %q, want true", HeaderReplayed, got)
+	}
+	if got := second.Header().Get("Location"); got != "/api/v1/users/1" {
+		t.Errorf("replay: Location = %q", got)
+	}
+	if got := second.Header().Get("X-Not-Stored"); got != "" {
+		t.Errorf("replay: X-Not-Stored = %q, want it left out", got)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+
+	// Other keys and requests without one run
+	send(h, "k2", `{"a":1}`)
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(`{"a":1}`))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("handler ran %d times, want 3", n)
+	}
+}
+
+func TestKeyReusedForDifferentRequest(t *testing.T) {
+	var calls atomic.Int32
+	h := Middleware(NewMemoryStore(), 0, 0)(counting(&calls))
+
+	send(h, "k", `{"a":1}`)
+	rec := send(h, "k", `{"a":2}`)
+	if rec.Code != http.StatusUnprocessableEntity || errorCode(t, rec) != contracts.ErrCodeIdempotencyReused {
+		t.Errorf("different body: %d %s", rec.Code, rec.Body)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestKeysAreScopedToSubject(t *testing.T) {
+	var calls atomic.Int32
+	auth := brokermw.NewTokenAuthenticator(map[string]string{"ta": "alice", "tb": "bob"})
+	h := brokermw.Authenticate(auth, false)(Middleware(NewMemoryStore(), 0, 0)(counting(&calls)))
+
+	send(h, "k", `{}`, "Authorization", "Bearer ta")
+	if rec := send(h, "k", `{}`, "Authorization", "Bearer tb"); rec.Header().Get(HeaderReplayed) != "" {
+		t.Error("bob got alice's response replayed")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+}
+
+func TestConflictWhileInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := Middleware(NewMemoryStore(), 0, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		send(h, "k", `{}`)
+	}()
+	<-started
+
+	rec := send(h, "k", `{}`)
+	if rec.Code != http.StatusConflict || errorCode(t, rec) != contracts.ErrCodeRequestInProgress {
+		t.Errorf("while in flight: %d %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("409 without Retry-After")
+	}
+
+	close(release)
+	wg.Wait()
+	if rec := send(h, "k", `{}`); rec.Code != http.StatusCreated || rec.Header().Get(HeaderReplayed) != "true" {
+		t.Errorf("after completion: %d, replayed %q", rec.Code, rec.Header().Get(HeaderReplayed))
+	}
+}
+
+func TestRetriableFailuresReleaseKey(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		rerun  bool
+	}{
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadRequest, false},
+		{http.StatusConflict, false},
+	} {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			var calls atomic.Int32
+			h := Middleware(NewMemoryStore(), 0, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			send(h, "k", `{}`)
+			rec := send(h, "k", `{}`)
+
+			if tt.rerun {
+				if rec.Code != http.StatusCreated || calls.Load() != 2 {
+					t.Errorf("retry: status %d after %d calls, want the request run again", rec.Code, calls.Load())
+				}
+				return
+			}
+			if rec.Code != tt.status || rec.Header().Get(HeaderReplayed) != "true" || calls.Load() != 1 {
+				t.Errorf("retry: status %d, replayed %q after %d calls; want %d replayed",
+					rec.Code, rec.Header().Get(HeaderReplayed), calls.Load(), tt.status)
+			}
+		})
+	}
+}
+
+// leaseStore records the ttl of every claim and save.
+type leaseStore struct {
+	*MemoryStore
+	reserved, saved time.Duration
+}
+
+func (s *leaseStore) Reserve(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, bool, error) {
+	s.reserved = ttl
+	return s.MemoryStore.Reserve(ctx, key, rec, ttl)
+}
+
+func (s *leaseStore) Save(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	s.saved = ttl
+	return s.MemoryStore.Save(ctx, key, rec, ttl)
+}
+
+func TestShortLeaseUntilSaved(t *testing.T) {
+	store := &leaseStore{MemoryStore: NewMemoryStore()}
+	var calls atomic.Int32
+	h := Middleware(store, 10*time.Second, DefaultTTL)(counting(&calls))
+
+	send(h, "k", `{}`)
+	if want := 10*time.Second + leaseMargin; store.reserved != want {
+		t.Errorf("claimed for %v, want %v", store.reserved, want)
+	}
+	if store.saved != DefaultTTL {
+		t.Errorf("saved for %v, want %v", store.saved, DefaultTTL)
+	}
+}
+
+func TestExpiredClaimCanBeRetaken(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	if _, ok, _ := store.Reserve(ctx, "k", Record{Fingerprint: "a"}, 20*time.Millisecond); !ok {
+		t.Fatal("first claim failed")
+	}
+	if existing, ok, _ := store.Reserve(ctx, "k", Record{Fingerprint: "a"}, time.Minute); ok || existing.Done {
+		t.Fatalf("live claim retaken: ok %v, existing %+v", ok, existing)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok, _ := store.Reserve(ctx, "k", Record{Fingerprint: "a"}, time.Minute); !ok {
+		t.Error("expired claim was not retaken")
+	}
+}
+
+// publishChannel records the MessageId of every published event.
+type publishChannel struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (c *publishChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (c *publishChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return make(chan amqp.Delivery), nil
+}
+
+func (c *publishChannel) Confirm(noWait bool) error { return nil }
+
+func (c *publishChannel) NotifyReturn(ch chan amqp.Return) chan amqp.Return { return ch }
+
+func (c *publishChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	c.mu.Lock()
+	c.ids = append(c.ids, msg.MessageId)
+	c.mu.Unlock()
+	return nil, nil
+}
+
+func (c *publishChannel) Close() error { return nil }
+
+func TestMessageIDIsScopedKey(t *testing.T) {
+	ch := &publishChannel{}
+	emitter, err := event.NewEmitterWithChannel(ch, "test_exchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	auth := brokermw.NewTokenAuthenticator(map[string]string{"ta": "alice"})
+	h := brokermw.Authenticate(auth, false)(Middleware(NewMemoryStore(), 0, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := emitter.PushAsync(r.Context(), contracts.TopicPayload{Name: "auth.register"}); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})))
+
+	send(h, "k", `{}`, "Authorization", "Bearer ta")
+	send(h, "", `{}`)
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if len(ch.ids) != 2 || ch.ids[0] != "alice:k" || ch.ids[1] != "" {
+		t.Errorf("message IDs %q, want [alice:k, \"\"]", ch.ids)
+	}
+}
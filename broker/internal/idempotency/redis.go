@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RedisClient is the subset of a Redis client the store needs. Get returns
+// a nil value without error for a missing key. Thin adapters over go-redis
+// or rueidis satisfy it, which keeps the broker free of a Redis dependency
+// until one is deployed.
+type RedisClient interface {
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Del(ctx context.Context, key string) error
+}
+
+// RedisStore keeps records in Redis as JSON, shared by all replicas.
+type RedisStore struct {
+	client RedisClient
+	prefix string
+}
+
+func NewRedisStore(client RedisClient, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "idempotency:"
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Reserve(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, bool, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// A claim can expire between SETNX and GET; one retry covers that
+	for attempt := 0; attempt < 2; attempt++ {
+		ok, err := s.client.SetNX(ctx, s.prefix+key, data, ttl)
+		if err != nil {
+			return nil, false, fmt.Errorf("reserve idempotency key: %w", err)
+		}
+		if ok {
+			return nil, true, nil
+		}
+
+		raw, err := s.client.Get(ctx, s.prefix+key)
+		if err != nil {
+			return nil, false, fmt.Errorf("read idempotency key: %w", err)
+		}
+		if raw == nil {
+			continue
+		}
+
+		var existing Record
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return nil, false, fmt.Errorf("decode idempotency record: %w", err)
+		}
+		return &existing, false, nil
+	}
+
+	return nil, false, fmt.Errorf("reserve idempotency key: %w", ErrNotFound)
+}
+
+func (s *RedisStore) Save(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+key, data, ttl)
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key)
+}
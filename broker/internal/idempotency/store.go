@@ -0,0 +1,37 @@
+// Package idempotency makes retried mutating requests safe: the first
+// response for an Idempotency-Key is stored and replayed to every retry.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var ErrNotFound = errors.New("idempotency key not found")
+
+// Record is what the store keeps for a key: the fingerprint of the request
+// that claimed it and, once that request finished, its response.
+type Record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Store keeps idempotency records. Its operations map onto Redis commands
+// (SET NX PX, SET PX, DEL), so a shared Redis can back several broker
+// replicas; see RedisStore.
+type Store interface {
+	// Reserve claims key for the request described by rec until ttl
+	// passes. When the key is already claimed it returns the existing
+	// record and false.
+	Reserve(ctx context.Context, key string, rec Record, ttl time.Duration) (*Record, bool, error)
+	// Save stores the final record for a claimed key, replacing the claim
+	// and its expiry.
+	Save(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// Release drops a claim so the request can be retried.
+	Release(ctx context.Context, key string) error
+}
@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// MemoryStore keeps jobs in process memory. Jobs are forgotten ttl after
+// their last update.
+type MemoryStore struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	watchers  map[string][]chan Job
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+
+	return &MemoryStore{
+		jobs:     make(map[string]*Job),
+		watchers: make(map[string][]chan Job),
+		ttl:      ttl,
+	}
+}
+
+func (m *MemoryStore) Create(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	m.sweep(now)
+
+	stored := *job
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	if stored.Status == "" {
+		stored.Status = StatusPending
+	}
+
+	m.jobs[job.ID] = &stored
+	*job = stored
+	return nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copied := *job
+	return &copied, nil
+}
+
+func (m *MemoryStore) Complete(ctx context.Context, id string, reply *contracts.Reply) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	// A redelivered reply must not overwrite the first outcome
+	if job.Done() {
+		copied := *job
+		return &copied, nil
+	}
+
+	job.Status = StatusFor(reply)
+	job.Result = reply
+	job.UpdatedAt = time.Now().UTC()
+
+	for _, w := range m.watchers[id] {
+		w <- *job
+		close(w)
+	}
+	delete(m.watchers, id)
+
+	copied := *job
+	return &copied, nil
+}
+
+func (m *MemoryStore) Watch(ctx context.Context, id string) (<-chan Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	// Buffered for the current state plus the final one, so Complete never
+	// blocks on a slow reader
+	ch := make(chan Job, 2)
+	ch <- *job
+	if job.Done() {
+		close(ch)
+		return ch, nil
+	}
+
+	m.watchers[id] = append(m.watchers[id], ch)
+
+	go func() {
+		<-ctx.Done()
+		m.unwatch(id, ch)
+	}()
+
+	return ch, nil
+}
+
+func (m *MemoryStore) unwatch(id string, ch chan Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	watchers := m.watchers[id]
+	for i, w := range watchers {
+		if w == ch {
+			m.watchers[id] = append(watchers[:i], watchers[i+1:]...)
+			close(ch)
+			break
+		}
+	}
+	if len(m.watchers[id]) == 0 {
+		delete(m.watchers, id)
+	}
+}
+
+// sweep drops expired jobs at most once a minute.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for id, job := range m.jobs {
+		if now.Sub(job.UpdatedAt) > m.ttl && len(m.watchers[id]) == 0 {
+			delete(m.jobs, id)
+		}
+	}
+}
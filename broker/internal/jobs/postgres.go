@@ -0,0 +1,174 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// watchInterval is how often watchers poll a pending job. Replies may be
+// recorded by any replica, so there is no local event to wait on.
+const watchInterval = 250 * time.Millisecond
+
+const createTable = `
+CREATE TABLE IF NOT EXISTS broker_jobs (
+	id         TEXT PRIMARY KEY,
+	topic      TEXT NOT NULL,
+	event      TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	result     JSONB,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+)`
+
+// PostgresStore keeps jobs in a table every broker replica shares, so any
+// replica can answer for a job another one accepted. Jobs are forgotten
+// ttl after their last update.
+type PostgresStore struct {
+	pool     *pgxpool.Pool
+	ttl      time.Duration
+	interval time.Duration
+
+	lastSweep atomic.Int64 // Unix nanoseconds
+}
+
+// NewPostgresStore creates the jobs table if needed and returns a store
+// on it.
+func NewPostgresStore(ctx context.Context, pool *pgxpool.Pool, ttl time.Duration) (*PostgresStore, error) {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	if _, err := pool.Exec(ctx, createTable); err != nil {
+		return nil, fmt.Errorf("create jobs table: %w", err)
+	}
+	return &PostgresStore{pool: pool, ttl: ttl, interval: watchInterval}, nil
+}
+
+func (p *PostgresStore) Create(ctx context.Context, job *Job) error {
+	now := time.Now().UTC()
+	p.sweep(ctx, now)
+
+	stored := *job
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	if stored.Status == "" {
+		stored.Status = StatusPending
+	}
+
+	_, err := p.pool.Exec(ctx,
+		`INSERT INTO broker_jobs (id, topic, event, status, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		stored.ID, stored.Topic, stored.Event, stored.Status, stored.CreatedAt, stored.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("create job: %w", err)
+	}
+	*job = stored
+	return nil
+}
+
+func (p *PostgresStore) Get(ctx context.Context, id string) (*Job, error) {
+	row := p.pool.QueryRow(ctx,
+		`SELECT id, topic, event, status, result, created_at, updated_at
+		 FROM broker_jobs WHERE id = $1 AND updated_at > $2`,
+		id, time.Now().Add(-p.ttl))
+	return scanJob(row)
+}
+
+func (p *PostgresStore) Complete(ctx context.Context, id string, reply *contracts.Reply) (*Job, error) {
+	result, err := json.Marshal(reply)
+	if err != nil {
+		return nil, fmt.Errorf("encode reply: %w", err)
+	}
+
+	// A redelivered reply must not overwrite the first outcome, so only
+	// pending jobs are updated
+	row := p.pool.QueryRow(ctx,
+		`UPDATE broker_jobs SET status = $2, result = $3, updated_at = $4
+		 WHERE id = $1 AND status = $5
+		 RETURNING id, topic, event, status, result, created_at, updated_at`,
+		id, StatusFor(reply), result, time.Now().UTC(), StatusPending)
+	job, err := scanJob(row)
+	if errors.Is(err, ErrNotFound) {
+		return p.Get(ctx, id)
+	}
+	return job, err
+}
+
+func (p *PostgresStore) Watch(ctx context.Context, id string) (<-chan Job, error) {
+	job, err := p.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Job, 1)
+	ch <- *job
+	if job.Done() {
+		close(ch)
+		return ch, nil
+	}
+
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				job, err := p.Get(ctx, id)
+				if err != nil || !job.Done() {
+					continue
+				}
+				select {
+				case ch <- *job:
+				case <-ctx.Done():
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// sweep deletes expired jobs at most once a minute per replica.
+func (p *PostgresStore) sweep(ctx context.Context, now time.Time) {
+	last := p.lastSweep.Load()
+	if now.Sub(time.Unix(0, last)) < time.Minute || !p.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	p.pool.Exec(ctx, `DELETE FROM broker_jobs WHERE updated_at < $1`, now.Add(-p.ttl))
+}
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var job Job
+	var result []byte
+	err := row.Scan(&job.ID, &job.Topic, &job.Event, &job.Status, &result, &job.CreatedAt, &job.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read job: %w", err)
+	}
+
+	if result != nil {
+		job.Result = new(contracts.Reply)
+		if err := json.Unmarshal(result, job.Result); err != nil {
+			return nil, fmt.Errorf("decode job result: %w", err)
+		}
+	}
+	job.CreatedAt = job.CreatedAt.UTC()
+	job.UpdatedAt = job.UpdatedAt.UTC()
+	return &job, nil
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+var ErrNotFound = errors.New("job not found")
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Job tracks an asynchronous command from the 202 response until the
+// listener's reply arrives.
+type Job struct {
+	ID        string           `json:"id"`
+	Topic     string           `json:"topic"`
+	Event     string           `json:"event"`
+	Status    Status           `json:"status"`
+	Result    *contracts.Reply `json:"result,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// Done reports whether the job has reached a final state.
+func (j *Job) Done() bool {
+	return j.Status != StatusPending
+}
+
+// Store persists jobs. MemoryStore serves a single broker; several
+// replicas need a shared store such as PostgresStore, so any replica can
+// answer status requests for a job another one accepted.
+type Store interface {
+	Create(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id string) (*Job, error)
+	// Complete records the reply for a job and notifies its watchers.
+	Complete(ctx context.Context, id string, reply *contracts.Reply) (*Job, error)
+	// Watch streams the job's state: first its current state, then every
+	// change until it is done or ctx ends, after which the channel closes.
+	Watch(ctx context.Context, id string) (<-chan Job, error)
+}
+
+// StatusFor maps a reply to the job status it implies.
+func StatusFor(reply *contracts.Reply) Status {
+	if reply.Error != nil || reply.Status >= http.StatusBadRequest {
+		return StatusFailed
+	}
+	return StatusCompleted
+}
@@ -0,0 +1,125 @@
+package jobs
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// stores returns the stores under test. The Postgres store needs a
+// database, named by BROKER_TEST_DATABASE_URL.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	stores := map[string]Store{"memory": NewMemoryStore(0)}
+
+	dsn := os.Getenv("BROKER_TEST_DATABASE_URL")
+	if dsn == "" {
+		return stores
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	pg, err := NewPostgresStore(ctx, pool, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg.interval = 10 * time.Millisecond
+	stores["postgres"] = pg
+	return stores
+}
+
+func TestStore(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			job := &Job{ID: uuid.New().String(), Topic: "orders.create", Event: "create"}
+			if err := store.Create(ctx, job); err != nil {
+				t.Fatal(err)
+			}
+			if job.Status != StatusPending || job.CreatedAt.IsZero() {
+				t.Errorf("created job %+v", job)
+			}
+
+			got, err := store.Get(ctx, job.ID)
+			if err != nil || got.Status != StatusPending || got.Topic != job.Topic {
+				t.Fatalf("Get: %+v, %v", got, err)
+			}
+
+			watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			updates, err := store.Watch(watchCtx, job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first := <-updates; first.Status != StatusPending {
+				t.Errorf("first update %+v, want pending", first)
+			}
+
+			reply := &contracts.Reply{Status: http.StatusCreated, Body: []byte(`{"id":"o-1"}`)}
+			done, err := store.Complete(ctx, job.ID, reply)
+			if err != nil || done.Status != StatusCompleted || string(done.Result.Body) != `{"id":"o-1"}` {
+				t.Fatalf("Complete: %+v, %v", done, err)
+			}
+
+			final, ok := <-updates
+			if !ok || final.Status != StatusCompleted {
+				t.Errorf("final update %+v, want completed", final)
+			}
+			if _, ok := <-updates; ok {
+				t.Error("updates still open after the job finished")
+			}
+
+			// Redelivered replies keep the first outcome
+			again, err := store.Complete(ctx, job.ID, &contracts.Reply{Status: http.StatusBadGateway})
+			if err != nil || again.Status != StatusCompleted || again.Result.Status != http.StatusCreated {
+				t.Errorf("second Complete: %+v, %v", again, err)
+			}
+
+			if _, err := store.Get(ctx, "nope"); err != ErrNotFound {
+				t.Errorf("Get of an unknown job: %v, want ErrNotFound", err)
+			}
+			if _, err := store.Complete(ctx, "nope", reply); err != ErrNotFound {
+				t.Errorf("Complete of an unknown job: %v, want ErrNotFound", err)
+			}
+			if _, err := store.Watch(ctx, "nope"); err != ErrNotFound {
+				t.Errorf("Watch of an unknown job: %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestWatchEndsWithContext(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			job := &Job{ID: uuid.New().String()}
+			store.Create(context.Background(), job)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			updates, err := store.Watch(ctx, job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-updates
+			cancel()
+
+			select {
+			case _, ok := <-updates:
+				if ok {
+					t.Error("update after the watcher left")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("updates not closed after the context ended")
+			}
+		})
+	}
+}
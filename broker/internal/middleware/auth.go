@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Subject string
+}
+
+// Authenticator resolves the caller of a request. It returns
+// ErrUnauthenticated when the request carries no valid credentials.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// IdentityFrom returns the identity stored on ctx by Authenticate.
+func IdentityFrom(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Authenticate resolves the caller with a and stores it on the request
+// context. When required is set, requests without a valid identity are
+// rejected with 401; otherwise they continue anonymously.
+func Authenticate(a Authenticator, required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id *Identity
+			var err error
+			if a != nil {
+				id, err = a.Authenticate(r)
+			} else {
+				err = ErrUnauthenticated
+			}
+
+			if err != nil {
+				if required {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+					event.WriteProblem(w, contracts.NewError(http.StatusUnauthorized, contracts.ErrCodeUnauthorized, "Authentication required"))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+		})
+	}
+}
+
+// TokenAuthenticator accepts bearer tokens from a fixed set, each mapped to
+// the subject it authenticates.
+type TokenAuthenticator struct {
+	tokens map[string]string
+}
+
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	return &TokenAuthenticator{tokens: tokens}
+}
+
+// ParseTokens parses "token:subject,token:subject" as used in BROKER_API_TOKENS.
+func ParseTokens(spec string) map[string]string {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		token, subject, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || token == "" {
+			continue
+		}
+		tokens[token] = subject
+	}
+	return tokens
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	for known, subject := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return &Identity{Subject: subject}, nil
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
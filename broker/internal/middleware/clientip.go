@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+)
+
+// IPResolver finds the client address of a request. Forwarded headers are
+// only believed when the request comes from a trusted proxy, since anyone
+// else can set them to whatever they like.
+type IPResolver struct {
+	trusted clientip.Proxies
+}
+
+func NewIPResolver(trusted clientip.Proxies) *IPResolver {
+	return &IPResolver{trusted: trusted}
+}
+
+// ClientIP returns the client address. Behind trusted proxies it walks
+// X-Forwarded-For from the right, skipping proxy hops, and returns the
+// first address a trusted proxy saw.
+func (res *IPResolver) ClientIP(r *http.Request) string {
+	remote := remoteAddr(r)
+	if !remote.IsValid() {
+		return r.RemoteAddr
+	}
+	if !res.trusted.Contains(remote) {
+		return remote.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		if !res.trusted.Contains(addr) {
+			return addr.String()
+		}
+		remote = addr
+	}
+
+	return remote.String()
+}
+
+// RelayClientIP records the client address on the request's context, so
+// the events published for it relay the address to the services.
+func (res *IPResolver) RelayClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := clientip.NewContext(r.Context(), res.ClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+)
+
+func TestIPResolver(t *testing.T) {
+	proxies, err := clientip.ParseProxies("10.0.0.0/8, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := NewIPResolver(proxies)
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"direct", "198.51.100.1:1234", nil, "", "198.51.100.1"},
+		{"untrusted hop", "198.51.100.1:1234", []string{"203.0.113.9"}, "203.0.113.9", "198.51.100.1"},
+		{"trusted proxy", "10.0.0.2:1234", []string{"203.0.113.9"}, "", "203.0.113.9"},
+		{"spoofed head", "10.0.0.2:1234", []string{"192.0.2.66, 203.0.113.9"}, "", "203.0.113.9"},
+		{"proxy chain", "10.0.0.2:1234", []string{"203.0.113.9, 10.1.1.1"}, "", "203.0.113.9"},
+		{"repeated headers", "10.0.0.2:1234", []string{"192.0.2.66", "203.0.113.9, 10.1.1.1"}, "", "203.0.113.9"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.3.3.3, 10.1.1.1"}, "", "10.3.3.3"},
+		{"no header from proxy", "10.0.0.2:1234", nil, "203.0.113.9", "10.0.0.2"},
+		{"garbage hop", "10.0.0.2:1234", []string{"203.0.113.9, bogus, 10.1.1.1"}, "", "10.1.1.1"},
+		{"ipv6 proxy", "[2001:db8::1]:443", []string{"2001:db9::7"}, "", "2001:db9::7"},
+		{"mapped ipv4", "[::ffff:10.0.0.2]:1234", []string{"::ffff:203.0.113.9"}, "", "203.0.113.9"},
+		{"no port", "198.51.100.1", nil, "", "198.51.100.1"},
+		{"unparsable remote", "pipe", []string{"203.0.113.9"}, "", "pipe"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+			if got := res.ClientIP(r); got != tc.want {
+				t.Errorf("ClientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIPResolverWithoutProxies(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	if got := NewIPResolver(nil).ClientIP(r); got != "10.0.0.2" {
+		t.Errorf("ClientIP = %q, want the peer address", got)
+	}
+}
+
+func TestRelayClientIP(t *testing.T) {
+	proxies, _ := clientip.ParseProxies("10.0.0.0/8")
+	var got string
+	handler := NewIPResolver(proxies).RelayClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = clientip.FromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "203.0.113.9" {
+		t.Errorf("relayed %q, want 203.0.113.9", got)
+	}
+}
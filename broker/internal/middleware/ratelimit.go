@@ -1,105 +1,89 @@
 package middleware
 
 import (
+	"log"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/ratelimit"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-type RateLimiter struct {
-	mu       sync.RWMutex
-	requests map[string]*clientState
-	rate     int           // requests per window
-	window   time.Duration // time window
-	cleanup  time.Duration // cleanup interval
+var rateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "broker_rate_limit_rejections_total",
+	Help: "Requests rejected with 429, by rate limit policy.",
+}, []string{"policy"})
+
+// Policy is one rate limit applied to requests. Key names the bucket a
+// request draws from; an empty key means the policy doesn't apply.
+type Policy struct {
+	Name  string
+	Limit func(r *http.Request) ratelimit.Limit
+	Key   func(r *http.Request) string
 }
 
-type clientState struct {
-	count       int
-	windowStart time.Time
+// StaticLimit returns a Policy.Limit that always yields limit.
+func StaticLimit(limit ratelimit.Limit) func(*http.Request) ratelimit.Limit {
+	return func(*http.Request) ratelimit.Limit { return limit }
 }
 
-func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		requests: make(map[string]*clientState),
-		rate:     rate,
-		window:   window,
-		cleanup:  window * 2,
-	}
-	go rl.cleanupLoop()
-	return rl
-}
-
-func (rl *RateLimiter) cleanupLoop() {
-	ticker := time.NewTicker(rl.cleanup)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		rl.mu.Lock()
-		now := time.Now()
-		for key, state := range rl.requests {
-			if now.Sub(state.windowStart) > rl.window {
-				delete(rl.requests, key)
+// RateLimit enforces every applicable policy. The RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers describe the policy
+// closest to its limit; rejected requests get 429 with Retry-After. If the
+// store fails, requests are let through rather than failing the API.
+func RateLimit(limiter *ratelimit.Limiter, policies ...Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var tightest *ratelimit.Result
+
+			for _, p := range policies {
+				key := p.Key(r)
+				if key == "" {
+					continue
+				}
+				limit := p.Limit(r)
+				if limit.IsZero() {
+					continue
+				}
+
+				res, err := limiter.Allow(r.Context(), p.Name+":"+key, limit)
+				if err != nil {
+					log.Printf("Rate limit %s unavailable: %v", p.Name, err)
+					continue
+				}
+
+				if !res.Allowed {
+					rateLimitRejections.WithLabelValues(p.Name).Inc()
+					setRateLimitHeaders(w, res)
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+					event.WriteProblem(w, contracts.NewError(http.StatusTooManyRequests, contracts.ErrCodeRateLimited, "Rate limit exceeded"))
+					return
+				}
+				if tightest == nil || res.Remaining < tightest.Remaining {
+					tightest = &res
+				}
 			}
-		}
-		rl.mu.Unlock()
-	}
-}
-
-func (rl *RateLimiter) Allow(key string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
 
-	now := time.Now()
-	state, exists := rl.requests[key]
-
-	if !exists || now.Sub(state.windowStart) > rl.window {
-		rl.requests[key] = &clientState{
-			count:       1,
-			windowStart: now,
-		}
-		return true
-	}
-
-	if state.count >= rl.rate {
-		return false
+			if tightest != nil {
+				setRateLimitHeaders(w, *tightest)
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
-
-	state.count++
-	return true
 }
 
-func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Use IP + path as key for per-endpoint rate limiting
-		key := r.RemoteAddr + ":" + r.URL.Path
-
-		if !rl.Allow(key) {
-			w.Header().Set("Retry-After", "1")
-			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
 }
 
-// NewRateLimiterPathOnly creates a rate limiter that keys only on path (for testing)
-func NewRateLimiterPathOnly(rate int, window time.Duration) *RateLimiter {
-	return NewRateLimiter(rate, window)
-}
-
-func (rl *RateLimiter) MiddlewarePathOnly(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Use only path as key (all clients share the limit)
-		key := r.URL.Path
-
-		if !rl.Allow(key) {
-			w.Header().Set("Retry-After", "1")
-			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
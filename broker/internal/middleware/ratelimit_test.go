@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/ratelimit"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	store := ratelimit.NewMemoryStore(0)
+	defer store.Close()
+
+	// Two requests a minute per client; the route allows ten
+	perClient := Policy{
+		Name:  "client",
+		Limit: StaticLimit(ratelimit.Limit{Rate: 2, Period: time.Minute}),
+		Key:   func(r *http.Request) string { return r.Header.Get("X-Test-Client") },
+	}
+	perRoute := Policy{
+		Name:  "route",
+		Limit: StaticLimit(ratelimit.Limit{Rate: 10, Period: time.Minute}),
+		Key:   func(*http.Request) string { return "route" },
+	}
+	handler := RateLimit(ratelimit.NewLimiter(store), perRoute, perClient)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(client string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Test-Client", client)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for i, want := range []struct {
+		status                  int
+		limit, remaining, reset string
+		retryAfter              string
+	}{
+		// The client policy is the tighter one, so its state is reported
+		{http.StatusNoContent, "2", "1", "30", ""},
+		{http.StatusNoContent, "2", "0", "60", ""},
+		{http.StatusTooManyRequests, "2", "0", "60", "30"},
+	} {
+		rec := serve("a")
+		h := rec.Header()
+		if rec.Code != want.status ||
+			h.Get("RateLimit-Limit") != want.limit ||
+			h.Get("RateLimit-Remaining") != want.remaining ||
+			h.Get("RateLimit-Reset") != want.reset ||
+			h.Get("Retry-After") != want.retryAfter {
+			t.Errorf("request %d: %d limit=%s remaining=%s reset=%s retry-after=%s, want %+v", i+1, rec.Code,
+				h.Get("RateLimit-Limit"), h.Get("RateLimit-Remaining"), h.Get("RateLimit-Reset"), h.Get("Retry-After"), want)
+		}
+	}
+
+	// Another client still has its own budget, and the route's is now
+	// down to 7 of 10
+	rec := serve("b")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("other client: %d remaining=%s", rec.Code, rec.Header().Get("RateLimit-Remaining"))
+	}
+
+	// Without a client key only the route policy applies
+	rec = serve("")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Limit") != "10" || rec.Header().Get("RateLimit-Remaining") != "5" {
+		t.Errorf("route only: %d limit=%s remaining=%s", rec.Code, rec.Header().Get("RateLimit-Limit"), rec.Header().Get("RateLimit-Remaining"))
+	}
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TicketParam is the query parameter carrying a WebSocket ticket.
+const TicketParam = "ticket"
+
+// ticketScope keeps ticket signatures apart from anything else signed
+// with the same key.
+const ticketScope = "ws"
+
+// Tickets issues and checks WebSocket tickets: credentials that admit one
+// handshake and nothing else, for a few seconds. Browsers cannot set
+// headers on WebSocket handshakes, so the credential travels in the URL,
+// where it may end up in logs and histories; a ticket found there has
+// been redeemed already, or expires before long. Tickets are signed, so
+// every replica sharing the key accepts the tickets of the others; they
+// are single-use across replicas when the replicas share a TicketLedger.
+type Tickets struct {
+	key    []byte
+	ttl    time.Duration
+	now    func() time.Time
+	ledger TicketLedger
+}
+
+// TicketLedger records redeemed tickets. Redeem reports false when the
+// ticket id was redeemed before; ids need only be remembered until the
+// ticket expires.
+type TicketLedger interface {
+	Redeem(ctx context.Context, id string, expires time.Time) (bool, error)
+}
+
+// NewTickets returns tickets signed with key and valid for ttl, recording
+// redeemed tickets in memory. A nil key is replaced by a random one,
+// which only this process knows.
+func NewTickets(key []byte, ttl time.Duration) *Tickets {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	t := &Tickets{key: key, ttl: ttl, now: time.Now}
+	t.ledger = &memoryLedger{now: func() time.Time { return t.now() }, redeemed: make(map[string]time.Time)}
+	return t
+}
+
+// SetLedger records redeemed tickets in l, which replicas sharing the key
+// should share too.
+func (t *Tickets) SetLedger(l TicketLedger) {
+	t.ledger = l
+}
+
+// Issue returns a ticket for subject and when it expires.
+func (t *Tickets) Issue(subject string) (string, time.Time) {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	expires := t.now().Add(t.ttl).Truncate(time.Second)
+	claims := subject + "|" + encode(id) + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return encode([]byte(claims)) + "." + encode(t.sign(claims)), expires
+}
+
+// Authenticate implements Authenticator for requests carrying a ticket in
+// the TicketParam query parameter.
+func (t *Tickets) Authenticate(r *http.Request) (*Identity, error) {
+	claimsPart, sigPart, ok := strings.Cut(r.URL.Query().Get(TicketParam), ".")
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(claimsPart)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, t.sign(string(claims))) {
+		return nil, ErrUnauthenticated
+	}
+
+	// subject|id|expires; the subject may itself contain '|'
+	rest, expiresPart, ok := cutLast(string(claims))
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	subject, id, ok := cutLast(rest)
+	if !ok || id == "" {
+		return nil, ErrUnauthenticated
+	}
+	unix, err := strconv.ParseInt(expiresPart, 10, 64)
+	expires := time.Unix(unix, 0)
+	if err != nil || !t.now().Before(expires) {
+		return nil, ErrUnauthenticated
+	}
+
+	if fresh, err := t.ledger.Redeem(r.Context(), id, expires); err != nil || !fresh {
+		return nil, ErrUnauthenticated
+	}
+	return &Identity{Subject: subject}, nil
+}
+
+func cutLast(s string) (before, after string, ok bool) {
+	i := strings.LastIndexByte(s, '|')
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func (t *Tickets) sign(claims string) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(ticketScope + "|" + claims))
+	return mac.Sum(nil)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// memoryLedger remembers the tickets this process redeemed.
+type memoryLedger struct {
+	now      func() time.Time
+	mu       sync.Mutex
+	redeemed map[string]time.Time // ticket id -> expiry
+}
+
+func (l *memoryLedger) Redeem(ctx context.Context, id string, expires time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for known, until := range l.redeemed {
+		if !now.Before(until) {
+			delete(l.redeemed, known)
+		}
+	}
+	if _, ok := l.redeemed[id]; ok {
+		return false, nil
+	}
+	l.redeemed[id] = expires
+	return true, nil
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ticketRequest(ticket string) *url.URL {
+	return &url.URL{Path: "/api/v1/ws", RawQuery: url.Values{TicketParam: {ticket}}.Encode()}
+}
+
+func TestTickets(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tickets := NewTickets([]byte("secret"), 30*time.Second)
+	tickets.now = func() time.Time { return now }
+
+	ticket, expires := tickets.Issue("dashboard|admin")
+	if want := now.Add(30 * time.Second); !expires.Equal(want) {
+		t.Errorf("expires at %v, want %v", expires, want)
+	}
+
+	authenticate := func(ticket string) (*Identity, error) {
+		r := httptest.NewRequest("GET", "/api/v1/ws", nil)
+		r.URL = ticketRequest(ticket)
+		return tickets.Authenticate(r)
+	}
+
+	id, err := authenticate(ticket)
+	if err != nil || id.Subject != "dashboard|admin" {
+		t.Fatalf("valid ticket: %+v, %v", id, err)
+	}
+	if _, err := authenticate(ticket); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("replayed ticket: err = %v, want ErrUnauthenticated", err)
+	}
+
+	claims, sig, _ := strings.Cut(ticket, ".")
+	other := NewTickets([]byte("other"), 30*time.Second)
+	other.now = tickets.now
+	forged, _ := other.Issue("dashboard|admin")
+	tampered := encode([]byte("root|"+strconv.FormatInt(expires.Unix(), 10))) + "." + sig
+
+	for name, ticket := range map[string]string{
+		"missing":    "",
+		"no dot":     claims,
+		"bad base64": claims + ".!!",
+		"tampered":   tampered,
+		"wrong key":  forged,
+	} {
+		if _, err := authenticate(ticket); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: err = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+
+	late, _ := tickets.Issue("dashboard|admin")
+	expiring, _ := tickets.Issue("dashboard|admin")
+	now = now.Add(29 * time.Second)
+	if _, err := authenticate(late); err != nil {
+		t.Errorf("ticket rejected before it expired: %v", err)
+	}
+	now = now.Add(time.Second)
+	if _, err := authenticate(expiring); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expired ticket: err = %v, want ErrUnauthenticated", err)
+	}
+}
+
+// ledgerFunc adapts a function to TicketLedger.
+type ledgerFunc func(id string, expires time.Time) (bool, error)
+
+func (f ledgerFunc) Redeem(ctx context.Context, id string, expires time.Time) (bool, error) {
+	return f(id, expires)
+}
+
+func TestTicketsShareLedger(t *testing.T) {
+	// Two replicas with the same key and ledger: a ticket redeemed on one
+	// is refused by the other
+	redeemed := make(map[string]time.Time)
+	ledger := ledgerFunc(func(id string, expires time.Time) (bool, error) {
+		if _, ok := redeemed[id]; ok {
+			return false, nil
+		}
+		redeemed[id] = expires
+		return true, nil
+	})
+	a := NewTickets([]byte("secret"), time.Minute)
+	b := NewTickets([]byte("secret"), time.Minute)
+	a.SetLedger(ledger)
+	b.SetLedger(ledger)
+
+	ticket, expires := a.Issue("dashboard|alice")
+	r := httptest.NewRequest("GET", "/api/v1/ws?"+TicketParam+"="+ticket, nil)
+	if id, err := b.Authenticate(r); err != nil || id.Subject != "dashboard|alice" {
+		t.Fatalf("other replica: %+v, %v", id, err)
+	}
+	if _, err := a.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ticket redeemed twice across replicas: err = %v", err)
+	}
+	for _, until := range redeemed {
+		if !until.Equal(expires) {
+			t.Errorf("ledger keeps the ticket until %v, want %v", until, expires)
+		}
+	}
+
+	// A ledger that can't be reached admits nobody
+	a.SetLedger(ledgerFunc(func(string, time.Time) (bool, error) { return false, errors.New("redis down") }))
+	ticket, _ = a.Issue("dashboard|alice")
+	r = httptest.NewRequest("GET", "/api/v1/ws?"+TicketParam+"="+ticket, nil)
+	if _, err := a.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("ledger failure: err = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestMemoryLedgerForgetsExpiredTickets(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tickets := NewTickets([]byte("secret"), 30*time.Second)
+	tickets.now = func() time.Time { return now }
+	ledger := tickets.ledger.(*memoryLedger)
+
+	for range 3 {
+		ticket, _ := tickets.Issue("dashboard")
+		r := httptest.NewRequest("GET", "/api/v1/ws?"+TicketParam+"="+ticket, nil)
+		if _, err := tickets.Authenticate(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(ledger.redeemed); n != 3 {
+		t.Fatalf("%d tickets recorded, want 3", n)
+	}
+
+	now = now.Add(30 * time.Second)
+	ledger.Redeem(context.Background(), "other", now.Add(time.Second))
+	if n := len(ledger.redeemed); n != 1 {
+		t.Errorf("%d tickets recorded after they expired, want 1", n)
+	}
+}
+
+func TestTicketsNeedSharedKey(t *testing.T) {
+	a := NewTickets(nil, time.Minute)
+	b := NewTickets(nil, time.Minute)
+
+	ticket, _ := a.Issue("dashboard")
+	r := httptest.NewRequest("GET", "/api/v1/ws?"+TicketParam+"="+ticket, nil)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Errorf("issuer rejected its ticket: %v", err)
+	}
+	if _, err := b.Authenticate(r); err == nil {
+		t.Error("random keys must differ between processes")
+	}
+}
@@ -0,0 +1,179 @@
+package openapi
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// Endpoint documents a fixed broker endpoint outside the route table.
+type Endpoint struct {
+	Method    string
+	Path      string
+	Operation *Operation
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Generate builds the document for the routes in t plus the fixed
+// endpoints. It fails if a route names an unknown contracts type.
+func Generate(t *routing.Table, fixed []Endpoint) (*Document, error) {
+	s := newSchemas()
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:   "E-commerce broker API",
+			Version: "v1",
+			Description: "Public API of the broker. Event-backed endpoints are generated from " +
+				"the broker's route table. Errors are problem details (RFC 9457) in the ProblemPayload shape.",
+		},
+		Paths: make(map[string]PathItem),
+		Components: Components{
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer"},
+			},
+		},
+	}
+
+	// Always present: every error response refers to ProblemPayload, and
+	// the job, batch and ticket endpoints to Job and the batch and ticket types
+	errorSchema := s.of(Types["ProblemPayload"])
+	for _, name := range []string{"Job", "BatchRequest", "BatchResponse", "WSTicketRequest", "WSTicket"} {
+		s.of(Types[name])
+	}
+
+	for _, route := range t.Routes {
+		op, err := routeOperation(s, route, errorSchema)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", route.ID(), err)
+		}
+		doc.add(route.Method, route.Path, op)
+	}
+	for _, e := range fixed {
+		doc.add(e.Method, e.Path, e.Operation)
+	}
+
+	doc.Components.Schemas = s.components
+	return doc, nil
+}
+
+func (d *Document) add(method, path string, op *Operation) {
+	path = pathParam.ReplaceAllString(path, "{$1}")
+	item, ok := d.Paths[path]
+	if !ok {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+func routeOperation(s *schemas, route routing.Route, errorSchema *Schema) (*Operation, error) {
+	domain, _, _ := strings.Cut(route.Topic, ".")
+	op := &Operation{
+		OperationID: route.Topic,
+		Summary:     route.Summary,
+		Description: fmt.Sprintf("Publishes the %s event on topic %s.", route.Event, route.Topic),
+		Tags:        []string{domain},
+		Responses:   make(map[string]Response),
+	}
+
+	for _, m := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name: m[1], In: "path", Required: true, Schema: &Schema{Type: "string"},
+		})
+	}
+	for _, name := range route.QueryParams {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name: name, In: "query", Schema: &Schema{Type: "string"},
+		})
+	}
+
+	errorResponse := func(description string) Response {
+		return Response{Description: description, Content: problemContent(errorSchema)}
+	}
+
+	if route.Body {
+		body := &Schema{Type: "object"}
+		if route.Request != "" {
+			var err error
+			if body, err = s.named(route.Request); err != nil {
+				return nil, err
+			}
+		}
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(body)}
+
+		// Bodies are checked at the edge
+		op.Responses["400"] = errorResponse("Invalid request; validation failures list each invalid field under errors")
+		op.Responses["413"] = errorResponse(fmt.Sprintf("Request body larger than %d bytes", route.MaxBody))
+		op.Responses["415"] = errorResponse("Request body is not application/json")
+	} else if len(op.Parameters) > 0 {
+		op.Responses["400"] = errorResponse("Invalid request")
+	}
+
+	if route.Method != http.MethodGet {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        "Idempotency-Key",
+			In:          "header",
+			Description: "Makes retries safe: the first response is replayed for repeated requests with the same key.",
+			Schema:      &Schema{Type: "string"},
+		})
+		op.Responses["409"] = errorResponse("A request with the same Idempotency-Key is still in progress")
+		op.Responses["422"] = errorResponse("The Idempotency-Key was used for a different request")
+	}
+
+	switch route.Auth {
+	case routing.AuthRequired:
+		op.Security = []map[string][]string{{"bearerAuth": {}}}
+		op.Responses["401"] = errorResponse("Missing or invalid credentials")
+	case routing.AuthOptional:
+		op.Security = []map[string][]string{{"bearerAuth": {}}, {}}
+	}
+
+	op.Responses["429"] = errorResponse("Rate limit exceeded")
+	op.Responses["503"] = errorResponse("Upstream unavailable; retry after the Retry-After delay")
+	op.Responses["default"] = errorResponse("Error reported by the upstream service")
+
+	if route.Mode == routing.ModeAsync {
+		op.Responses["202"] = Response{
+			Description: "Accepted; poll the job at the Location header or stream /api/v1/jobs/{id}/events",
+			Headers:     map[string]Header{"Location": {Schema: &Schema{Type: "string"}}},
+			Content:     jsonContent(s.of(Types["Job"])),
+		}
+		return op, nil
+	}
+
+	result := &Schema{Description: "Any JSON value"}
+	if route.Response != "" {
+		var err error
+		if result, err = s.named(route.Response); err != nil {
+			return nil, err
+		}
+	}
+	ok := Response{Description: "Successful reply", Content: jsonContent(result)}
+	if route.Cache.TTL > 0 {
+		ok.Headers = map[string]Header{
+			"ETag":          {Schema: &Schema{Type: "string"}},
+			"Cache-Control": {Schema: &Schema{Type: "string"}},
+		}
+		op.Responses["304"] = Response{Description: "Not modified since the ETag in If-None-Match"}
+	}
+	op.Responses["200"] = ok
+	op.Responses["504"] = errorResponse("Upstream did not reply in time")
+
+	return op, nil
+}
+
+// DocsHTML is a self-contained page rendering the document served next to
+// it as openapi.json.
+//
+//go:embed docs.html
+var DocsHTML []byte
+
+func problemContent(s *Schema) map[string]MediaType {
+	return map[string]MediaType{contracts.ProblemContentType: {Schema: s}}
+}
@@ -0,0 +1,294 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/jobs"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// Types are the named types routes may refer to as request or response.
+var Types = map[string]reflect.Type{
+	"Payload":              reflect.TypeFor[contracts.Payload](),
+	"ErrorPayload":         reflect.TypeFor[contracts.ErrorPayload](),
+	"ProblemPayload":       reflect.TypeFor[contracts.ProblemPayload](),
+	"AuthRegisterRequest":  reflect.TypeFor[contracts.AuthRegisterRequest](),
+	"AuthRegisterResponse": reflect.TypeFor[contracts.AuthRegisterResponse](),
+	"AuthLoginRequest":     reflect.TypeFor[contracts.AuthLoginRequest](),
+	"AuthLoginResponse":    reflect.TypeFor[contracts.AuthLoginResponse](),
+	"AuthUser":             reflect.TypeFor[contracts.AuthUser](),
+	"BatchRequest":         reflect.TypeFor[contracts.BatchRequest](),
+	"BatchResponse":        reflect.TypeFor[contracts.BatchResponse](),
+	"Job":                  reflect.TypeFor[jobs.Job](),
+	"WSTicket":             reflect.TypeFor[contracts.WSTicket](),
+	"WSTicketRequest":      reflect.TypeFor[contracts.WSTicketRequest](),
+}
+
+// errorCodes lists every ErrorCode so clients can see the full set.
+var errorCodes = []contracts.ErrorCode{
+	contracts.ErrCodeInvalidPayload,
+	contracts.ErrCodePayloadTooLarge,
+	contracts.ErrCodeUnsupportedMedia,
+	contracts.ErrCodeValidation,
+	contracts.ErrCodeNotFound,
+	contracts.ErrCodeConflict,
+	contracts.ErrCodeEmailTaken,
+	contracts.ErrCodeInvalidCredentials,
+	contracts.ErrCodeUnauthorized,
+	contracts.ErrCodeRateLimited,
+	contracts.ErrCodeChallengeRequired,
+	contracts.ErrCodeUnavailable,
+	contracts.ErrCodeTimeout,
+	contracts.ErrCodeBadGateway,
+	contracts.ErrCodeInternal,
+	contracts.ErrCodeIdempotencyReused,
+	contracts.ErrCodeRequestInProgress,
+	contracts.ErrCodeQueryTooComplex,
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+	errorCodeType  = reflect.TypeFor[contracts.ErrorCode]()
+)
+
+// schemas collects component schemas while types are converted.
+type schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	s := &schemas{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+	for name, t := range Types {
+		s.names[t] = name
+	}
+	return s
+}
+
+// named resolves a type name from a route, such as "AuthUser" or
+// "[]AuthUser", into a schema.
+func (s *schemas) named(name string) (*Schema, error) {
+	if elem, ok := strings.CutPrefix(name, "[]"); ok {
+		items, err := s.named(elem)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	}
+
+	t, ok := Types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown contracts type %q", name)
+	}
+	return s.of(t), nil
+}
+
+// of returns the schema for t, referencing a component for named types.
+func (s *schemas) of(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{Description: "Any JSON value"}
+	case errorCodeType:
+		enum := make([]string, len(errorCodes))
+		for i, code := range errorCodes {
+			enum[i] = string(code)
+		}
+		return &Schema{Type: "string", Enum: enum}
+	}
+
+	if name, ok := s.names[t]; ok {
+		if _, done := s.components[name]; !done {
+			// Placeholder first, so recursive types terminate
+			s.components[name] = &Schema{}
+			*s.components[name] = *s.inline(t)
+		}
+		return ref(name)
+	}
+	return s.inline(t)
+}
+
+func (s *schemas) inline(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.of(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+		copied := *schema
+		copied.Nullable = true
+		return &copied
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		s.fields(t, schema)
+		return schema
+	default:
+		return &Schema{}
+	}
+}
+
+// fields adds t's JSON fields to schema, flattening embedded structs the
+// way encoding/json does.
+func (s *schemas) fields(t reflect.Type, schema *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			s.fields(f.Type, schema)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		field := s.of(f.Type)
+		optional := strings.Contains(opts, "omitempty")
+		if tag, ok := f.Tag.Lookup("jsonschema"); ok {
+			copied := *field
+			field = &copied
+			optional = applyConstraints(field, tag) || optional
+		}
+
+		schema.Properties[name] = field
+		if !optional {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// applyConstraints adds the keywords of a jsonschema struct tag to schema
+// and reports whether the tag marks the field optional.
+func applyConstraints(schema *Schema, tag string) (optional bool) {
+	for _, kw := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(kw), "=")
+		switch key {
+		case "optional":
+			optional = true
+		case "format":
+			schema.Format = value
+		case "pattern":
+			schema.Pattern = value
+		case "minLength":
+			schema.MinLength = intPtr(value)
+		case "maxLength":
+			schema.MaxLength = intPtr(value)
+		case "minimum":
+			schema.Minimum = floatPtr(value)
+		case "maximum":
+			schema.Maximum = floatPtr(value)
+		case "minItems":
+			schema.MinItems = intPtr(value)
+		case "maxItems":
+			schema.MaxItems = intPtr(value)
+		}
+	}
+	return optional
+}
+
+func intPtr(s string) *int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func floatPtr(s string) *float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// JSONSchema returns the JSON Schema (draft 2020-12) of a named type, as
+// decoded JSON, with referenced types under $defs. OpenAPI's nullable
+// becomes a "null" alternative in the type.
+func JSONSchema(name string) (map[string]any, error) {
+	s := newSchemas()
+	root, err := s.named(name)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, ok := toJSONSchema(root).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("schema for %q is not an object", name)
+	}
+	defs := make(map[string]any, len(s.components))
+	for n, c := range s.components {
+		defs[n] = toJSONSchema(c)
+	}
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["$defs"] = defs
+	return doc, nil
+}
+
+func toJSONSchema(schema *Schema) any {
+	data, _ := json.Marshal(schema)
+	var v any
+	json.Unmarshal(data, &v)
+	return rewriteJSONSchema(v)
+}
+
+func rewriteJSONSchema(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			v[k] = rewriteJSONSchema(child)
+		}
+		if ref, ok := v["$ref"].(string); ok {
+			v["$ref"] = strings.Replace(ref, "#/components/schemas/", "#/$defs/", 1)
+		}
+		if nullable, _ := v["nullable"].(bool); nullable {
+			delete(v, "nullable")
+			if t, ok := v["type"].(string); ok {
+				v["type"] = []any{t, "null"}
+			}
+		}
+		return v
+	case []any:
+		for i, child := range v {
+			v[i] = rewriteJSONSchema(child)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,103 @@
+// Package ratelimit implements GCRA, the generic cell rate algorithm: a
+// token bucket stored as a single timestamp per key, the theoretical
+// arrival time (TAT) of the next request. Storing one value makes the
+// algorithm easy to run against a shared store with compare-and-swap.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Rate requests per Period on average, with bursts of up to
+// Burst requests. A zero Limit means unlimited.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// PerSecond returns a limit of rate requests per second with an equal burst.
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second, Burst: rate}
+}
+
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Period <= 0
+}
+
+// interval is the time one request uses up from the bucket.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Rate)
+}
+
+func (l Limit) burst() int {
+	if l.Burst <= 0 {
+		return l.Rate
+	}
+	return l.Burst
+}
+
+func (l Limit) String() string {
+	if l.IsZero() {
+		return "unlimited"
+	}
+	s := fmt.Sprintf("%d/%s", l.Rate, l.Period)
+	if l.Burst > 0 && l.Burst != l.Rate {
+		s += ":" + strconv.Itoa(l.Burst)
+	}
+	return s
+}
+
+// ParseLimit reads limits written as "rate/period[:burst]", e.g. "100/s",
+// "20/1m" or "5/10s:10". Period units are Go durations; a bare unit means
+// one of it.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	spec, burstSpec, hasBurst := strings.Cut(s, ":")
+
+	rateSpec, periodSpec, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q: want rate/period", s)
+	}
+
+	rate, err := strconv.Atoi(strings.TrimSpace(rateSpec))
+	if err != nil || rate <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: invalid rate", s)
+	}
+
+	periodSpec = strings.TrimSpace(periodSpec)
+	if periodSpec != "" && (periodSpec[0] < '0' || periodSpec[0] > '9') {
+		periodSpec = "1" + periodSpec
+	}
+	period, err := time.ParseDuration(periodSpec)
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: invalid period", s)
+	}
+
+	limit := Limit{Rate: rate, Period: period, Burst: rate}
+	if hasBurst {
+		burst, err := strconv.Atoi(strings.TrimSpace(burstSpec))
+		if err != nil || burst <= 0 {
+			return Limit{}, fmt.Errorf("rate limit %q: invalid burst", s)
+		}
+		limit.Burst = burst
+	}
+	return limit, nil
+}
+
+// UnmarshalText lets limits be written as strings in config files.
+func (l *Limit) UnmarshalText(text []byte) error {
+	parsed, err := ParseLimit(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
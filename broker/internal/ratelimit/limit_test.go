@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for spec, want := range map[string]Limit{
+		"100/s":     {Rate: 100, Period: time.Second, Burst: 100},
+		" 20/1m ":   {Rate: 20, Period: time.Minute, Burst: 20},
+		"5/10s:10":  {Rate: 5, Period: 10 * time.Second, Burst: 10},
+		"1/h":       {Rate: 1, Period: time.Hour, Burst: 1},
+		"3/500ms:1": {Rate: 3, Period: 500 * time.Millisecond, Burst: 1},
+	} {
+		got, err := ParseLimit(spec)
+		if err != nil || got != want {
+			t.Errorf("ParseLimit(%q) = %+v, %v; want %+v", spec, got, err, want)
+		}
+	}
+
+	for _, spec := range []string{"", "100", "0/s", "-1/s", "x/s", "10/", "10/0s", "10/fortnight", "10/s:0", "10/s:x"} {
+		if _, err := ParseLimit(spec); err == nil {
+			t.Errorf("ParseLimit(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestLimitString(t *testing.T) {
+	for _, tc := range []struct {
+		limit Limit
+		want  string
+	}{
+		{Limit{}, "unlimited"},
+		{PerSecond(100), "100/1s"},
+		{Limit{Rate: 5, Period: 10 * time.Second, Burst: 10}, "5/10s:10"},
+	} {
+		if got := tc.limit.String(); got != tc.want {
+			t.Errorf("%+v: %q, want %q", tc.limit, got, tc.want)
+		}
+		if tc.limit.IsZero() {
+			continue
+		}
+		parsed, err := ParseLimit(tc.want)
+		if err != nil || parsed != tc.limit {
+			t.Errorf("%q does not round-trip: %+v, %v", tc.want, parsed, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// maxAttempts bounds compare-and-swap retries when many requests for the
+// same key race.
+const maxAttempts = 10
+
+var ErrContention = errors.New("rate limit state contended")
+
+// Result describes a rate limit decision, in the terms of the RateLimit
+// response headers.
+type Result struct {
+	Allowed    bool
+	Limit      int           // burst size
+	Remaining  int           // requests left right now
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request would be allowed, when denied
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes one request from key's bucket under limit.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.IsZero() {
+		return Result{Allowed: true}, nil
+	}
+
+	interval := int64(limit.interval())
+	burst := limit.burst()
+	tolerance := interval * int64(burst)
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		now := l.now().UnixNano()
+
+		stored, err := l.store.Get(ctx, key)
+		if err != nil {
+			return Result{}, err
+		}
+
+		tat := stored
+		if tat < now {
+			tat = now
+		}
+
+		newTAT := tat + interval
+		allowAt := newTAT - tolerance
+		if now < allowAt {
+			return Result{
+				Limit:      burst,
+				Reset:      time.Duration(tat - now),
+				RetryAfter: time.Duration(allowAt - now),
+			}, nil
+		}
+
+		ttl := time.Duration(newTAT - now)
+		var ok bool
+		if stored == 0 {
+			ok, err = l.store.SetIfNotExists(ctx, key, newTAT, ttl)
+		} else {
+			ok, err = l.store.CompareAndSwap(ctx, key, stored, newTAT, ttl)
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		if !ok {
+			continue
+		}
+
+		return Result{
+			Allowed:   true,
+			Limit:     burst,
+			Remaining: int((tolerance - (newTAT - now)) / interval),
+			Reset:     ttl,
+		}, nil
+	}
+
+	return Result{}, ErrContention
+}
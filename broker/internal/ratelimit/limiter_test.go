@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newLimiter(store Store) (*Limiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(store)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestBurstAndRefill(t *testing.T) {
+	store := NewMemoryStore(0)
+	defer store.Close()
+	l, now := newLimiter(store)
+	ctx := context.Background()
+
+	// One request per second on average, three at once
+	limit := Limit{Rate: 10, Period: 10 * time.Second, Burst: 3}
+
+	for i, want := range []Result{
+		{Allowed: true, Limit: 3, Remaining: 2, Reset: time.Second},
+		{Allowed: true, Limit: 3, Remaining: 1, Reset: 2 * time.Second},
+		{Allowed: true, Limit: 3, Remaining: 0, Reset: 3 * time.Second},
+		{Allowed: false, Limit: 3, Remaining: 0, Reset: 3 * time.Second, RetryAfter: time.Second},
+	} {
+		got, err := l.Allow(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("request %d: %+v, want %+v", i+1, got, want)
+		}
+	}
+
+	// One interval later a single request fits again
+	*now = now.Add(time.Second)
+	if got, _ := l.Allow(ctx, "k", limit); !got.Allowed || got.Remaining != 0 {
+		t.Errorf("after one interval: %+v, want allowed with nothing left", got)
+	}
+	if got, _ := l.Allow(ctx, "k", limit); got.Allowed {
+		t.Errorf("second request after one interval: %+v, want denied", got)
+	}
+
+	// After a long pause the bucket is full, not fuller
+	*now = now.Add(time.Hour)
+	for i := range 3 {
+		if got, _ := l.Allow(ctx, "k", limit); !got.Allowed || got.Remaining != 2-i {
+			t.Errorf("request %d after a pause: %+v", i+1, got)
+		}
+	}
+	if got, _ := l.Allow(ctx, "k", limit); got.Allowed {
+		t.Errorf("burst exceeded after a pause: %+v", got)
+	}
+
+	if got, _ := l.Allow(ctx, "other", limit); !got.Allowed {
+		t.Errorf("other key limited: %+v", got)
+	}
+}
+
+func TestZeroLimitAllows(t *testing.T) {
+	l, _ := newLimiter(failingStore{})
+	if got, err := l.Allow(context.Background(), "k", Limit{}); err != nil || !got.Allowed {
+		t.Errorf("zero limit: %+v, %v", got, err)
+	}
+}
+
+// racingStore loses the first swaps to another writer, as when replicas
+// race for a key. The other writer moves the TAT on by take each time.
+type racingStore struct {
+	*MemoryStore
+	losses int
+	take   time.Duration
+	swaps  atomic.Int64
+}
+
+func (s *racingStore) CompareAndSwap(ctx context.Context, key string, old, tat int64, ttl time.Duration) (bool, error) {
+	if s.swaps.Add(1) <= int64(s.losses) {
+		s.MemoryStore.CompareAndSwap(ctx, key, old, old+int64(s.take), ttl)
+		return false, nil
+	}
+	return s.MemoryStore.CompareAndSwap(ctx, key, old, tat, ttl)
+}
+
+func TestCompareAndSwapRetries(t *testing.T) {
+	store := &racingStore{MemoryStore: NewMemoryStore(0), losses: 2, take: time.Second}
+	defer store.Close()
+	l, _ := newLimiter(store)
+	ctx := context.Background()
+	limit := Limit{Rate: 10, Period: 10 * time.Second, Burst: 10}
+
+	l.Allow(ctx, "k", limit) // creates the key
+	got, err := l.Allow(ctx, "k", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One request of ours and the two the other writer took
+	if !got.Allowed || got.Remaining != 10-4 {
+		t.Errorf("after lost swaps: %+v, want allowed with 6 left", got)
+	}
+	if n := store.swaps.Load(); n != 3 {
+		t.Errorf("%d swaps, want 3", n)
+	}
+}
+
+func TestContention(t *testing.T) {
+	store := &racingStore{MemoryStore: NewMemoryStore(0), losses: 1 << 30}
+	defer store.Close()
+	l, _ := newLimiter(store)
+	ctx := context.Background()
+	limit := Limit{Rate: 1000, Period: time.Second}
+
+	l.Allow(ctx, "k", limit)
+	if _, err := l.Allow(ctx, "k", limit); !errors.Is(err, ErrContention) {
+		t.Errorf("err = %v, want ErrContention", err)
+	}
+	if n := store.swaps.Load(); n != maxAttempts {
+		t.Errorf("%d swaps, want %d", n, maxAttempts)
+	}
+}
+
+func TestConcurrentRequestsShareOneBucket(t *testing.T) {
+	store := NewMemoryStore(0)
+	defer store.Close()
+	l, _ := newLimiter(store) // the clock stands still, so nothing refills
+	limit := Limit{Rate: 20, Period: time.Minute}
+
+	var allowed, contended atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				res, err := l.Allow(context.Background(), "k", limit)
+				switch {
+				case errors.Is(err, ErrContention):
+					contended.Add(1)
+				case err != nil:
+					t.Error(err)
+				case res.Allowed:
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() > 20 {
+		t.Errorf("%d requests allowed, burst is 20", allowed.Load())
+	}
+	if contended.Load() == 0 && allowed.Load() != 20 {
+		t.Errorf("%d requests allowed, want 20", allowed.Load())
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Get(context.Context, string) (int64, error) {
+	return 0, errors.New("down")
+}
+
+func (failingStore) SetIfNotExists(context.Context, string, int64, time.Duration) (bool, error) {
+	return false, errors.New("down")
+}
+
+func (failingStore) CompareAndSwap(context.Context, string, int64, int64, time.Duration) (bool, error) {
+	return false, errors.New("down")
+}
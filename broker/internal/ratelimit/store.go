@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Store holds the TAT of every key as Unix nanoseconds. Its operations
+// match what Redis offers (GET, SET NX PX, and a compare-and-swap script),
+// so a shared store lets all broker replicas enforce one budget.
+type Store interface {
+	// Get returns the stored TAT for key, or 0 if there is none.
+	Get(ctx context.Context, key string) (int64, error)
+	// SetIfNotExists stores tat unless key already has a value.
+	SetIfNotExists(ctx context.Context, key string, tat int64, ttl time.Duration) (bool, error)
+	// CompareAndSwap stores tat if key still holds old.
+	CompareAndSwap(ctx context.Context, key string, old, tat int64, ttl time.Duration) (bool, error)
+}
+
+const shardCount = 64
+
+// MemoryStore is a Store sharded by key hash, so concurrent requests for
+// different clients rarely contend on the same lock.
+type MemoryStore struct {
+	shards [shardCount]shard
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type shard struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	tat     int64
+	expires int64
+}
+
+// NewMemoryStore returns a store that drops expired keys every
+// cleanupInterval until Close is called.
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	if cleanupInterval <= 0 {
+		cleanupInterval = time.Minute
+	}
+
+	m := &MemoryStore{stop: make(chan struct{})}
+	for i := range m.shards {
+		m.shards[i].entries = make(map[string]entry)
+	}
+	go m.cleanupLoop(cleanupInterval)
+	return m
+}
+
+func (m *MemoryStore) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &m.shards[h.Sum32()%shardCount]
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (int64, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || e.expires < time.Now().UnixNano() {
+		return 0, nil
+	}
+	return e.tat, nil
+}
+
+func (m *MemoryStore) SetIfNotExists(ctx context.Context, key string, tat int64, ttl time.Duration) (bool, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	if e, ok := s.entries[key]; ok && e.expires >= now {
+		return false, nil
+	}
+	s.entries[key] = entry{tat: tat, expires: now + int64(ttl)}
+	return true, nil
+}
+
+func (m *MemoryStore) CompareAndSwap(ctx context.Context, key string, old, tat int64, ttl time.Duration) (bool, error) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	e, ok := s.entries[key]
+	if !ok || e.expires < now || e.tat != old {
+		return false, nil
+	}
+	s.entries[key] = entry{tat: tat, expires: now + int64(ttl)}
+	return true, nil
+}
+
+// Close stops the cleanup goroutine.
+func (m *MemoryStore) Close() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+func (m *MemoryStore) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.cleanup()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *MemoryStore) cleanup() {
+	now := time.Now().UnixNano()
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for key, e := range s.entries {
+			if e.expires < now {
+				delete(s.entries, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
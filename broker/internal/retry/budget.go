@@ -0,0 +1,39 @@
+package retry
+
+import "sync"
+
+// Budget is a token bucket filled by requests rather than time: every
+// request adds ratio tokens and every retry or hedge takes one, so extra
+// requests stay at about ratio per request. It starts with reserve tokens
+// and holds no more.
+type Budget struct {
+	ratio   float64
+	reserve float64
+
+	mu      sync.Mutex
+	balance float64
+}
+
+func NewBudget(ratio float64, reserve int) *Budget {
+	return &Budget{ratio: ratio, reserve: float64(reserve), balance: float64(reserve)}
+}
+
+// Deposit credits one request.
+func (b *Budget) Deposit() {
+	b.mu.Lock()
+	b.balance = min(b.balance+b.ratio, b.reserve)
+	b.mu.Unlock()
+}
+
+// Withdraw takes a token for one retry or hedge, reporting false when none
+// is left.
+func (b *Budget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.balance < 1 {
+		return false
+	}
+	b.balance--
+	return true
+}
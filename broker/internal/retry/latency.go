@@ -0,0 +1,57 @@
+package retry
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples is how many recent replies percentiles are taken over
+	latencySamples = 200
+	// minLatencySamples is how many replies make a percentile meaningful
+	minLatencySamples = 20
+)
+
+// Latency keeps the durations of a route's most recent replies.
+type Latency struct {
+	mu      sync.Mutex
+	samples []time.Duration // ring buffer
+	next    int
+	sorted  []time.Duration // samples sorted, nil when stale
+}
+
+func NewLatency() *Latency {
+	return &Latency{samples: make([]time.Duration, 0, latencySamples)}
+}
+
+// Observe records the duration of one reply.
+func (l *Latency) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, d)
+	} else {
+		l.samples[l.next] = d
+		l.next = (l.next + 1) % latencySamples
+	}
+	l.sorted = nil
+}
+
+// Percentile returns the duration that fraction p of recent replies took
+// at most. It reports false until enough replies were observed.
+func (l *Latency) Percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.samples) < minLatencySamples {
+		return 0, false
+	}
+	if l.sorted == nil {
+		l.sorted = slices.Clone(l.samples)
+		slices.Sort(l.sorted)
+	}
+	i := int(p*float64(len(l.sorted))+0.5) - 1
+	return l.sorted[max(0, min(i, len(l.sorted)-1))], true
+}
@@ -0,0 +1,18 @@
+package retry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_rpc_retries_total",
+		Help: "RPC tries after a failed one, by topic.",
+	}, []string{"topic"})
+
+	budgetExhausted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_rpc_retry_budget_exhausted_total",
+		Help: "Retries not made because the route's retry budget was spent, by topic.",
+	}, []string{"topic"})
+)
@@ -0,0 +1,162 @@
+// Package retry retries and hedges the RPCs of idempotent routes. Extra
+// requests are paid from a budget per route that requests refill, so a
+// struggling service sees a bounded share of additional load rather than
+// a multiple of it.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+)
+
+// budgetReserve is how many retries a route may spend before its requests
+// earned any, so occasional failures on quiet routes are retried too.
+const budgetReserve = 10
+
+// Caller makes one try of an RPC, hedged as asked; event.Emitter's
+// CallHedged is one.
+type Caller func(ctx context.Context, hedge event.Hedge) (*contracts.Reply, error)
+
+// Route runs the RPCs of one route under its policy.
+type Route struct {
+	topic   string
+	policy  routing.RetryPolicy
+	budget  *Budget
+	latency *Latency
+}
+
+// NewRoute returns the retry state of route, starting with a full budget
+// reserve and no latencies.
+func NewRoute(route routing.Route) *Route {
+	return &Route{
+		topic:   route.Topic,
+		policy:  route.Retry,
+		budget:  NewBudget(route.Retry.Budget, budgetReserve),
+		latency: NewLatency(),
+	}
+}
+
+// Do calls until a try succeeds or fails for good, no tries or budget are
+// left, or ctx ends, and returns the last try's outcome. Tries time out
+// after the policy's attempt timeout, except the last one, which has
+// whatever is left of ctx.
+func (r *Route) Do(ctx context.Context, call Caller) (*contracts.Reply, error) {
+	r.budget.Deposit()
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		reply, err := r.try(ctx, call, attempt)
+		if err == nil {
+			r.latency.Observe(time.Since(start))
+		}
+
+		if attempt >= r.policy.Attempts || !retriable(reply, err) || ctx.Err() != nil {
+			return reply, err
+		}
+
+		delay := Backoff(r.policy, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return reply, err
+		}
+		if !r.budget.Withdraw() {
+			budgetExhausted.WithLabelValues(r.topic).Inc()
+			return reply, err
+		}
+		retries.WithLabelValues(r.topic).Inc()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return reply, err
+		}
+	}
+}
+
+func (r *Route) try(ctx context.Context, call Caller, attempt int) (*contracts.Reply, error) {
+	if attempt < r.policy.Attempts {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.policy.AttemptTimeout)
+		defer cancel()
+	}
+
+	var hedge event.Hedge
+	if r.policy.Hedge {
+		hedge = event.Hedge{After: r.hedgeDelay(), Allow: r.budget.Withdraw}
+	}
+	return call(ctx, hedge)
+}
+
+// hedgeDelay is the route's p95 latency once enough replies were timed,
+// so only the slowest requests in twenty are hedged.
+func (r *Route) hedgeDelay() time.Duration {
+	if p95, ok := r.latency.Percentile(0.95); ok {
+		return p95
+	}
+	return r.policy.HedgeDelay
+}
+
+// retriable reports whether another try may fare better: the try timed
+// out, the broker connection was down, or the service answered that it is
+// unavailable. Unroutable events and every other reply fail the same way
+// again.
+func retriable(reply *contracts.Reply, err error) bool {
+	if err != nil {
+		return errors.Is(err, event.ErrTimeout) || rabbit.IsRetriable(err)
+	}
+	switch reply.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Backoff returns the delay before the retry following attempt: Backoff
+// doubled for every earlier retry, capped at MaxBackoff, of which a random
+// half is taken off so clients that failed together retry apart.
+func Backoff(p routing.RetryPolicy, attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// Set holds the retry state of every route with a retry policy.
+type Set struct {
+	mu     sync.Mutex
+	routes map[string]*Route
+}
+
+func NewSet() *Set {
+	return &Set{routes: make(map[string]*Route)}
+}
+
+// Route returns the state of route, creating it on first use. A route
+// whose policy changed, when the route table was reloaded, starts over.
+func (s *Set) Route(route routing.Route) *Route {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.routes[route.ID()]
+	if !ok || r.policy != route.Retry || r.topic != route.Topic {
+		r = NewRoute(route)
+		s.routes[route.ID()] = r
+	}
+	return r
+}
@@ -0,0 +1,259 @@
+package routing
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/ratelimit"
+	"gopkg.in/yaml.v3"
+)
+
+// Mode selects whether a route waits for the listener's reply.
+type Mode string
+
+const (
+	ModeSync  Mode = "sync"  // publish and wait for the reply (RPC)
+	ModeAsync Mode = "async" // publish and answer 202 immediately
+)
+
+// AuthRequirement declares whether a route needs an authenticated caller.
+type AuthRequirement string
+
+const (
+	AuthNone     AuthRequirement = "none"
+	AuthOptional AuthRequirement = "optional"
+	AuthRequired AuthRequirement = "required"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultMaxBody = 1 << 20
+
+	defaultBackoff     = 50 * time.Millisecond
+	defaultMaxBackoff  = time.Second
+	defaultHedgeDelay  = 100 * time.Millisecond
+	defaultRetryBudget = 0.1
+)
+
+//go:embed routes.yaml
+var defaultRoutes []byte
+
+// Route maps one HTTP endpoint onto an event topic.
+type Route struct {
+	Method      string          `yaml:"method"`
+	Path        string          `yaml:"path"`
+	Topic       string          `yaml:"topic"`
+	Event       string          `yaml:"event"`
+	PathParams  []string        `yaml:"path_params"`  // URL params copied into the event data
+	QueryParams []string        `yaml:"query_params"` // query string params copied into the event data
+	Body        bool            `yaml:"body"`         // forward the JSON request body as event data
+	Auth        AuthRequirement `yaml:"auth"`
+	Timeout     time.Duration   `yaml:"timeout"`
+	Mode        Mode            `yaml:"mode"`
+	RateLimit   ratelimit.Limit `yaml:"rate_limit"` // per-client limit on top of the global one
+	Cache       CachePolicy     `yaml:"cache"`
+	Retry       RetryPolicy     `yaml:"retry"`
+
+	// Documentation: Request and Response name contracts types (prefix []
+	// for arrays) describing the body and a successful reply
+	Summary  string `yaml:"summary"`
+	Request  string `yaml:"request"`
+	Response string `yaml:"response"`
+
+	// Body validation: Schema is an inline JSON Schema, used instead of the
+	// one derived from Request; MaxBody caps the body size in bytes
+	Schema  map[string]any `yaml:"schema"`
+	MaxBody int64          `yaml:"max_body"`
+}
+
+// CachePolicy enables response caching for a GET route.
+type CachePolicy struct {
+	TTL           time.Duration `yaml:"ttl"`
+	InvalidatedBy []string      `yaml:"invalidated_by"` // event topics that drop cached responses
+}
+
+// RetryPolicy retries and hedges the RPC of a sync GET route. Retries and
+// hedges are paid from a budget per route, refilled by requests.
+type RetryPolicy struct {
+	Attempts       int           `yaml:"attempts"`        // tries in all, the first included; 0 or 1 disables retries
+	Backoff        time.Duration `yaml:"backoff"`         // delay before the first retry, doubled for each further one
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // cap of the backoff
+	AttemptTimeout time.Duration `yaml:"attempt_timeout"` // how long a try waits for its reply before the next one
+	Hedge          bool          `yaml:"hedge"`           // send a second copy when the reply is later than usual
+	HedgeDelay     time.Duration `yaml:"hedge_delay"`     // when to hedge until the route's p95 latency is known
+	Budget         float64       `yaml:"budget"`          // retries and hedges per request, on average
+}
+
+// Enabled reports whether the policy sends more than one copy of a request.
+func (p RetryPolicy) Enabled() bool {
+	return p.Attempts > 1 || p.Hedge
+}
+
+// ID identifies the route within a table.
+func (r Route) ID() string {
+	return r.Method + " " + r.Path
+}
+
+// Table is the full set of routes served by the broker.
+type Table struct {
+	Routes []Route `yaml:"routes"`
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Default returns the route table compiled into the binary.
+func Default() (*Table, error) {
+	return Parse(defaultRoutes)
+}
+
+// Load reads a route table from a YAML or JSON file.
+func Load(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read route table: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a route table. JSON is accepted as well,
+// since it is a subset of YAML.
+func Parse(data []byte) (*Table, error) {
+	var t Table
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("parse route table: %w", err)
+	}
+
+	for i := range t.Routes {
+		t.Routes[i].applyDefaults()
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *Route) applyDefaults() {
+	r.Method = strings.ToUpper(r.Method)
+	if r.Auth == "" {
+		r.Auth = AuthNone
+	}
+	if r.Mode == "" {
+		r.Mode = ModeSync
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = defaultTimeout
+	}
+	if r.MaxBody <= 0 {
+		r.MaxBody = defaultMaxBody
+	}
+	if r.Retry.Enabled() {
+		r.Retry.applyDefaults(r.Timeout)
+	}
+}
+
+func (p *RetryPolicy) applyDefaults(timeout time.Duration) {
+	if p.Attempts < 1 {
+		p.Attempts = 1
+	}
+	if p.Backoff == 0 {
+		p.Backoff = defaultBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	// Split the route's timeout so every try gets its turn
+	if p.AttemptTimeout == 0 {
+		p.AttemptTimeout = timeout / time.Duration(p.Attempts)
+	}
+	if p.HedgeDelay == 0 {
+		p.HedgeDelay = defaultHedgeDelay
+	}
+	if p.Budget == 0 {
+		p.Budget = defaultRetryBudget
+	}
+}
+
+// Validate checks every route and reports all problems at once.
+func (t *Table) Validate() error {
+	var errs []error
+	seen := make(map[string]bool)
+
+	for i, r := range t.Routes {
+		id := fmt.Sprintf("route %d (%s %s)", i, r.Method, r.Path)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unsupported method %q", id, r.Method))
+		}
+
+		if !strings.HasPrefix(r.Path, "/") {
+			errs = append(errs, fmt.Errorf("%s: path must start with /", id))
+		}
+		if r.Topic == "" {
+			errs = append(errs, fmt.Errorf("%s: topic is required", id))
+		}
+		if r.Event == "" {
+			errs = append(errs, fmt.Errorf("%s: event is required", id))
+		}
+
+		switch r.Mode {
+		case ModeSync, ModeAsync:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown mode %q", id, r.Mode))
+		}
+
+		switch r.Auth {
+		case AuthNone, AuthOptional, AuthRequired:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown auth requirement %q", id, r.Auth))
+		}
+
+		if r.Cache.TTL > 0 && r.Method != http.MethodGet {
+			errs = append(errs, fmt.Errorf("%s: only GET routes can be cached", id))
+		}
+		if r.Cache.TTL < 0 {
+			errs = append(errs, fmt.Errorf("%s: cache ttl must be positive", id))
+		}
+		if r.Retry.Enabled() {
+			// Only reads may run twice; a retried command could apply twice
+			if r.Method != http.MethodGet || r.Mode != ModeSync {
+				errs = append(errs, fmt.Errorf("%s: retry is only supported on sync GET routes", id))
+			}
+			if r.Retry.Backoff < 0 || r.Retry.MaxBackoff < 0 || r.Retry.AttemptTimeout < 0 || r.Retry.HedgeDelay < 0 {
+				errs = append(errs, fmt.Errorf("%s: retry durations must be positive", id))
+			}
+			if r.Retry.Budget < 0 || r.Retry.Budget > 1 {
+				errs = append(errs, fmt.Errorf("%s: retry budget must be between 0 and 1", id))
+			}
+		}
+		if (r.Schema != nil || r.Request != "") && !r.Body {
+			errs = append(errs, fmt.Errorf("%s: schema and request need body: true", id))
+		}
+
+		declared := make(map[string]bool)
+		for _, m := range pathParamPattern.FindAllStringSubmatch(r.Path, -1) {
+			declared[m[1]] = true
+		}
+		for _, p := range r.PathParams {
+			if !declared[p] {
+				errs = append(errs, fmt.Errorf("%s: path param %q is not in the path", id, p))
+			}
+		}
+
+		key := r.ID()
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("%s: duplicate route", id))
+		}
+		seen[key] = true
+	}
+
+	return errors.Join(errs...)
+}
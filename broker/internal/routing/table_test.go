@@ -0,0 +1,141 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultTableIsValid(t *testing.T) {
+	table, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Routes) == 0 {
+		t.Error("default table has no routes")
+	}
+}
+
+func TestParseAppliesDefaults(t *testing.T) {
+	table, err := Parse([]byte(`
+routes:
+  - method: get
+    path: /api/v1/items
+    topic: items.list
+    event: list
+    retry:
+      attempts: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := table.Routes[0]
+	if r.Method != "GET" || r.Mode != ModeSync || r.Auth != AuthNone {
+		t.Errorf("method %q, mode %q, auth %q; want GET, sync, none", r.Method, r.Mode, r.Auth)
+	}
+	if r.Timeout != defaultTimeout || r.MaxBody != defaultMaxBody {
+		t.Errorf("timeout %v, max body %d", r.Timeout, r.MaxBody)
+	}
+	if r.Retry.AttemptTimeout != defaultTimeout/2 || r.Retry.Budget != defaultRetryBudget {
+		t.Errorf("retry %+v", r.Retry)
+	}
+}
+
+func TestInvalidTables(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		want   string
+	}{
+		{
+			"unknown mode",
+			`{method: GET, path: /a, topic: a.get, event: get, mode: later}`,
+			`unknown mode "later"`,
+		},
+		{
+			"unknown auth",
+			`{method: GET, path: /a, topic: a.get, event: get, auth: maybe}`,
+			`unknown auth requirement "maybe"`,
+		},
+		{
+			"unsupported method",
+			`{method: TRACE, path: /a, topic: a.get, event: get}`,
+			`unsupported method "TRACE"`,
+		},
+		{
+			"duplicate route",
+			`{method: GET, path: /a, topic: a.get, event: get}, {method: get, path: /a, topic: a.list, event: list}`,
+			"duplicate route",
+		},
+		{
+			"cached POST",
+			`{method: POST, path: /a, topic: a.create, event: create, cache: {ttl: 1m}}`,
+			"only GET routes can be cached",
+		},
+		{
+			"negative cache ttl",
+			`{method: GET, path: /a, topic: a.get, event: get, cache: {ttl: -1m}}`,
+			"cache ttl must be positive",
+		},
+		{
+			"retried POST",
+			`{method: POST, path: /a, topic: a.create, event: create, retry: {attempts: 3}}`,
+			"retry is only supported on sync GET routes",
+		},
+		{
+			"hedged DELETE",
+			`{method: DELETE, path: /a, topic: a.delete, event: delete, retry: {hedge: true}}`,
+			"retry is only supported on sync GET routes",
+		},
+		{
+			"retried async GET",
+			`{method: GET, path: /a, topic: a.get, event: get, mode: async, retry: {attempts: 3}}`,
+			"retry is only supported on sync GET routes",
+		},
+		{
+			"retry budget",
+			`{method: GET, path: /a, topic: a.get, event: get, retry: {attempts: 2, budget: 2}}`,
+			"retry budget must be between 0 and 1",
+		},
+		{
+			"missing topic",
+			`{method: GET, path: /a, event: get}`,
+			"topic is required",
+		},
+		{
+			"relative path",
+			`{method: GET, path: a, topic: a.get, event: get}`,
+			"path must start with /",
+		},
+		{
+			"undeclared path param",
+			`{method: GET, path: /a, topic: a.get, event: get, path_params: [id]}`,
+			`path param "id" is not in the path`,
+		},
+		{
+			"schema without body",
+			`{method: POST, path: /a, topic: a.create, event: create, request: AuthLoginRequest}`,
+			"schema and request need body: true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte("routes: [" + tt.routes + "]"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	_, err := Parse([]byte(`routes: [{method: POST, path: /a, topic: a.create, event: create, mode: later, cache: {ttl: 1m}}]`))
+	if err == nil {
+		t.Fatal("invalid table parsed")
+	}
+	for _, want := range []string{"unknown mode", "only GET routes can be cached"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err %v lacks %q", err, want)
+		}
+	}
+}
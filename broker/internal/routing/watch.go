@@ -0,0 +1,50 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// Watch polls the route table file and calls onChange with the new table
+// whenever its content changes. Invalid tables are logged and skipped, so
+// the broker keeps serving the last good table. Polling is used instead of
+// inotify because it also works on bind-mounted config in containers.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func(*Table)) {
+	last, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Route table watch: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Route table watch: %v", err)
+			continue
+		}
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		table, err := Parse(data)
+		if err != nil {
+			log.Printf("Route table %s not reloaded: %v", path, err)
+			continue
+		}
+
+		log.Printf("Route table %s reloaded with %d routes", path, len(table.Routes))
+		onChange(table)
+	}
+}
@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const watchInterval = 10 * time.Millisecond
+
+func TestWatchReloadsValidTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`routes: [{method: GET, path: /a, topic: a.get, event: get}]`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tables := make(chan *Table, 1)
+	go Watch(ctx, path, watchInterval, func(table *Table) { tables <- table })
+
+	next := func() *Table {
+		t.Helper()
+		select {
+		case table := <-tables:
+			return table
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	// Let Watch read the file it starts with
+	time.Sleep(5 * watchInterval)
+
+	write(`routes: [{method: GET, path: /a, topic: a.get, event: get}, {method: GET, path: /b, topic: b.get, event: get}]`)
+	if table := next(); table == nil || len(table.Routes) != 2 {
+		t.Fatalf("after a valid change: %+v, want 2 routes", table)
+	}
+
+	// An invalid table never replaces the one being served
+	write(`routes: [{method: GET, path: /a, topic: a.get, event: get, mode: later}]`)
+	select {
+	case table := <-tables:
+		t.Fatalf("invalid table applied: %+v", table)
+	case <-time.After(20 * watchInterval):
+	}
+
+	// and the watch goes on
+	write(`routes: [{method: POST, path: /c, topic: c.create, event: create}]`)
+	if table := next(); table == nil || table.Routes[0].Path != "/c" {
+		t.Fatalf("after fixing the file: %+v, want /c", table)
+	}
+}
+
+func TestWatchStopsWithContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, path, watchInterval, func(*Table) {})
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch still running after cancel")
+	}
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/breaker"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// GetBreakers lists the state of every per-topic circuit breaker.
+func (s *Server) GetBreakers(w http.ResponseWriter, r *http.Request) {
+	statuses := []breaker.Status{}
+	if s.Breakers != nil {
+		statuses = s.Breakers.Snapshot()
+	}
+
+	event.WriteReply(w, &contracts.Reply{
+		Status: http.StatusOK,
+		Body:   mustJSON(map[string]any{"breakers": statuses}),
+	})
+}
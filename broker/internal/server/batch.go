@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/validation"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	batchPath    = "/api/v1/batch"
+	batchTimeout = 30 * time.Second // overall deadline for all items
+	batchMaxBody = 1 << 20
+)
+
+// batchHeaders are the caller's headers every item inherits, so items are
+// authenticated and rate limited as the caller.
+var batchHeaders = []string{"Authorization", "Cookie", "User-Agent", "X-Forwarded-For", "X-Real-IP"}
+
+// itemHeaders are the headers items may set for themselves. Anything else
+// is ignored, so an item cannot pose as another caller or client address.
+var itemHeaders = map[string]bool{"Idempotency-Key": true, "If-None-Match": true, "Accept": true}
+
+// batchValidator checks batch bodies against contracts.BatchRequest.
+func batchValidator() (*validation.Validator, error) {
+	return validation.Compile(routing.Route{
+		Method:  http.MethodPost,
+		Path:    batchPath,
+		Body:    true,
+		Request: "BatchRequest",
+		MaxBody: batchMaxBody,
+	})
+}
+
+// Batch runs several route table requests concurrently and answers with
+// every item's status and body in request order. Each item goes through
+// the same auth, validation, rate limits and breakers as a direct call and
+// also counts against the caller's rate limit. Items still running at the
+// batch deadline are reported as 504.
+func (s *Server) Batch(w http.ResponseWriter, r *http.Request) {
+	var req contracts.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Request body must be a batch"))
+		return
+	}
+
+	seen := make(map[string]bool, len(req.Requests))
+	for i := range req.Requests {
+		item := &req.Requests[i]
+		if item.ID == "" {
+			item.ID = strconv.Itoa(i)
+		}
+		if seen[item.ID] {
+			event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Batch item ids must be unique").
+				WithField("requests."+strconv.Itoa(i)+".id", "duplicates "+item.ID))
+			return
+		}
+		seen[item.ID] = true
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), batchTimeout)
+	defer cancel()
+
+	// Items count against the caller's budget like direct calls would
+	handler := brokermw.RateLimit(s.RateLimits.Limiter, brokermw.Policy{
+		Name:  "client",
+		Limit: s.clientLimit,
+		Key:   s.clientKey,
+	})(http.HandlerFunc(s.serveTable))
+
+	type done struct {
+		index  int
+		result contracts.BatchResult
+	}
+	results := make(chan done, len(req.Requests))
+	for i, item := range req.Requests {
+		go func() {
+			results <- done{i, s.batchItem(ctx, r, handler, item)}
+		}()
+	}
+
+	// Handlers stop at the deadline since their RPCs share ctx; collecting
+	// through the channel keeps a straggler from holding up the response
+	resp := contracts.BatchResponse{Responses: make([]contracts.BatchResult, len(req.Requests))}
+	pending := make(map[int]bool, len(req.Requests))
+	for i := range req.Requests {
+		pending[i] = true
+	}
+collect:
+	for len(pending) > 0 {
+		select {
+		case d := <-results:
+			resp.Responses[d.index] = d.result
+			delete(pending, d.index)
+		case <-ctx.Done():
+			if r.Context().Err() != nil {
+				// Client went away; nobody is left to read a response
+				return
+			}
+			timeout := contracts.NewError(http.StatusGatewayTimeout, contracts.ErrCodeTimeout, "Batch deadline exceeded")
+			for i := range pending {
+				resp.Responses[i] = contracts.BatchResult{
+					ID:     req.Requests[i].ID,
+					Status: timeout.Status,
+					Body:   mustJSON(timeout.ToProblem()),
+				}
+			}
+			break collect
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// batchItem serves one item through handler as a request of its own and
+// records the response.
+func (s *Server) batchItem(ctx context.Context, parent *http.Request, handler http.Handler, item contracts.BatchItem) contracts.BatchResult {
+	// A fresh routing context: the caller's belongs to the batch route and
+	// items are routed concurrently
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, (*chi.Context)(nil))
+
+	r, err := http.NewRequestWithContext(ctx, item.Method, item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		apiErr := contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Invalid batch item").
+			WithField("path", err.Error())
+		return contracts.BatchResult{ID: item.ID, Status: apiErr.Status, Body: mustJSON(apiErr.ToProblem())}
+	}
+	r.RemoteAddr = parent.RemoteAddr
+	for name, v := range item.Headers {
+		if name = http.CanonicalHeaderKey(name); itemHeaders[name] {
+			r.Header.Set(name, v)
+		}
+	}
+	for _, name := range batchHeaders {
+		if v := parent.Header.Values(name); len(v) > 0 {
+			r.Header[name] = v
+		}
+	}
+	if len(item.Body) > 0 {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	rec := newBatchRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec.result(item.ID)
+}
+
+// batchRecorder captures a batch item's response.
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchRecorder() *batchRecorder {
+	return &batchRecorder{header: make(http.Header)}
+}
+
+func (rec *batchRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *batchRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *batchRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *batchRecorder) result(id string) contracts.BatchResult {
+	res := contracts.BatchResult{ID: id, Status: rec.status}
+	if res.Status == 0 {
+		// The handler wrote nothing, which only happens when the batch
+		// was cancelled under it
+		res.Status = http.StatusGatewayTimeout
+	}
+
+	if len(rec.header) > 0 {
+		res.Headers = make(map[string]string, len(rec.header))
+		for name := range rec.header {
+			res.Headers[name] = rec.header.Get(name)
+		}
+	}
+
+	if rec.body.Len() > 0 {
+		mediaType, _, _ := mime.ParseMediaType(rec.header.Get("Content-Type"))
+		if (mediaType == "application/json" || mediaType == contracts.ProblemContentType) && json.Valid(rec.body.Bytes()) {
+			res.Body = rec.body.Bytes()
+		} else {
+			res.Body = mustJSON(rec.body.String())
+		}
+	}
+	return res
+}
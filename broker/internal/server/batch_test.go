@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+const batchTestRoutes = `
+routes:
+  - {method: GET, path: "/api/v1/users/{id}", topic: auth.get_user, event: get_user, path_params: [id]}
+  - {method: POST, path: /api/v1/orders, topic: orders.create, event: create, body: true, auth: required}
+`
+
+func TestBatch(t *testing.T) {
+	table, err := routing.Parse([]byte(batchTestRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(newTestEmitter(t, 10*time.Millisecond), nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+
+	body := `{"requests": [
+		{"id": "a", "method": "GET", "path": "/api/v1/users/1"},
+		{"method": "GET", "path": "/api/v1/users/2"},
+		{"id": "order", "method": "POST", "path": "/api/v1/orders", "body": {"sku": "x"}},
+		{"id": "missing", "method": "GET", "path": "/api/v1/nope"}
+	]}`
+	resp, err := http.Post(ts.URL+batchPath, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+
+	var got contracts.BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		id     string
+		status int
+		body   string
+	}{
+		{"a", http.StatusOK, `{"id":"1"}`},
+		{"1", http.StatusOK, `{"id":"2"}`},
+		{"order", http.StatusUnauthorized, ""},
+		{"missing", http.StatusNotFound, ""},
+	}
+	if len(got.Responses) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got.Responses), len(want))
+	}
+	for i, w := range want {
+		res := got.Responses[i]
+		if res.ID != w.id || res.Status != w.status {
+			t.Errorf("result %d: got %s %d, want %s %d", i, res.ID, res.Status, w.id, w.status)
+		}
+		if w.body != "" && string(res.Body) != w.body {
+			t.Errorf("result %d: body %s, want %s", i, res.Body, w.body)
+		}
+	}
+}
+
+func TestBatchRejectsInvalidBatches(t *testing.T) {
+	table, err := routing.Parse([]byte(batchTestRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(newTestEmitter(t, 0), nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	for name, body := range map[string]string{
+		"empty":         `{"requests": []}`,
+		"bad method":    `{"requests": [{"method": "TRACE", "path": "/api/v1/users/1"}]}`,
+		"outside api":   `{"requests": [{"method": "GET", "path": "/metrics"}]}`,
+		"duplicate ids": `{"requests": [{"id": "x", "method": "GET", "path": "/api/v1/users/1"}, {"id": "x", "method": "GET", "path": "/api/v1/users/2"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, batchPath, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			srv.Routes().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestBatchItemHeaders(t *testing.T) {
+	srv := &Server{}
+	parent := httptest.NewRequest(http.MethodPost, batchPath, nil)
+	parent.Header.Set("Authorization", "Bearer caller")
+	parent.Header.Set("X-Forwarded-For", "198.51.100.7")
+
+	var got http.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	})
+	srv.batchItem(parent.Context(), parent, handler, contracts.BatchItem{
+		Method: http.MethodGet,
+		Path:   "/api/v1/users/1",
+		Headers: map[string]string{
+			"idempotency-key": "k1",
+			"If-None-Match":   `"v1"`,
+			"Authorization":   "Bearer someone-else",
+			"X-Forwarded-For": "203.0.113.1",
+			"X-Real-IP":       "203.0.113.1",
+			"X-Client-IP":     "203.0.113.1",
+		},
+	})
+
+	for name, want := range map[string]string{
+		"Idempotency-Key": "k1",
+		"If-None-Match":   `"v1"`,
+		"Authorization":   "Bearer caller",
+		"X-Forwarded-For": "198.51.100.7",
+		"X-Real-IP":       "",
+		"X-Client-IP":     "",
+	} {
+		if v := got.Get(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+}
+
+func TestBatchItemCannotAuthenticate(t *testing.T) {
+	table, err := routing.Parse([]byte(batchTestRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(newTestEmitter(t, 0), nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.Auth = brokermw.NewTokenAuthenticator(map[string]string{"secret": "admin"})
+
+	body := `{"requests": [{"method": "POST", "path": "/api/v1/orders", "headers": {"Authorization": "Bearer secret"}, "body": {}}]}`
+	req := httptest.NewRequest(http.MethodPost, batchPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.Routes().ServeHTTP(rec, req)
+
+	var got contracts.BatchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Responses) != 1 || got.Responses[0].Status != http.StatusUnauthorized {
+		t.Errorf("item with its own credentials: %+v, want 401", got.Responses)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+)
+
+func TestCachedRoutes(t *testing.T) {
+	table, err := routing.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(newTestEmitter(t, 0), nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// The broker subscribes to these at startup
+	if topics := srv.Cache.Topics(); !slices.Equal(topics, []string{"user.created"}) {
+		t.Errorf("cache topics %v, want [user.created]", topics)
+	}
+
+	ts := httptest.NewServer(srv.Routes())
+	defer ts.Close()
+
+	get := func(header http.Header) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/users", nil)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	first := get(nil)
+	if first.StatusCode != http.StatusOK || first.Header.Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: status %d, X-Cache %q", first.StatusCode, first.Header.Get("X-Cache"))
+	}
+	if got := get(nil).Header.Get("X-Cache"); got != "HIT" {
+		t.Errorf("second request: X-Cache %q, want HIT", got)
+	}
+	if resp := get(http.Header{"If-None-Match": {first.Header.Get("ETag")}}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("matching If-None-Match: status %d, want 304", resp.StatusCode)
+	}
+
+	// The listener announces registrations without data
+	srv.Cache.Invalidate("user.created", map[string]any{})
+	if got := get(nil).Header.Get("X-Cache"); got != "MISS" {
+		t.Errorf("after user.created: X-Cache %q, want MISS", got)
+	}
+}
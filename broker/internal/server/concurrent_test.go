@@ -1,6 +1,9 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,7 +13,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
 	"github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/ratelimit"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // MockEmitter simulates RabbitMQ emitter for testing
@@ -76,8 +85,7 @@ func TestConcurrentGetUsers(t *testing.T) {
 			})
 
 			if tt.rateLimit > 0 {
-				rl := middleware.NewRateLimiterPathOnly(tt.rateLimit, tt.rateLimitWindow)
-				handler = rl.MiddlewarePathOnly(handler)
+				handler = pathRateLimit(tt.rateLimit, tt.rateLimitWindow)(handler)
 			}
 
 			ts := httptest.NewServer(handler)
@@ -170,10 +178,18 @@ func TestConcurrentGetUsers(t *testing.T) {
 	}
 }
 
-func TestRateLimiterStress(t *testing.T) {
-	rl := middleware.NewRateLimiter(100, time.Second)
+// pathRateLimit limits requests per path, so all clients share one budget.
+func pathRateLimit(rate int, window time.Duration) func(http.Handler) http.Handler {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(0))
+	return middleware.RateLimit(limiter, middleware.Policy{
+		Name:  "path",
+		Limit: middleware.StaticLimit(ratelimit.Limit{Rate: rate, Period: window, Burst: rate}),
+		Key:   func(r *http.Request) string { return r.URL.Path },
+	})
+}
 
-	handler := rl.MiddlewarePathOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func TestRateLimiterStress(t *testing.T) {
+	handler := pathRateLimit(100, time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -256,14 +272,241 @@ func BenchmarkGetUsersConcurrent(b *testing.B) {
 }
 
 func BenchmarkRateLimiterAllow(b *testing.B) {
-	rl := middleware.NewRateLimiter(10000, time.Second)
+	store := ratelimit.NewMemoryStore(0)
+	defer store.Close()
+	limiter := ratelimit.NewLimiter(store)
+	limit := ratelimit.PerSecond(10000)
+	ctx := context.Background()
 
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		i := 0
 		for pb.Next() {
-			rl.Allow(fmt.Sprintf("client-%d", i%100))
+			limiter.Allow(ctx, fmt.Sprintf("client-%d", i%100), limit)
 			i++
 		}
 	})
 }
+
+// fakeChannel stands in for an AMQP channel plus a listener: every publish
+// is answered on the direct reply-to consumer after delay, echoing the
+// event data so tests can check replies reach the right caller.
+type fakeChannel struct {
+	delay   time.Duration
+	replies chan amqp.Delivery
+	returns chan amqp.Return
+	unbound map[string]bool // topics returned as unroutable
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFakeChannel(delay time.Duration) *fakeChannel {
+	return &fakeChannel{
+		delay:   delay,
+		replies: make(chan amqp.Delivery, 1024),
+	}
+}
+
+func (f *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (f *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return f.replies, nil
+}
+
+func (f *fakeChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (f *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	f.returns = c
+	return c
+}
+
+// PublishWithDeferredConfirmWithContext returns no confirmation, like a
+// real channel outside confirm mode.
+func (f *fakeChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	var evt contracts.EventPayload
+	if err := json.Unmarshal(msg.Body, &evt); err != nil {
+		return nil, err
+	}
+
+	if f.unbound[key] {
+		f.returns <- amqp.Return{
+			ReplyCode:     312,
+			ReplyText:     "NO_ROUTE",
+			Exchange:      exchange,
+			RoutingKey:    key,
+			CorrelationId: msg.CorrelationId,
+		}
+		return nil, nil
+	}
+
+	go func() {
+		if f.delay > 0 {
+			time.Sleep(f.delay)
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.closed {
+			return
+		}
+		f.replies <- amqp.Delivery{
+			CorrelationId: msg.CorrelationId,
+			Body:          evt.Data,
+		}
+	}()
+	return nil, nil
+}
+
+func (f *fakeChannel) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.replies)
+	}
+	return nil
+}
+
+func newTestEmitter(tb testing.TB, delay time.Duration) *event.Emitter {
+	tb.Helper()
+
+	emitter, err := event.NewEmitterWithChannel(newFakeChannel(delay), "test_exchange")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { emitter.Close() })
+	return emitter
+}
+
+func TestEmitterPushConcurrentCorrelation(t *testing.T) {
+	emitter := newTestEmitter(t, time.Millisecond)
+
+	var wg sync.WaitGroup
+	var mismatches int64
+
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			want := fmt.Sprintf(`{"id":"user-%d"}`, i)
+			rec := httptest.NewRecorder()
+			err := emitter.Push(context.Background(), rec, contracts.TopicPayload{
+				Name:  "auth.get_user",
+				Event: contracts.EventPayload{Name: "get_user", Data: json.RawMessage(want)},
+			})
+			if err != nil || rec.Body.String() != want {
+				atomic.AddInt64(&mismatches, 1)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if mismatches > 0 {
+		t.Errorf("%d replies were lost or delivered to the wrong caller", mismatches)
+	}
+	if n := emitter.Pending(); n != 0 {
+		t.Errorf("expected no pending waiters, got %d", n)
+	}
+}
+
+func TestEmitterPushContextTimeout(t *testing.T) {
+	emitter := newTestEmitter(t, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := emitter.Push(ctx, httptest.NewRecorder(), contracts.TopicPayload{
+		Name:  "auth.get_users",
+		Event: contracts.EventPayload{Name: "get_users", Data: json.RawMessage(`{}`)},
+	})
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Push ignored the request deadline, took %v", elapsed)
+	}
+	if n := emitter.Pending(); n != 0 {
+		t.Errorf("timed out waiter was not removed, %d pending", n)
+	}
+}
+
+func TestEmitterPushUnroutable(t *testing.T) {
+	ch := newFakeChannel(0)
+	ch.unbound = map[string]bool{"orders.create": true}
+	emitter, err := event.NewEmitterWithChannel(ch, "test_exchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer emitter.Close()
+
+	start := time.Now()
+	err = emitter.Push(context.Background(), httptest.NewRecorder(), contracts.TopicPayload{
+		Name:  "orders.create",
+		Event: contracts.EventPayload{Name: "create", Data: json.RawMessage(`{}`)},
+	})
+	if !errors.Is(err, rabbit.ErrUnroutable) {
+		t.Fatalf("expected ErrUnroutable, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("unroutable event waited for a reply, took %v", elapsed)
+	}
+	if n := emitter.Pending(); n != 0 {
+		t.Errorf("returned request left %d pending waiters", n)
+	}
+}
+
+func BenchmarkEmitterPush(b *testing.B) {
+	emitter := newTestEmitter(b, 0)
+	payload := contracts.TopicPayload{
+		Name:  "auth.get_users",
+		Event: contracts.EventPayload{Name: "get_users", Data: json.RawMessage(`[{"id":"test"}]`)},
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := emitter.Push(context.Background(), httptest.NewRecorder(), payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetUsersThroughEmitter(b *testing.B) {
+	table, err := routing.Default()
+	if err != nil {
+		b.Fatal(err)
+	}
+	srv, err := NewServer(newTestEmitter(b, 0), nil, table)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer srv.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(srv.serveTable))
+	defer ts.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		client := &http.Client{}
+		for pb.Next() {
+			// Measure the RPC path, not cache hits
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/users", nil)
+			req.Header.Set("Cache-Control", "no-cache")
+			resp, err := client.Do(req)
+			if err != nil {
+				b.Fatal(err)
+			}
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/idempotency"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+const (
+	ticketMaxBody    = 1 << 10
+	ticketMaxSubject = 128
+
+	// ticketKeyPrefix keeps redeemed tickets apart from idempotency keys;
+	// a key could only collide by naming a ticket's random id
+	ticketKeyPrefix = "#ws-ticket:"
+)
+
+// IssueWSTicket trades the caller's credentials for a ticket admitting one
+// WebSocket handshake. Browsers pass it in the URL instead of a token,
+// which would be good for every other endpoint too. A service signing in
+// its own users asks for tickets on their behalf by naming them in the
+// body, so each user's connection is told apart from the service's.
+func (s *Server) IssueWSTicket(w http.ResponseWriter, r *http.Request) {
+	var req contracts.WSTicketRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, ticketMaxBody)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Request body must be a ticket request"))
+		return
+	}
+	if len(req.Subject) > ticketMaxSubject {
+		event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Invalid ticket request").
+			WithField("subject", "must be at most 128 characters"))
+		return
+	}
+
+	id, _ := brokermw.IdentityFrom(r.Context())
+	subject := id.Subject
+	if req.Subject != "" {
+		subject += "|" + req.Subject
+	}
+	ticket, expires := s.Tickets.Issue(subject)
+
+	event.WriteReply(w, &contracts.Reply{
+		Status:  http.StatusCreated,
+		Headers: map[string]string{"Cache-Control": "no-store"},
+		Body:    mustJSON(contracts.WSTicket{Ticket: ticket, ExpiresAt: expires}),
+	})
+}
+
+// TicketLedger records redeemed WebSocket tickets in the idempotency
+// store, so replicas sharing that store refuse each other's used tickets.
+func (s *Server) TicketLedger() brokermw.TicketLedger {
+	return ticketLedger{s}
+}
+
+type ticketLedger struct {
+	s *Server
+}
+
+func (l ticketLedger) Redeem(ctx context.Context, id string, expires time.Time) (bool, error) {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return false, nil
+	}
+	_, fresh, err := l.s.Idempotency.Reserve(ctx, ticketKeyPrefix+id, idempotency.Record{Done: true}, ttl)
+	return fresh, err
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/gateway"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+func newGatewayServer(t *testing.T) *Server {
+	t.Helper()
+
+	table, err := routing.Parse([]byte("routes: []"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(newTestEmitter(t, 0), nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	srv.Auth = brokermw.NewTokenAuthenticator(map[string]string{"svc": "dashboard"})
+	srv.Gateway = gateway.NewHub(nil)
+	return srv
+}
+
+// issue asks srv for a ticket with body and returns the response.
+func issue(srv *Server, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, wsTicketsPath, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer svc")
+	rec := httptest.NewRecorder()
+	srv.Routes().ServeHTTP(rec, r)
+	return rec
+}
+
+// redeem checks ticket as the handshake would.
+func redeem(srv *Server, ticket string) (*brokermw.Identity, error) {
+	r := httptest.NewRequest(http.MethodGet, wsPath+"?"+brokermw.TicketParam+"="+ticket, nil)
+	return srv.Tickets.Authenticate(r)
+}
+
+func TestTicketsOnBehalfOfUsers(t *testing.T) {
+	srv := newGatewayServer(t)
+
+	for body, want := range map[string]string{
+		"":                   "dashboard",
+		`{}`:                 "dashboard",
+		`{"subject":"u-42"}`: "dashboard|u-42",
+	} {
+		rec := issue(srv, body)
+		if rec.Code != http.StatusCreated || rec.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("body %q: %d %s", body, rec.Code, rec.Body)
+		}
+		var ticket contracts.WSTicket
+		if err := json.Unmarshal(rec.Body.Bytes(), &ticket); err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := redeem(srv, ticket.Ticket)
+		if err != nil || id.Subject != want {
+			t.Errorf("body %q: subject %+v, %v; want %s", body, id, err, want)
+		}
+		if _, err := redeem(srv, ticket.Ticket); err == nil {
+			t.Errorf("body %q: ticket redeemed twice", body)
+		}
+	}
+}
+
+func TestTicketRequestValidation(t *testing.T) {
+	srv := newGatewayServer(t)
+
+	for _, body := range []string{
+		`{`,
+		`{"subject":1}`,
+		`{"subject":"` + strings.Repeat("x", ticketMaxSubject+1) + `"}`,
+		`{"subject":"` + strings.Repeat("x", ticketMaxBody) + `"}`,
+	} {
+		if rec := issue(srv, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("body %.20q...: %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestRedeemedTicketsExpireFromTheStore(t *testing.T) {
+	srv := newGatewayServer(t)
+	ledger := srv.TicketLedger()
+
+	if fresh, err := ledger.Redeem(t.Context(), "a", time.Now().Add(time.Minute)); !fresh || err != nil {
+		t.Fatalf("first redemption: %v, %v", fresh, err)
+	}
+	if fresh, _ := ledger.Redeem(t.Context(), "a", time.Now().Add(time.Minute)); fresh {
+		t.Error("second redemption accepted")
+	}
+	if fresh, _ := ledger.Redeem(t.Context(), "b", time.Now().Add(-time.Second)); fresh {
+		t.Error("expired ticket accepted")
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/graph"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// NewGraph builds a GraphQL endpoint whose resolvers call the route table
+// as the GraphQL request's caller, like batch items do.
+func (s *Server) NewGraph(cfg graph.Config) (*graph.Handler, error) {
+	return graph.New(graphCaller{s}, cfg)
+}
+
+type graphCaller struct {
+	s *Server
+}
+
+func (c graphCaller) Get(ctx context.Context, r *http.Request, path string) (*contracts.Reply, error) {
+	res := c.s.batchItem(ctx, r, http.HandlerFunc(c.s.serveTable), contracts.BatchItem{Method: http.MethodGet, Path: path})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	reply := &contracts.Reply{Status: res.Status, Headers: res.Headers, Body: res.Body}
+	if res.Status >= http.StatusBadRequest {
+		// Error bodies, problem details or not, share code and message
+		var apiErr contracts.Error
+		if json.Unmarshal(res.Body, &apiErr) != nil || apiErr.Code == "" {
+			reply.Error = contracts.ErrorFromStatus(res.Status, "")
+		} else {
+			apiErr.Status = res.Status
+			reply.Error = &apiErr
+		}
+		reply.Body = nil
+	}
+	return reply, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/breaker"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+func graphServer(t *testing.T, ch *scriptedChannel, routes string) *Server {
+	t.Helper()
+
+	emitter, err := event.NewEmitterWithChannel(ch, "test_exchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { emitter.Close() })
+
+	table, err := routing.Parse([]byte(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(emitter, nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// query runs a GraphQL query and returns its first error's extensions, if
+// any.
+func query(t *testing.T, srv *Server, q string) map[string]any {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"query": q})
+	rec := httptest.NewRecorder()
+	srv.Graph.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, graphPath, strings.NewReader(string(body))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Errors []struct {
+			Extensions map[string]any `json:"extensions"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) == 0 {
+		return nil
+	}
+	return resp.Errors[0].Extensions
+}
+
+func TestGraphCallsAreRetriedAndCached(t *testing.T) {
+	ch := &scriptedChannel{
+		fakeChannel: newFakeChannel(0),
+		answer: func(n int) (time.Duration, contracts.Reply, bool) {
+			if n == 0 {
+				return 0, contracts.Reply{Status: http.StatusServiceUnavailable}, true
+			}
+			return 0, contracts.Reply{Status: http.StatusOK, Body: json.RawMessage(`{"id":"1","email":"a@example.com"}`)}, true
+		},
+	}
+	srv := graphServer(t, ch, `routes:
+  - method: GET
+    path: "/api/v1/users/{id}"
+    topic: auth.get_user
+    event: get_user
+    path_params: [id]
+    timeout: 2s
+    retry: {attempts: 2, backoff: 1ms}
+    cache: {ttl: 1m}`)
+
+	for range 2 {
+		if ext := query(t, srv, `{ user(id: "1") { email } }`); ext != nil {
+			t.Fatalf("query failed: %v", ext)
+		}
+	}
+	// One retry for the first query; the second is served from the cache
+	if n := len(ch.correlationIDs()); n != 2 {
+		t.Errorf("%d events published, want 2", n)
+	}
+}
+
+func TestGraphCallsShareBreakers(t *testing.T) {
+	ch := &scriptedChannel{
+		fakeChannel: newFakeChannel(0),
+		answer: func(int) (time.Duration, contracts.Reply, bool) {
+			return 0, contracts.Reply{Status: http.StatusInternalServerError}, true
+		},
+	}
+	srv := graphServer(t, ch, `routes:
+  - {method: GET, path: /api/v1/users, topic: auth.get_users, event: get_users, query_params: [limit, offset], timeout: 2s}`)
+	srv.Breakers = breaker.NewSet(breaker.Config{MinRequests: 1})
+
+	if ext := query(t, srv, `{ users(first: 2) { id } }`); ext["status"] != float64(http.StatusInternalServerError) {
+		t.Fatalf("first query: extensions %v, want status 500", ext)
+	}
+	ext := query(t, srv, `{ users(first: 2) { id } }`)
+	if ext["code"] != string(contracts.ErrCodeUnavailable) {
+		t.Errorf("with the breaker open: extensions %v, want %s", ext, contracts.ErrCodeUnavailable)
+	}
+	if n := len(ch.correlationIDs()); n != 1 {
+		t.Errorf("%d events published, want 1", n)
+	}
+}
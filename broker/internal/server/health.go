@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// Healthz reports liveness: the process is up and serving HTTP.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// Readyz reports readiness: RabbitMQ is connected, so RPCs can be
+// published, and the server is not shutting down.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	if s.shuttingDown.Load() {
+		writeError(w, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "Shutting down"))
+		return
+	}
+	if s.Emitter == nil || !s.Emitter.Connected() {
+		writeError(w, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "RabbitMQ unavailable"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ready"}`))
+}
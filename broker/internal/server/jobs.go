@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/jobs"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// sseHeartbeat keeps idle job streams alive through proxies that drop
+// silent connections.
+const sseHeartbeat = 15 * time.Second
+
+// startJob publishes payload as an asynchronous command and answers 202
+// with the job, which the client polls or streams until it completes.
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request, route routing.Route, payload contracts.TopicPayload) {
+	job := &jobs.Job{
+		ID:     uuid.New().String(),
+		Topic:  route.Topic,
+		Event:  route.Event,
+		Status: jobs.StatusPending,
+	}
+	if err := s.Jobs.Create(r.Context(), job); err != nil {
+		writeError(w, contracts.ErrInternal())
+		return
+	}
+
+	if err := s.Emitter.PushJob(r.Context(), payload, job.ID); err != nil {
+		// The command never left the broker; record that so pollers don't
+		// wait forever on a job nobody is running
+		s.Jobs.Complete(r.Context(), job.ID, &contracts.Reply{
+			Status: http.StatusServiceUnavailable,
+			Error:  contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "Failed to dispatch job"),
+		})
+		pushError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", jobPath(job.ID))
+	event.WriteReply(w, &contracts.Reply{
+		Status: http.StatusAccepted,
+		Body:   mustJSON(job),
+	})
+}
+
+// GetJob returns the current state of an asynchronous job.
+func (s *Server) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.Jobs.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+
+	event.WriteReply(w, &contracts.Reply{
+		Status: http.StatusOK,
+		Body:   mustJSON(job),
+	})
+}
+
+// StreamJob streams the job's state as server-sent events: the current
+// state first, then a "completed" or "failed" event once the reply arrives.
+// Streams end early when the server shuts down.
+func (s *Server) StreamJob(w http.ResponseWriter, r *http.Request) {
+	updates, err := s.Jobs.Watch(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// Streams outlive the server's write timeout by design
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case job, ok := <-updates:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(job)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", job.Status, data)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			if job.Done() {
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// CompleteJob records a job reply delivered by the emitter.
+func (s *Server) CompleteJob(jobID string, reply *contracts.Reply) {
+	ctx := context.Background()
+	if _, err := s.Jobs.Complete(ctx, jobID, reply); err != nil && !errors.Is(err, jobs.ErrNotFound) {
+		log.Printf("Failed to complete job %s: %v", jobID, err)
+	}
+}
+
+func jobError(w http.ResponseWriter, err error) {
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeError(w, contracts.NewError(http.StatusNotFound, contracts.ErrCodeNotFound, "Job not found"))
+		return
+	}
+	writeError(w, contracts.ErrInternal())
+}
+
+func jobPath(id string) string {
+	return "/api/v1/jobs/" + id
+}
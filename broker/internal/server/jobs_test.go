@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/jobs"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+const jobTestRoutes = `
+routes:
+  - {method: POST, path: /api/v1/orders, topic: orders.create, event: create, body: true, mode: async}
+`
+
+func newJobServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	table, err := routing.Parse([]byte(jobTestRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(newTestEmitter(t, 0), nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	ts := httptest.NewServer(srv.Routes())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+func getJob(t *testing.T, url string) jobs.Job {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, resp.StatusCode)
+	}
+	var job jobs.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// nextEvent reads one server-sent event.
+func nextEvent(t *testing.T, r *bufio.Reader) (string, jobs.Job) {
+	t.Helper()
+	var name string
+	var job jobs.Job
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &job); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && name != "":
+			return name, job
+		}
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	srv, ts := newJobServer(t)
+
+	resp, err := http.Post(ts.URL+"/api/v1/orders", "application/json", strings.NewReader(`{"sku":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted jobs.Job
+	json.NewDecoder(resp.Body).Decode(&accepted)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status %d, want 202", resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+	if accepted.ID == "" || location != "/api/v1/jobs/"+accepted.ID {
+		t.Fatalf("Location %q for job %q", location, accepted.ID)
+	}
+	if accepted.Status != jobs.StatusPending || accepted.Topic != "orders.create" || accepted.Event != "create" {
+		t.Errorf("accepted job %+v", accepted)
+	}
+
+	if job := getJob(t, ts.URL+location); job.Status != jobs.StatusPending {
+		t.Errorf("status %s before the reply, want pending", job.Status)
+	}
+
+	stream, err := http.Get(ts.URL + location + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	if ct := stream.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("stream content type %q", ct)
+	}
+	events := bufio.NewReader(stream.Body)
+	if name, job := nextEvent(t, events); name != "pending" || job.ID != accepted.ID {
+		t.Fatalf("first event %s %+v, want the pending job", name, job)
+	}
+
+	srv.CompleteJob(accepted.ID, &contracts.Reply{Status: http.StatusCreated, Body: json.RawMessage(`{"order":"o-1"}`)})
+
+	name, job := nextEvent(t, events)
+	if name != "completed" || job.Result == nil || job.Result.Status != http.StatusCreated || string(job.Result.Body) != `{"order":"o-1"}` {
+		t.Fatalf("final event %s %+v, want the completed job", name, job)
+	}
+	if _, err := events.ReadString('\n'); err == nil {
+		t.Error("stream still open after the job completed")
+	}
+
+	// A redelivered reply keeps the first outcome
+	srv.CompleteJob(accepted.ID, &contracts.Reply{Status: http.StatusInternalServerError})
+	if job := getJob(t, ts.URL+location); job.Status != jobs.StatusCompleted || job.Result.Status != http.StatusCreated {
+		t.Errorf("after a redelivery: %+v", job)
+	}
+
+	// Streams of finished jobs send the outcome and end
+	done, err := http.Get(ts.URL + location + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done.Body.Close()
+	if name, _ := nextEvent(t, bufio.NewReader(done.Body)); name != "completed" {
+		t.Errorf("stream of a finished job sent %s", name)
+	}
+}
+
+func TestFailedJob(t *testing.T) {
+	srv, ts := newJobServer(t)
+
+	resp, err := http.Post(ts.URL+"/api/v1/orders", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+
+	srv.CompleteJob(strings.TrimPrefix(location, "/api/v1/jobs/"), &contracts.Reply{
+		Status: http.StatusConflict,
+		Error:  contracts.NewError(http.StatusConflict, contracts.ErrCodeConflict, "Out of stock"),
+	})
+	job := getJob(t, ts.URL+location)
+	if job.Status != jobs.StatusFailed || job.Result.Error == nil || job.Result.Error.Code != contracts.ErrCodeConflict {
+		t.Errorf("failed job %+v", job)
+	}
+}
+
+func TestStreamEndsOnShutdown(t *testing.T) {
+	srv, ts := newJobServer(t)
+
+	resp, err := http.Post(ts.URL+"/api/v1/orders", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	stream, err := http.Get(ts.URL + resp.Header.Get("Location") + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	events := bufio.NewReader(stream.Body)
+	nextEvent(t, events)
+
+	srv.SetShuttingDown()
+	ended := make(chan error, 1)
+	go func() {
+		_, err := events.ReadString('\n')
+		ended <- err
+	}()
+	select {
+	case err := <-ended:
+		if err == nil {
+			t.Error("stream sent more after shutdown")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after shutdown")
+	}
+}
+
+func TestUnknownJob(t *testing.T) {
+	_, ts := newJobServer(t)
+
+	for _, path := range []string{"/api/v1/jobs/nope", "/api/v1/jobs/nope/events"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/openapi"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// GetOpenAPI serves the OpenAPI document for the current route table.
+func (s *Server) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := openapi.Generate(s.Table(), fixedEndpoints())
+	if err != nil {
+		writeError(w, contracts.ErrInternal())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
+
+// GetDocs serves the API docs page, which renders /api/v1/openapi.json.
+func (s *Server) GetDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openapi.DocsHTML)
+}
+
+// fixedEndpoints documents the endpoints registered in Routes rather than
+// in the route table. Keep it in sync with Routes; TestOpenAPICoversRoutes
+// fails otherwise.
+func fixedEndpoints() []openapi.Endpoint {
+	errorResponse := func(description string) openapi.Response {
+		return openapi.Response{
+			Description: description,
+			Content: map[string]openapi.MediaType{
+				contracts.ProblemContentType: {Schema: &openapi.Schema{Ref: "#/components/schemas/ProblemPayload"}},
+			},
+		}
+	}
+	jobID := openapi.Parameter{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string", Format: "uuid"}}
+	bearer := []map[string][]string{{"bearerAuth": {}}}
+
+	return []openapi.Endpoint{
+		{
+			Method: http.MethodPost, Path: batchPath,
+			Operation: &openapi.Operation{
+				OperationID: "batch",
+				Summary:     "Run several API requests in one round trip",
+				Description: fmt.Sprintf("Runs each item concurrently against the event-backed endpoints, as the caller. "+
+					"Items are authenticated, validated and rate limited like direct calls and count against the caller's rate limit. "+
+					"Every item gets its own status and body; items unfinished after %s are answered with 504.", batchTimeout),
+				Tags: []string{"batch"},
+				RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+					"application/json": {Schema: &openapi.Schema{Ref: "#/components/schemas/BatchRequest"}},
+				}},
+				Responses: map[string]openapi.Response{
+					"200": {Description: "Results in request order", Content: map[string]openapi.MediaType{
+						"application/json": {Schema: &openapi.Schema{Ref: "#/components/schemas/BatchResponse"}},
+					}},
+					"400": errorResponse("Invalid batch; validation failures list each invalid field under errors"),
+					"413": errorResponse(fmt.Sprintf("Request body larger than %d bytes", batchMaxBody)),
+					"415": errorResponse("Request body is not application/json"),
+				},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: graphPath,
+			Operation: &openapi.Operation{
+				OperationID: "graphql",
+				Summary:     "Query users and other read models with GraphQL",
+				Description: "Resolvers publish the same events as the REST routes, batching lookups per request. " +
+					"Queries are limited in depth and estimated cost; list fields count as many items as their first argument allows. " +
+					"Errors of single fields come back with a 200 and a code under extensions.",
+				Tags: []string{"graphql"},
+				RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+					"application/json": {Schema: &openapi.Schema{
+						Type: "object",
+						Properties: map[string]*openapi.Schema{
+							"query":         {Type: "string"},
+							"operationName": {Type: "string"},
+							"variables":     {Type: "object"},
+						},
+						Required: []string{"query"},
+					}},
+				}},
+				Responses: map[string]openapi.Response{
+					"200": {Description: "Query result with data and field errors", Content: map[string]openapi.MediaType{
+						"application/json": {Schema: &openapi.Schema{Type: "object"}},
+					}},
+					"400": {Description: "Malformed, invalid or too complex query; errors explain why", Content: map[string]openapi.MediaType{
+						"application/json": {Schema: &openapi.Schema{Type: "object"}},
+					}},
+				},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/jobs/{id}",
+			Operation: &openapi.Operation{
+				OperationID: "jobs.get",
+				Summary:     "Get the state of an asynchronous job",
+				Tags:        []string{"jobs"},
+				Parameters:  []openapi.Parameter{jobID},
+				Responses: map[string]openapi.Response{
+					"200": {Description: "Current job state", Content: map[string]openapi.MediaType{
+						"application/json": {Schema: &openapi.Schema{Ref: "#/components/schemas/Job"}},
+					}},
+					"404": errorResponse("Unknown or expired job"),
+				},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/jobs/{id}/events",
+			Operation: &openapi.Operation{
+				OperationID: "jobs.events",
+				Summary:     "Stream job state changes as server-sent events",
+				Description: "Sends the current state, then a completed or failed event carrying the Job once it finishes.",
+				Tags:        []string{"jobs"},
+				Parameters:  []openapi.Parameter{jobID},
+				Responses: map[string]openapi.Response{
+					"200": {Description: "Event stream", Content: map[string]openapi.MediaType{
+						"text/event-stream": {Schema: &openapi.Schema{Type: "string"}},
+					}},
+					"404": errorResponse("Unknown or expired job"),
+				},
+			},
+		},
+		{
+			Method: http.MethodPost, Path: wsTicketsPath,
+			Operation: &openapi.Operation{
+				OperationID: "gateway.tickets",
+				Summary:     "Issue a ticket for opening a WebSocket",
+				Description: "The ticket admits one handshake on /api/v1/ws until it expires, seconds later, and is refused once used. " +
+					"Services that sign in their own users name the user in subject; the ticket is then issued to both.",
+				Tags:     []string{"gateway"},
+				Security: bearer,
+				RequestBody: &openapi.RequestBody{Content: map[string]openapi.MediaType{
+					"application/json": {Schema: &openapi.Schema{Ref: "#/components/schemas/WSTicketRequest"}},
+				}},
+				Responses: map[string]openapi.Response{
+					"201": {Description: "Ticket issued", Content: map[string]openapi.MediaType{
+						"application/json": {Schema: &openapi.Schema{Ref: "#/components/schemas/WSTicket"}},
+					}},
+					"400": errorResponse("Invalid ticket request"),
+					"401": errorResponse("Missing or invalid credentials"),
+				},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: wsPath,
+			Operation: &openapi.Operation{
+				OperationID: "gateway.ws",
+				Summary:     "WebSocket stream of domain events and service logs",
+				Description: "Upgrade to a WebSocket, passing a ticket from /api/v1/ws/tickets. " +
+					"Pages from other origins than the broker's and BROKER_WS_ORIGINS are refused. " +
+					`Send {"action":"subscribe","topics":[...]} to filter by message type or topic.`,
+				Tags: []string{"gateway"},
+				Parameters: []openapi.Parameter{
+					{Name: brokermw.TicketParam, In: "query", Required: true, Schema: &openapi.Schema{Type: "string"}},
+				},
+				Responses: map[string]openapi.Response{
+					"101": {Description: "Switching to the WebSocket protocol"},
+					"401": errorResponse("Missing, invalid or expired ticket"),
+					"403": {Description: "Origin not allowed"},
+				},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/admin/breakers",
+			Operation: &openapi.Operation{
+				OperationID: "admin.breakers",
+				Summary:     "List per-topic circuit breaker state",
+				Tags:        []string{"admin"},
+				Security:    bearer,
+				Responses: map[string]openapi.Response{
+					"200": {Description: "Breaker states", Content: map[string]openapi.MediaType{
+						"application/json": {Schema: &openapi.Schema{Type: "object"}},
+					}},
+					"401": errorResponse("Missing or invalid credentials"),
+				},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/openapi.json",
+			Operation: &openapi.Operation{
+				OperationID: "docs.openapi",
+				Summary:     "This OpenAPI document",
+				Tags:        []string{"docs"},
+				Responses: map[string]openapi.Response{
+					"200": {Description: "OpenAPI 3 document", Content: map[string]openapi.MediaType{
+						"application/json": {Schema: &openapi.Schema{Type: "object"}},
+					}},
+				},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/docs",
+			Operation: &openapi.Operation{
+				OperationID: "docs.ui",
+				Summary:     "API documentation page",
+				Tags:        []string{"docs"},
+				Responses: map[string]openapi.Response{
+					"200": {Description: "HTML page", Content: map[string]openapi.MediaType{
+						"text/html": {Schema: &openapi.Schema{Type: "string"}},
+					}},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/gateway"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/openapi"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// specFile is the committed OpenAPI document frontend clients generate
+// their types from.
+const specFile = "../../api/openapi.json"
+
+var update = flag.Bool("update", false, "rewrite the committed OpenAPI document")
+
+func defaultSpec(t *testing.T) []byte {
+	t.Helper()
+
+	table, err := routing.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openapi.Generate(table, fixedEndpoints())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+// TestOpenAPIUpToDate fails when the default routes or contracts types
+// change without regenerating api/openapi.json. Regenerate it with
+//
+//	go test ./internal/server -run TestOpenAPIUpToDate -update
+func TestOpenAPIUpToDate(t *testing.T) {
+	generated := defaultSpec(t)
+
+	if *update {
+		if err := os.WriteFile(specFile, generated, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	committed, err := os.ReadFile(specFile)
+	if err != nil {
+		t.Fatalf("read %s: %v (run with -update to create it)", specFile, err)
+	}
+	if !bytes.Equal(committed, generated) {
+		t.Fatalf("%s is out of date; regenerate it with: go test ./internal/server -run TestOpenAPIUpToDate -update", specFile)
+	}
+}
+
+// TestOpenAPICoversRoutes checks that every endpoint the broker serves is
+// documented and every documented endpoint is served.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	table, err := routing.Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer(nil, nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.Gateway = gateway.NewHub(nil)
+
+	served := make(map[string]bool)
+	collect := func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		// Operational endpoints are not part of the API
+		switch {
+		case route == "/*", route == metrics.Path,
+			route == "/healthz", route == "/readyz":
+			return nil
+		}
+		served[strings.ToLower(method)+" "+route] = true
+		return nil
+	}
+	if err := chi.Walk(srv.Routes().(chi.Routes), collect); err != nil {
+		t.Fatal(err)
+	}
+	if err := chi.Walk(srv.router.Load(), collect); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := openapi.Generate(table, fixedEndpoints())
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			documented[method+" "+path] = true
+		}
+	}
+
+	var missing, stale []string
+	for op := range served {
+		if !documented[op] {
+			missing = append(missing, op)
+		}
+	}
+	for op := range documented {
+		if !served[op] {
+			stale = append(stale, op)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+
+	if len(missing) > 0 {
+		t.Errorf("served but not documented: %v", missing)
+	}
+	if len(stale) > 0 {
+		t.Errorf("documented but not served: %v", stale)
+	}
+}
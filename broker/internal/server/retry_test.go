@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/retry"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// scriptedChannel answers the n-th publish (from 0) as answer says: after
+// a delay with a reply, or never when answer returns ok false.
+type scriptedChannel struct {
+	*fakeChannel
+	answer func(n int) (delay time.Duration, reply contracts.Reply, ok bool)
+
+	mu        sync.Mutex
+	published []string // correlation IDs in publish order
+}
+
+func (c *scriptedChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	c.mu.Lock()
+	n := len(c.published)
+	c.published = append(c.published, msg.CorrelationId)
+	c.mu.Unlock()
+
+	delay, reply, ok := c.answer(n)
+	if !ok {
+		return nil, nil
+	}
+	body, _ := json.Marshal(reply)
+	go func() {
+		time.Sleep(delay)
+		c.fakeChannel.mu.Lock()
+		defer c.fakeChannel.mu.Unlock()
+		if !c.closed {
+			c.replies <- amqp.Delivery{CorrelationId: msg.CorrelationId, Type: contracts.ReplyMessageType, Body: body}
+		}
+	}()
+	return nil, nil
+}
+
+func (c *scriptedChannel) correlationIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.published...)
+}
+
+func retryServer(t *testing.T, ch *scriptedChannel, policy routing.RetryPolicy) *Server {
+	t.Helper()
+
+	emitter, err := event.NewEmitterWithChannel(ch, "test_exchange")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { emitter.Close() })
+
+	table, err := routing.Parse([]byte(`routes:
+  - {method: GET, path: "/api/v1/users/{id}", topic: auth.get_user, event: get_user, path_params: [id], timeout: 2s}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.Routes[0].Retry = policy
+
+	srv, err := NewServer(emitter, nil, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	srv.Retries = retry.NewSet()
+	return srv
+}
+
+func get(srv *Server, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.serveTable(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHedgedRequestBeatsStalledWorker(t *testing.T) {
+	ok := contracts.Reply{Status: http.StatusOK, Body: json.RawMessage(`{"id":"1"}`)}
+	ch := &scriptedChannel{
+		fakeChannel: newFakeChannel(0),
+		// The first copy lands on a stalled worker that answers late; the
+		// hedged copy is answered at once
+		answer: func(n int) (time.Duration, contracts.Reply, bool) {
+			if n == 0 {
+				return 300 * time.Millisecond, ok, true
+			}
+			return 0, ok, true
+		},
+	}
+	srv := retryServer(t, ch, routing.RetryPolicy{Attempts: 1, Hedge: true, HedgeDelay: 20 * time.Millisecond, AttemptTimeout: 2 * time.Second, Budget: 0.1})
+
+	start := time.Now()
+	rec := get(srv, "/api/v1/users/1")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"id":"1"}` {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("hedged request took %v, waited for the stalled worker", elapsed)
+	}
+
+	ids := ch.correlationIDs()
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Fatalf("published %v, want the request and one hedge under the same correlation ID", ids)
+	}
+
+	// The stalled worker's late reply must find nobody waiting
+	time.Sleep(350 * time.Millisecond)
+	if n := srv.Emitter.Pending(); n != 0 {
+		t.Errorf("%d waiters left after the duplicate reply", n)
+	}
+}
+
+func TestRetryAfterUnavailableAndTimeout(t *testing.T) {
+	ch := &scriptedChannel{
+		fakeChannel: newFakeChannel(0),
+		answer: func(n int) (time.Duration, contracts.Reply, bool) {
+			switch n {
+			case 0:
+				return 0, contracts.Reply{Status: http.StatusServiceUnavailable, Error: contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "busy")}, true
+			case 1:
+				return 0, contracts.Reply{}, false // lost
+			}
+			return 0, contracts.Reply{Status: http.StatusOK, Body: json.RawMessage(`{"id":"1"}`)}, true
+		},
+	}
+	srv := retryServer(t, ch, routing.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, AttemptTimeout: 50 * time.Millisecond, Budget: 0.1})
+
+	rec := get(srv, "/api/v1/users/1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want the third try's reply", rec.Code, rec.Body)
+	}
+	ids := ch.correlationIDs()
+	if len(ids) != 3 || ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Errorf("published %v, want three tries with their own correlation IDs", ids)
+	}
+}
+
+func TestRetriesStopWhenBudgetIsSpent(t *testing.T) {
+	unavailable := contracts.Reply{Status: http.StatusServiceUnavailable, Error: contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "down")}
+	ch := &scriptedChannel{
+		fakeChannel: newFakeChannel(0),
+		answer: func(int) (time.Duration, contracts.Reply, bool) {
+			return 0, unavailable, true
+		},
+	}
+	srv := retryServer(t, ch, routing.RetryPolicy{Attempts: 3, Backoff: time.Microsecond, MaxBackoff: time.Microsecond, AttemptTimeout: time.Second, Budget: 0.1})
+	srv.Breakers = nil
+
+	const requests = 20
+	for range requests {
+		if rec := get(srv, "/api/v1/users/1"); rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("got %d, want 503", rec.Code)
+		}
+	}
+
+	// 10 retries in reserve plus 0.1 earned per request, short of the two
+	// retries every request would make without a budget
+	if n := len(ch.correlationIDs()); n > requests+10+requests/10+1 {
+		t.Errorf("%d publishes for %d requests, the budget did not hold", n, requests)
+	}
+}
+
+func TestBackoffGrowsWithJitter(t *testing.T) {
+	p := routing.RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 6: 50 * time.Millisecond} {
+		for range 50 {
+			if d := retry.Backoff(p, attempt); d < want/2 || d > want {
+				t.Fatalf("attempt %d: backoff %v outside [%v, %v]", attempt, d, want/2, want)
+			}
+		}
+	}
+}
@@ -2,30 +2,52 @@ package server
 
 import (
 	"net/http"
-	"time"
 
 	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/contracts/metrics"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 )
 
+const (
+	graphPath     = "/api/v1/graphql"
+	wsPath        = "/api/v1/ws"
+	wsTicketsPath = "/api/v1/ws/tickets"
+)
+
 func (s *Server) Routes() http.Handler {
-	mux := chi.NewRouter()
+	root := chi.NewRouter()
+	root.Use(middleware.Recoverer)
 
-	mux.Use(middleware.Recoverer)
+	// Probes sit outside the API middleware to keep them out of the logs,
+	// metrics and rate limits
+	root.Get("/healthz", s.Healthz)
+	root.Get("/readyz", s.Readyz)
+
+	mux := chi.NewRouter()
+	// Start the request's span first, so every later middleware and the
+	// events it publishes belong to the caller's trace
+	mux.Use(trace.Middleware)
+	// Events published for the request tell services who the client is
+	mux.Use(s.RateLimits.IPs.RelayClientIP)
+	mux.Use(metrics.Middleware(routePattern))
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Idempotency-Key", "If-None-Match", "Cache-Control", "traceparent", "tracestate"},
+		ExposedHeaders:   []string{"Link", "Location", "ETag", "X-Cache", "Idempotent-Replayed", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "X-Trace-ID"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
-	// Rate limiter: 1000 requests per second per IP+endpoint
-	rateLimiter := brokermw.NewRateLimiter(1000, time.Second)
-	mux.Use(rateLimiter.Middleware)
+	// Per-client budget across all endpoints; routes may add their own
+	mux.Use(brokermw.RateLimit(s.RateLimits.Limiter, brokermw.Policy{
+		Name:  "client",
+		Limit: s.clientLimit,
+		Key:   s.clientKey,
+	}))
 
 	// Add logging middleware
 	if s.Logger != nil {
@@ -34,12 +56,39 @@ func (s *Server) Routes() http.Handler {
 		mux.Use(middleware.Logger)
 	}
 
-	mux.Route("/api/v1", func(r chi.Router) {
-		// Auth routes
-		r.Get("/users", s.GetUsersHandler)
-		r.Get("/users/{id}", s.GetUserHandler)
-		r.Post("/register", s.RegisterHandler)
-	})
+	// Event-backed endpoints come from the route table (see routing/routes.yaml).
+	// Fixed endpoints must be registered by full path rather than under a
+	// sub-router, or they would shadow the table's routes with the same prefix.
+	mux.With(brokermw.Authenticate(s.Auth, true)).Get("/api/v1/admin/breakers", s.GetBreakers)
+	mux.Handle(metrics.Path, metrics.Handler())
+	mux.Get("/api/v1/openapi.json", s.GetOpenAPI)
+	mux.Get("/api/v1/docs", s.GetDocs)
+	mux.With(s.batch.Middleware).Post(batchPath, s.Batch)
+	mux.Post(graphPath, s.Graph.ServeHTTP)
+	mux.Get("/api/v1/jobs/{id}", s.GetJob)
+	mux.Get("/api/v1/jobs/{id}/events", s.StreamJob)
+	if s.Gateway != nil {
+		// Browsers trade their credentials for a ticket, which they can
+		// pass on the handshake
+		mux.With(brokermw.Authenticate(s.Auth, true)).Post(wsTicketsPath, s.IssueWSTicket)
+		mux.With(brokermw.Authenticate(s.Tickets, true)).Get(wsPath, s.Gateway.ServeWS)
+	}
+	mux.Handle("/*", http.HandlerFunc(s.serveTable))
+
+	root.Mount("/", mux)
+	return root
+}
 
-	return mux
+// routePattern labels request metrics with the matched route, including
+// route table patterns, which chi appends to the /* they are served under.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	pattern := rctx.RoutePattern()
+	if pattern == "/*" {
+		return ""
+	}
+	return pattern
 }
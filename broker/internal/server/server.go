@@ -1,18 +1,222 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/breaker"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/cache"
 	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/gateway"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/graph"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/idempotency"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/jobs"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/ratelimit"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/retry"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/validation"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
 	"github.com/Flaviogonzalez/e-commerce/contracts/logger"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultBulkhead   = 256  // concurrent RPCs per topic
+	defaultClientRate = 1000 // requests per second per client
+	defaultTicketTTL  = 30 * time.Second
 )
 
 type Server struct {
 	Emitter *event.Emitter
 	Logger  *logger.Logger
+	Auth    brokermw.Authenticator // optional; routes requiring auth reject every request without it
+	Jobs    jobs.Store             // state of async routes
+	Gateway *gateway.Hub           // optional; serves /api/v1/ws when set
+	Tickets *brokermw.Tickets      // admit WebSocket handshakes
+	Graph   *graph.Handler         // serves /api/v1/graphql
+
+	// Breakers guard sync routes per topic; nil disables them
+	Breakers *breaker.Set
+	// Retries run the retry policies of sync GET routes; nil disables them
+	Retries *retry.Set
+	// Cache serves routes with a cache policy; feed it events through
+	// Cache.Event so they invalidate cached responses
+	Cache *cache.Cache
+
+	// Idempotency stores responses of mutating routes by Idempotency-Key.
+	// Routes capture it when built, so a change applies from the next
+	// SetTable call; the same goes for RateLimits.
+	Idempotency idempotency.Store
+	RateLimits  RateLimits
+
+	rateStore *ratelimit.MemoryStore
+	batch     *validation.Validator
+
+	table  atomic.Pointer[routing.Table]
+	router atomic.Pointer[chi.Mux]
+
+	shuttingDown atomic.Bool
+	shutdown     chan struct{} // closed by SetShuttingDown
+	shutdownOnce sync.Once
+}
+
+// RateLimits configures per-client rate limiting. Every client has its
+// own bucket: authenticated callers by subject, everyone else by IP.
+type RateLimits struct {
+	Limiter  *ratelimit.Limiter
+	Client   ratelimit.Limit            // default per-client limit
+	Subjects map[string]ratelimit.Limit // overrides for specific users or API keys
+	IPs      *brokermw.IPResolver
 }
 
-func NewServer(emitter *event.Emitter, log *logger.Logger) *Server {
-	return &Server{
+func NewServer(emitter *event.Emitter, log *logger.Logger, table *routing.Table) (*Server, error) {
+	batch, err := batchValidator()
+	if err != nil {
+		return nil, err
+	}
+	rateStore := ratelimit.NewMemoryStore(0)
+
+	s := &Server{
 		Emitter: emitter,
 		Logger:  log,
+		Jobs:    jobs.NewMemoryStore(0),
+		Tickets: brokermw.NewTickets(nil, defaultTicketTTL),
+
+		Breakers: breaker.NewSet(breaker.Config{MaxConcurrent: defaultBulkhead}),
+		Retries:  retry.NewSet(),
+		Cache:    cache.New(0),
+
+		Idempotency: idempotency.NewMemoryStore(),
+		RateLimits: RateLimits{
+			Limiter: ratelimit.NewLimiter(rateStore),
+			Client:  ratelimit.PerSecond(defaultClientRate),
+			IPs:     brokermw.NewIPResolver(nil),
+		},
+
+		rateStore: rateStore,
+		batch:     batch,
+		shutdown:  make(chan struct{}),
+	}
+	s.Tickets.SetLedger(s.TicketLedger())
+	if s.Graph, err = s.NewGraph(graph.Config{}); err != nil {
+		rateStore.Close()
+		return nil, err
+	}
+	if err := s.SetTable(table); err != nil {
+		rateStore.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetShuttingDown makes /readyz report not-ready so load balancers stop
+// routing new requests while in-flight ones drain. Job event streams end,
+// letting their clients reconnect to another replica.
+func (s *Server) SetShuttingDown() {
+	s.shutdownOnce.Do(func() {
+		s.shuttingDown.Store(true)
+		close(s.shutdown)
+	})
+}
+
+// Close releases background resources held by the server.
+func (s *Server) Close() {
+	s.rateStore.Close()
+}
+
+// clientKey names the caller for rate limiting: the authenticated subject
+// when the request carries valid credentials, the client IP otherwise.
+func (s *Server) clientKey(r *http.Request) string {
+	if s.Auth != nil {
+		if id, err := s.Auth.Authenticate(r); err == nil {
+			return "sub:" + id.Subject
+		}
 	}
+	return "ip:" + s.RateLimits.IPs.ClientIP(r)
+}
+
+// clientLimit returns the limit for the caller, honoring per-subject
+// overrides.
+func (s *Server) clientLimit(r *http.Request) ratelimit.Limit {
+	if len(s.RateLimits.Subjects) > 0 && s.Auth != nil {
+		if id, err := s.Auth.Authenticate(r); err == nil {
+			if limit, ok := s.RateLimits.Subjects[id.Subject]; ok {
+				return limit
+			}
+		}
+	}
+	return s.RateLimits.Client
+}
+
+// pushError reports a failed Push as problem details. Errors
+// caused by a lost RabbitMQ connection are retriable and answered with 503
+// so clients back off and retry instead of treating them as server faults.
+func pushError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rabbit.ErrUnroutable):
+		// No service consumes the topic; waiting would not change that
+		writeError(w, contracts.NewError(http.StatusBadGateway, contracts.ErrCodeBadGateway, "No upstream service handles this request"))
+	case rabbit.IsRetriable(err):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, "Upstream temporarily unavailable"))
+	case errors.Is(err, event.ErrTimeout):
+		writeError(w, contracts.NewError(http.StatusGatewayTimeout, contracts.ErrCodeTimeout, "Timed out waiting for upstream"))
+	case errors.Is(err, context.Canceled):
+		// Client went away; nobody is left to read a response
+	default:
+		writeError(w, contracts.ErrInternal())
+	}
+}
+
+// breakerError answers a request rejected by a circuit breaker or bulkhead
+// with 503, telling the client when to come back.
+func breakerError(w http.ResponseWriter, err error, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := "Upstream temporarily unavailable"
+	if errors.Is(err, breaker.ErrBulkheadFull) {
+		message = "Too many requests in flight upstream"
+	}
+	writeError(w, contracts.NewError(http.StatusServiceUnavailable, contracts.ErrCodeUnavailable, message))
+}
+
+// statusWriter records the status of the response written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// writeError answers with apiErr as problem details.
+func writeError(w http.ResponseWriter, apiErr *contracts.Error) {
+	event.WriteReply(w, &contracts.Reply{Status: apiErr.Status, Error: apiErr})
 }
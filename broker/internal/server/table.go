@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/breaker"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/idempotency"
+	brokermw "github.com/Flaviogonzalez/e-commerce/broker/internal/middleware"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/validation"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/go-chi/chi/v5"
+)
+
+// SetTable builds a router for t and swaps it in atomically. Requests
+// already being served finish on the previous router. If a route's body
+// schema does not compile, the current table stays in place.
+func (s *Server) SetTable(t *routing.Table) error {
+	router := chi.NewRouter()
+
+	for _, route := range t.Routes {
+		var h http.Handler = s.routeHandler(route)
+		if route.Cache.TTL > 0 && s.Cache != nil {
+			h = s.Cache.Middleware(route)(h)
+		}
+		if route.Method != http.MethodGet && s.Idempotency != nil {
+			h = idempotency.Middleware(s.Idempotency, route.Timeout, idempotency.DefaultTTL)(h)
+		}
+		if route.Body {
+			v, err := validation.Compile(route)
+			if err != nil {
+				return err
+			}
+			h = v.Middleware(h)
+		}
+		if !route.RateLimit.IsZero() && s.RateLimits.Limiter != nil {
+			h = brokermw.RateLimit(s.RateLimits.Limiter, brokermw.Policy{
+				Name:  "route:" + route.Method + " " + route.Path,
+				Limit: brokermw.StaticLimit(route.RateLimit),
+				Key:   s.clientKey,
+			})(h)
+		}
+		if route.Auth != routing.AuthNone {
+			h = brokermw.Authenticate(s.Auth, route.Auth == routing.AuthRequired)(h)
+		}
+		router.Method(route.Method, route.Path, h)
+	}
+
+	if s.Cache != nil {
+		s.Cache.SetTable(t)
+	}
+	s.table.Store(t)
+	s.router.Store(router)
+	return nil
+}
+
+// Table returns the route table currently being served.
+func (s *Server) Table() *routing.Table {
+	return s.table.Load()
+}
+
+// serveTable dispatches to the router built from the current route table.
+func (s *Server) serveTable(w http.ResponseWriter, r *http.Request) {
+	router := s.router.Load()
+	if router == nil {
+		http.NotFound(w, r)
+		return
+	}
+	router.ServeHTTP(w, r)
+}
+
+// routeHandler publishes the event declared by route for each request.
+func (s *Server) routeHandler(route routing.Route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, apiErr := eventData(route, r)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+
+		payload := contracts.TopicPayload{
+			Name: route.Topic,
+			Event: contracts.EventPayload{
+				Name: route.Event,
+				Data: data,
+			},
+		}
+
+		if route.Mode == routing.ModeAsync {
+			s.startJob(w, r, route, payload)
+			return
+		}
+
+		s.push(w, r, route, payload)
+	}
+}
+
+// push runs a sync route's RPC behind its topic's circuit breaker, so a
+// failing service is answered with 503 at once instead of tying up a
+// goroutine and a reply slot for the whole timeout.
+func (s *Server) push(w http.ResponseWriter, r *http.Request, route routing.Route, payload contracts.TopicPayload) {
+	done := func(breaker.Result) {}
+	if s.Breakers != nil {
+		var retryAfter time.Duration
+		var err error
+		done, retryAfter, err = s.Breakers.Get(route.Topic).Allow()
+		if err != nil {
+			breakerError(w, err, retryAfter)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), route.Timeout)
+	defer cancel()
+
+	sw := &statusWriter{ResponseWriter: w}
+	err := s.call(ctx, sw, route, payload)
+	switch {
+	case errors.Is(err, context.Canceled):
+		done(breaker.Ignored)
+	case err != nil, sw.status >= http.StatusInternalServerError:
+		done(breaker.Failure)
+	default:
+		done(breaker.Success)
+	}
+
+	if err != nil {
+		pushError(w, err)
+	}
+}
+
+// call runs the RPC of route, under its retry policy if it has one, and
+// writes the reply.
+func (s *Server) call(ctx context.Context, w http.ResponseWriter, route routing.Route, payload contracts.TopicPayload) error {
+	if !route.Retry.Enabled() || s.Retries == nil {
+		return s.Emitter.Push(ctx, w, payload)
+	}
+
+	reply, err := s.Retries.Route(route).Do(ctx, func(ctx context.Context, hedge event.Hedge) (*contracts.Reply, error) {
+		return s.Emitter.CallHedged(ctx, payload, hedge)
+	})
+	if err != nil {
+		return err
+	}
+	return event.WriteReply(w, reply)
+}
+
+// eventData builds the event data for a request. A forwarded body is passed
+// through untouched unless params must be merged in, in which case the
+// body has to be a JSON object.
+func eventData(route routing.Route, r *http.Request) (json.RawMessage, *contracts.Error) {
+	var body []byte
+	if route.Body {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Failed to read body")
+		}
+		defer r.Body.Close()
+	}
+
+	if len(route.PathParams) == 0 && len(route.QueryParams) == 0 {
+		if len(body) == 0 {
+			return nil, nil
+		}
+		return json.RawMessage(body), nil
+	}
+
+	fields := make(map[string]any)
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Request body must be a JSON object")
+		}
+	}
+
+	for _, name := range route.PathParams {
+		fields[name] = chi.URLParam(r, name)
+	}
+
+	query := r.URL.Query()
+	for _, name := range route.QueryParams {
+		if v := query.Get(name); v != "" {
+			fields[name] = v
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, contracts.ErrInternal()
+	}
+	return data, nil
+}
+
+func mustJSON(v any) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/event"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Middleware enforces v on request bodies: the size limit (413), a JSON
+// content type (415), well-formed JSON and the schema (400). Failures are
+// answered as problem details with one entry per invalid field. The body
+// is handed on unchanged.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			mediaType, _, err := mime.ParseMediaType(ct)
+			if err != nil || mediaType != "application/json" {
+				event.WriteProblem(w, contracts.NewError(http.StatusUnsupportedMediaType, contracts.ErrCodeUnsupportedMedia, "Request body must be application/json"))
+				return
+			}
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, v.maxBody))
+		r.Body.Close()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				event.WriteProblem(w, contracts.NewError(http.StatusRequestEntityTooLarge, contracts.ErrCodePayloadTooLarge, "Request body is too large").
+					WithField("body", "must be at most "+formatBytes(tooLarge.Limit)))
+				return
+			}
+			event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Failed to read body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if v.schema == nil && len(body) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(body) == 0 {
+			event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Request body is required"))
+			return
+		}
+		decoded, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+		if err != nil {
+			event.WriteProblem(w, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Request body must be valid JSON"))
+			return
+		}
+
+		if fields := v.Validate(decoded); fields != nil {
+			apiErr := contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Request body is invalid")
+			for field, msg := range fields {
+				if field == "" {
+					field = "body"
+				}
+				apiErr.WithField(field, msg)
+			}
+			event.WriteProblem(w, apiErr)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return strconv.FormatInt(n>>20, 10) + " MiB"
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return strconv.FormatInt(n>>10, 10) + " KiB"
+	default:
+		return strconv.FormatInt(n, 10) + " bytes"
+	}
+}
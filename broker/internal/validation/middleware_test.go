@@ -0,0 +1,246 @@
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Flaviogonzalez/e-commerce/broker/internal/routing"
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+)
+
+// compile builds the validator of the only route in a route table.
+func compile(t *testing.T, table string) *Validator {
+	t.Helper()
+
+	parsed, err := routing.Parse([]byte(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Compile(parsed.Routes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+const registerRoute = `routes:
+  - {method: POST, path: /api/v1/register, topic: auth.register, event: register, body: true, request: AuthRegisterRequest, max_body: 256}`
+
+const inlineRoute = `routes:
+  - method: POST
+    path: /api/v1/addresses
+    topic: user.add_address
+    event: add_address
+    body: true
+    schema:
+      type: object
+      additionalProperties: false
+      required: [address]
+      properties:
+        address:
+          type: object
+          additionalProperties: false
+          required: [street, city]
+          properties:
+            street: {type: string}
+            city: {type: string}
+            email: {type: string, format: email}
+        tags:
+          type: array
+          items: {type: string, maxLength: 3}`
+
+// post sends body through v and returns the response and the problem
+// details it carries, if any.
+func post(t *testing.T, v *Validator, contentType, body string) (*httptest.ResponseRecorder, contracts.ProblemPayload, bool) {
+	t.Helper()
+
+	var reached bool
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+
+	var problem contracts.ProblemPayload
+	if !reached {
+		if ct := rec.Header().Get("Content-Type"); ct != contracts.ProblemContentType {
+			t.Errorf("Content-Type %q, want %s", ct, contracts.ProblemContentType)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("decode problem: %v: %s", err, rec.Body)
+		}
+		if problem.Status != rec.Code {
+			t.Errorf("problem status %d, response status %d", problem.Status, rec.Code)
+		}
+	}
+	return rec, problem, reached
+}
+
+// fields lists a problem's invalid fields.
+func fields(p contracts.ProblemPayload) map[string]string {
+	out := make(map[string]string)
+	for _, e := range p.Errors {
+		out[e.Field] = e.Message
+	}
+	return out
+}
+
+func TestValidBodyPasses(t *testing.T) {
+	v := compile(t, registerRoute)
+	body := `{"email":"alice@example.com","password":"correct horse"}`
+	for _, ct := range []string{"application/json", "application/json; charset=utf-8", ""} {
+		if rec, _, reached := post(t, v, ct, body); !reached {
+			t.Errorf("Content-Type %q: rejected with %d %s", ct, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	v := compile(t, registerRoute)
+	body := `{"email":"alice@example.com","password":"` + strings.Repeat("x", 300) + `"}`
+
+	rec, problem, reached := post(t, v, "application/json", body)
+	if reached || rec.Code != http.StatusRequestEntityTooLarge || problem.Code != contracts.ErrCodePayloadTooLarge {
+		t.Fatalf("got %d %s", rec.Code, rec.Body)
+	}
+	if got := fields(problem)["body"]; got != "must be at most 256 bytes" {
+		t.Errorf("body message %q", got)
+	}
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+	v := compile(t, registerRoute)
+	for _, ct := range []string{"text/plain", "application/x-www-form-urlencoded", "application/json+x", "not a media type"} {
+		rec, problem, reached := post(t, v, ct, `{}`)
+		if reached || rec.Code != http.StatusUnsupportedMediaType || problem.Code != contracts.ErrCodeUnsupportedMedia {
+			t.Errorf("Content-Type %q: got %d %s", ct, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestMalformedBody(t *testing.T) {
+	v := compile(t, registerRoute)
+	for _, body := range []string{"", "{", `{"email":}`, "[1,2"} {
+		rec, problem, reached := post(t, v, "application/json", body)
+		if reached || rec.Code != http.StatusBadRequest || problem.Code != contracts.ErrCodeInvalidPayload {
+			t.Errorf("body %q: got %d %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	v := compile(t, inlineRoute)
+
+	tests := []struct {
+		name string
+		body string
+		want map[string]string // field to a substring of its message
+	}{
+		{"required", `{}`, map[string]string{"address": "is required"}},
+		{"nested required", `{"address":{}}`, map[string]string{
+			"address.street": "is required",
+			"address.city":   "is required",
+		}},
+		{"additional properties", `{"address":{"street":"s","city":"c","zip":"1"},"extra":1}`, map[string]string{
+			"address.zip": "is not allowed",
+			"extra":       "is not allowed",
+		}},
+		{"format", `{"address":{"street":"s","city":"c","email":"not an email"}}`, map[string]string{
+			"address.email": "email",
+		}},
+		{"type", `{"address":{"street":1,"city":"c"}}`, map[string]string{
+			"address.street": "string",
+		}},
+		{"array items", `{"address":{"street":"s","city":"c"},"tags":["ok","toolong"]}`, map[string]string{
+			"tags.1": "3",
+		}},
+		{"whole body", `[]`, map[string]string{
+			"body": "object",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, problem, reached := post(t, v, "application/json", tt.body)
+			if reached || rec.Code != http.StatusBadRequest || problem.Code != contracts.ErrCodeValidation {
+				t.Fatalf("got %d %s", rec.Code, rec.Body)
+			}
+
+			got := fields(problem)
+			if len(got) != len(tt.want) {
+				t.Errorf("fields %v, want %v", got, tt.want)
+			}
+			for field, msg := range tt.want {
+				if !strings.Contains(got[field], msg) {
+					t.Errorf("%s: message %q, want it to mention %q", field, got[field], msg)
+				}
+				// The legacy fields map carries the same entries
+				if problem.Fields[field] != got[field] {
+					t.Errorf("%s: fields map has %q, errors %q", field, problem.Fields[field], got[field])
+				}
+			}
+		})
+	}
+}
+
+func TestSchemaFromContractsType(t *testing.T) {
+	v := compile(t, registerRoute)
+
+	tests := []struct {
+		body   string
+		fields []string
+	}{
+		{`{"email":"alice@example.com","password":"correct horse","name":"Alice","policy":1}`, nil},
+		{`{"password":"correct horse"}`, []string{"email"}},
+		{`{"email":"alice","password":"short"}`, []string{"email", "password"}},
+		{`{"email":"alice@example.com","password":"correct horse","policy":-1}`, []string{"policy"}},
+		// Derived schemas leave room for fields newer clients send
+		{`{"email":"alice@example.com","password":"correct horse","locale":"en"}`, nil},
+	}
+	for _, tt := range tests {
+		rec, problem, reached := post(t, v, "application/json", tt.body)
+		if tt.fields == nil {
+			if !reached {
+				t.Errorf("%s: rejected with %d %s", tt.body, rec.Code, rec.Body)
+			}
+			continue
+		}
+		if reached {
+			t.Errorf("%s: accepted", tt.body)
+			continue
+		}
+
+		got := fields(problem)
+		for _, field := range tt.fields {
+			if _, ok := got[field]; !ok {
+				t.Errorf("%s: no error for %s in %v", tt.body, field, got)
+			}
+		}
+		if len(got) != len(tt.fields) {
+			t.Errorf("%s: errors for %v, want %v", tt.body, got, tt.fields)
+		}
+	}
+}
+
+func TestRouteWithoutSchema(t *testing.T) {
+	v := compile(t, `routes:
+  - {method: POST, path: /api/v1/things, topic: things.create, event: create, body: true}`)
+
+	if _, _, reached := post(t, v, "application/json", ""); !reached {
+		t.Error("empty body rejected without a schema")
+	}
+	if _, _, reached := post(t, v, "application/json", `{"anything":[1,2]}`); !reached {
+		t.Error("JSON body rejected without a schema")
+	}
+	if rec, _, reached := post(t, v, "application/json", `{`); reached || rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed body: got %d", rec.Code)
+	}
+}
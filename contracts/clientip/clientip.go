@@ -0,0 +1,103 @@
+// Package clientip carries the address of the client behind a request from
+// the broker, which resolves it at the edge, to the services acting on it,
+// such as auth throttling logins per subnet. A service only believes an
+// address relayed by a proxy it trusts, since anyone else can send one.
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+const (
+	// Header relays the client address over HTTP
+	Header = "X-Client-IP"
+	// Key relays it in AMQP headers and gRPC metadata
+	Key = "x-client-ip"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a context carrying the client address ip.
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, ip)
+}
+
+// FromContext returns the client address carried by ctx.
+func FromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(ctxKey{}).(string)
+	return ip, ok && ip != ""
+}
+
+// Proxies are the addresses whose relayed client address is believed.
+type Proxies []netip.Prefix
+
+// ParseProxies reads a comma-separated list of CIDRs or single addresses.
+func ParseProxies(spec string) (Proxies, error) {
+	var proxies Proxies
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Contains reports whether addr is a trusted proxy.
+func (p Proxies) Contains(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client address of a request that came from remote
+// (host or host:port) relaying the address relayed. The relayed address
+// counts only when remote is a trusted proxy. A trusted proxy that relayed
+// none yields "", so the clients behind it are not all taken for the proxy
+// itself.
+func (p Proxies) Resolve(remote, relayed string) string {
+	addr, ok := parseHost(remote)
+	if !ok {
+		return ""
+	}
+	if !p.Contains(addr) {
+		return addr.String()
+	}
+
+	client, err := netip.ParseAddr(strings.TrimSpace(relayed))
+	if err != nil {
+		return ""
+	}
+	return client.Unmap().String()
+}
+
+func parseHost(hostport string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
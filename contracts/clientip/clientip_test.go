@@ -0,0 +1,35 @@
+package clientip
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote, relayed, want string
+	}{
+		{"10.1.2.3:5000", "203.0.113.7", "203.0.113.7"},    // trusted proxy
+		{"192.0.2.1:5000", " 2001:db8::1 ", "2001:db8::1"}, // single trusted address
+		{"198.51.100.9:5000", "203.0.113.7", "198.51.100.9"},
+		{"[::ffff:198.51.100.9]:5000", "", "198.51.100.9"},
+		{"10.1.2.3:5000", "", ""}, // trusted proxy relaying nothing
+		{"10.1.2.3:5000", "not-an-ip", ""},
+		{"bogus", "203.0.113.7", ""},
+	}
+	for _, tt := range tests {
+		if got := proxies.Resolve(tt.remote, tt.relayed); got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %q, want %q", tt.remote, tt.relayed, got, tt.want)
+		}
+	}
+
+	var none Proxies
+	if got := none.Resolve("10.1.2.3:5000", "203.0.113.7"); got != "10.1.2.3" {
+		t.Errorf("no trusted proxies: got %q, want the remote address", got)
+	}
+	if _, err := ParseProxies("10.0.0.0/33"); err == nil {
+		t.Error("invalid prefix accepted")
+	}
+}
@@ -21,6 +21,19 @@ type TopicPayload struct {
 	Event EventPayload `json:"event"`
 }
 
+// ReplyMessageType marks AMQP messages whose body is a Reply envelope.
+const ReplyMessageType = "reply.v1"
+
+// Reply is the envelope a listener sends back for an RPC event. It carries
+// the downstream HTTP outcome so the broker can answer with the same status:
+// Body holds the success payload, Error the typed failure.
+type Reply struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Error   *Error            `json:"error,omitempty"`
+}
+
 // Log types
 type LogLevel string
 
@@ -53,11 +66,16 @@ type LogEntry struct {
 }
 
 // Auth types
+//
+// Request types carry jsonschema tags with the constraints the broker
+// enforces before publishing: comma-separated keywords such as
+// format=email or maxLength=72, and "optional" for fields that may be
+// omitted despite lacking omitempty.
 type AuthRegisterRequest struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Policy   int32  `json:"policy"`
+	Name     string `json:"name" jsonschema:"optional,maxLength=100"`
+	Email    string `json:"email" jsonschema:"format=email,maxLength=254"`
+	Password string `json:"password" jsonschema:"minLength=8,maxLength=72"`
+	Policy   int32  `json:"policy" jsonschema:"optional,minimum=0"`
 }
 
 type AuthRegisterResponse struct {
@@ -65,6 +83,74 @@ type AuthRegisterResponse struct {
 }
 
 type AuthLoginRequest struct { // credentials method
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email     string `json:"email" jsonschema:"format=email,maxLength=254"`
+	Password  string `json:"password" jsonschema:"minLength=1"`
+	Challenge string `json:"challenge,omitempty"` // proof-of-work or CAPTCHA token, required after suspicious activity
+}
+
+type AuthLoginResponse struct {
+	Payload
+	UserID string `json:"user_id,omitempty"`
+}
+
+// AuthUser is the public view of a user returned by the user lookup
+// endpoints. It mirrors the rows auth selects, without credentials.
+type AuthUser struct {
+	ID            string     `json:"id"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	Phone         *string    `json:"phone"`
+	PhoneVerified bool       `json:"phone_verified"`
+	AvatarURL     *string    `json:"avatar_url"`
+	Status        string     `json:"status"`
+	Role          string     `json:"role"`
+	LastLoginAt   *time.Time `json:"last_login_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Batch types
+//
+// A batch runs several broker routes in one round trip. Items run
+// concurrently and each gets its own status, so one failing item does not
+// fail the batch.
+type BatchRequest struct {
+	Requests []BatchItem `json:"requests" jsonschema:"minItems=1,maxItems=20"`
+}
+
+type BatchItem struct {
+	ID      string            `json:"id,omitempty"` // echoed in the result; defaults to the item's index
+	Method  string            `json:"method" jsonschema:"pattern=^(GET|POST|PUT|PATCH|DELETE)$"`
+	Path    string            `json:"path" jsonschema:"pattern=^/api/,maxLength=2048"` // may carry a query string
+	Headers map[string]string `json:"headers,omitempty"`                               // only Idempotency-Key, If-None-Match and Accept
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+type BatchResponse struct {
+	Responses []BatchResult `json:"responses"`
+}
+
+// BatchResult is the outcome of one item, in request order. Body holds the
+// item's JSON response as is; other content is passed as a JSON string.
+type BatchResult struct {
+	ID      string            `json:"id"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// WSTicketRequest is the optional body of a ticket request. A service
+// that signs in its own users, such as the dashboard, names the user the
+// ticket is for; the ticket's subject is then the service's subject and
+// the user's, joined by '|'.
+type WSTicketRequest struct {
+	Subject string `json:"subject,omitempty" jsonschema:"maxLength=128"`
+}
+
+// WSTicket admits one WebSocket connection to the broker's gateway. Clients
+// pass it as the ticket query parameter before it expires, seconds after
+// it was issued; a ticket is refused once it has been used.
+type WSTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
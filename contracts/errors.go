@@ -0,0 +1,154 @@
+package contracts
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ErrorCode is a stable, machine-readable identifier for an error. Clients
+// should branch on the code, never on the human-readable message.
+type ErrorCode string
+
+const (
+	ErrCodeInvalidPayload     ErrorCode = "invalid_payload"
+	ErrCodePayloadTooLarge    ErrorCode = "payload_too_large"
+	ErrCodeUnsupportedMedia   ErrorCode = "unsupported_media_type"
+	ErrCodeValidation         ErrorCode = "validation_failed"
+	ErrCodeNotFound           ErrorCode = "not_found"
+	ErrCodeConflict           ErrorCode = "conflict"
+	ErrCodeEmailTaken         ErrorCode = "email_taken"
+	ErrCodeInvalidCredentials ErrorCode = "invalid_credentials"
+	ErrCodeUnauthorized       ErrorCode = "unauthorized"
+	ErrCodeRateLimited        ErrorCode = "rate_limited"
+	ErrCodeChallengeRequired  ErrorCode = "challenge_required"
+	ErrCodeUnavailable        ErrorCode = "service_unavailable"
+	ErrCodeTimeout            ErrorCode = "upstream_timeout"
+	ErrCodeBadGateway         ErrorCode = "bad_gateway"
+	ErrCodeInternal           ErrorCode = "internal_error"
+	ErrCodeIdempotencyReused  ErrorCode = "idempotency_key_reused"
+	ErrCodeRequestInProgress  ErrorCode = "request_in_progress"
+	ErrCodeQueryTooComplex    ErrorCode = "query_too_complex"
+)
+
+// Error is the structured error returned by services. It serializes as a
+// Payload with the code and optional per-field messages alongside, so older
+// clients reading only "error" and "message" keep working.
+type Error struct {
+	Status  int               `json:"-"`
+	Code    ErrorCode         `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// ErrorPayload is the wire form of Error.
+type ErrorPayload struct {
+	Payload
+	Code   ErrorCode         `json:"code"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// ProblemPayload is Error in the problem details format of RFC 9457,
+// served as application/problem+json. It keeps the ErrorPayload members,
+// so clients reading either format work.
+type ProblemPayload struct {
+	ErrorPayload
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is one invalid field; nested fields are joined with dots.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ProblemContentType is the media type of ProblemPayload.
+const ProblemContentType = "application/problem+json"
+
+func NewError(status int, code ErrorCode, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// WithField attaches a validation message for a single request field.
+func (e *Error) WithField(field, message string) *Error {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[field] = message
+	return e
+}
+
+// ToPayload converts the error to its wire form.
+func (e *Error) ToPayload() ErrorPayload {
+	return ErrorPayload{
+		Payload: Payload{
+			Error:   true,
+			Message: e.Message,
+		},
+		Code:   e.Code,
+		Fields: e.Fields,
+	}
+}
+
+// ToProblem converts e to problem details. The type URI names the code, so
+// clients can branch on it as on Code.
+func (e *Error) ToProblem() ProblemPayload {
+	p := ProblemPayload{
+		ErrorPayload: e.ToPayload(),
+		Type:         "urn:problem-type:" + string(e.Code),
+		Title:        http.StatusText(e.Status),
+		Status:       e.Status,
+	}
+	for field, message := range e.Fields {
+		p.Errors = append(p.Errors, FieldError{Field: field, Message: message})
+	}
+	sort.Slice(p.Errors, func(i, j int) bool { return p.Errors[i].Field < p.Errors[j].Field })
+	return p
+}
+
+// ErrInternal is returned for unexpected failures; it deliberately carries
+// no detail about the underlying cause.
+func ErrInternal() *Error {
+	return NewError(http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+}
+
+// ErrorFromStatus builds an Error for a response that carried no structured
+// error body, picking the code from the HTTP status.
+func ErrorFromStatus(status int, message string) *Error {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	code := ErrCodeInternal
+	switch {
+	case status == http.StatusNotFound:
+		code = ErrCodeNotFound
+	case status == http.StatusConflict:
+		code = ErrCodeConflict
+	case status == http.StatusUnauthorized:
+		code = ErrCodeInvalidCredentials
+	case status == http.StatusTooManyRequests:
+		code = ErrCodeRateLimited
+	case status == http.StatusBadGateway:
+		code = ErrCodeBadGateway
+	case status == http.StatusServiceUnavailable:
+		code = ErrCodeUnavailable
+	case status == http.StatusGatewayTimeout:
+		code = ErrCodeTimeout
+	case status >= 400 && status < 500:
+		code = ErrCodeInvalidPayload
+	}
+
+	return NewError(status, code, message)
+}
@@ -0,0 +1,187 @@
+package grpcutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain scopes the ErrorInfo reasons, which are contracts error codes.
+const errorDomain = "e-commerce"
+
+// Status converts err into a status error. A *contracts.Error keeps its
+// message, and its code, HTTP status and field messages travel as
+// ErrorInfo and BadRequest details. Status errors and context errors pass
+// through; anything else becomes a bare internal error so details such as
+// SQL messages never reach the client.
+func Status(err error) error {
+	return toStatus(err, 0)
+}
+
+// StatusRetryAfter is Status for errors the client may retry after d, such
+// as rate limiting; the delay travels as a RetryInfo detail.
+func StatusRetryAfter(err error, d time.Duration) error {
+	return toStatus(err, d)
+}
+
+func toStatus(err error, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := grpcstatus.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return grpcstatus.FromContextError(err).Err()
+	}
+
+	var apiErr *contracts.Error
+	if !errors.As(err, &apiErr) {
+		apiErr = contracts.ErrInternal()
+	}
+	httpStatus := apiErr.Status
+	if httpStatus == 0 {
+		httpStatus = http.StatusInternalServerError
+	}
+
+	st := grpcstatus.New(codeFor(httpStatus), apiErr.Message)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason:   string(apiErr.Code),
+		Domain:   errorDomain,
+		Metadata: map[string]string{"status": strconv.Itoa(httpStatus)},
+	}}
+	if len(apiErr.Fields) > 0 {
+		br := &errdetails.BadRequest{}
+		for field, message := range apiErr.Fields {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field, Description: message})
+		}
+		sort.Slice(br.FieldViolations, func(i, j int) bool { return br.FieldViolations[i].Field < br.FieldViolations[j].Field })
+		details = append(details, br)
+	}
+	if retryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	}
+
+	withDetails, detailsErr := st.WithDetails(details...)
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// Error recovers the contracts.Error a server returned through Status. It
+// reports false for failures the server did not answer with one, such as
+// an unreachable server or an expired deadline, which callers treat as
+// transport errors.
+func Error(err error) (*contracts.Error, bool) {
+	st, ok := grpcstatus.FromError(err)
+	if !ok || st.Code() == codes.OK {
+		return nil, false
+	}
+
+	var (
+		apiErr *contracts.Error
+		fields []*errdetails.BadRequest_FieldViolation
+	)
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if d.Domain != errorDomain {
+				continue
+			}
+			httpStatus, err := strconv.Atoi(d.Metadata["status"])
+			if err != nil {
+				httpStatus = statusFor(st.Code())
+			}
+			apiErr = contracts.NewError(httpStatus, contracts.ErrorCode(d.Reason), st.Message())
+		case *errdetails.BadRequest:
+			fields = d.FieldViolations
+		}
+	}
+	if apiErr == nil {
+		return nil, false
+	}
+	for _, v := range fields {
+		apiErr.WithField(v.Field, v.Description)
+	}
+	return apiErr, true
+}
+
+// RetryAfter returns the delay a server asked for with StatusRetryAfter,
+// or zero.
+func RetryAfter(err error) time.Duration {
+	st, ok := grpcstatus.FromError(err)
+	if !ok {
+		return 0
+	}
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*errdetails.RetryInfo); ok {
+			return d.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
+
+// codeFor picks the gRPC code closest to an HTTP status.
+func codeFor(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if httpStatus >= 400 && httpStatus < 500 {
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// statusFor is the inverse of codeFor, for ErrorInfo details that lost
+// their HTTP status.
+func statusFor(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,100 @@
+// Package grpcutil carries the conventions of the HTTP services over gRPC.
+// Trace context, the name of the calling service and the client address
+// travel in request metadata, deadlines travel as gRPC deadlines, and failures are
+// contracts.Error values encoded in the status, so a client gets the same
+// error codes whichever transport it uses.
+package grpcutil
+
+import (
+	"context"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataCaller is the metadata key naming the service that made a call.
+const MetadataCaller = "x-caller"
+
+type callerKey struct{}
+
+// Caller returns the service that made the call ctx belongs to, as the
+// client named itself. Calls from clients without UnaryClientInterceptor
+// have none.
+func Caller(ctx context.Context) (string, bool) {
+	caller, ok := ctx.Value(callerKey{}).(string)
+	return caller, ok
+}
+
+// ExtractTrace reads the caller's span from request metadata.
+func ExtractTrace(md metadata.MD) (trace.SpanContext, bool) {
+	sc, err := trace.Parse(first(md, trace.HeaderTraceparent))
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	sc.State = first(md, trace.HeaderTracestate)
+	return sc, true
+}
+
+// InjectTrace adds the current span of ctx to md, allocating md when it
+// is nil, and returns it.
+func InjectTrace(ctx context.Context, md metadata.MD) metadata.MD {
+	sc, ok := trace.FromContext(ctx)
+	if !ok {
+		return md
+	}
+	if md == nil {
+		md = metadata.MD{}
+	}
+	md.Set(trace.HeaderTraceparent, sc.Traceparent())
+	if sc.State != "" {
+		md.Set(trace.HeaderTracestate, sc.State)
+	}
+	return md
+}
+
+// UnaryClientInterceptor stamps every call with the current span, with
+// service as the caller and with the client address of ctx, if any. The
+// context's deadline needs no help: gRPC sends it along as the call's
+// timeout.
+func UnaryClientInterceptor(service string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = InjectTrace(ctx, md.Copy())
+		md.Set(MetadataCaller, service)
+		if ip, ok := clientip.FromContext(ctx); ok {
+			md.Set(clientip.Key, ip)
+		}
+		return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	}
+}
+
+// UnaryServerInterceptor starts a server span for every call, continuing
+// the caller's trace, and records the caller on the context. Errors the
+// handler returns are converted with Status, so handlers return
+// *contracts.Error as they would over HTTP.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		parent, _ := ExtractTrace(md)
+		ctx, _ = trace.Start(ctx, parent)
+		if caller := first(md, MetadataCaller); caller != "" {
+			ctx = context.WithValue(ctx, callerKey{}, caller)
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, Status(err)
+		}
+		return resp, nil
+	}
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package grpcutil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	authv1 "github.com/Flaviogonzalez/e-commerce/contracts/proto/auth/v1"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// recordingAuth remembers what the last call saw on its context.
+type recordingAuth struct {
+	authv1.UnimplementedAuthServiceServer
+	span     trace.SpanContext
+	caller   string
+	clientIP string
+	deadline time.Time
+	err      error
+}
+
+func (r *recordingAuth) GetUser(ctx context.Context, req *authv1.GetUserRequest) (*authv1.User, error) {
+	r.span, _ = trace.FromContext(ctx)
+	r.caller, _ = Caller(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.clientIP = first(md, clientip.Key)
+	r.deadline, _ = ctx.Deadline()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &authv1.User{Id: req.Id}, nil
+}
+
+func dial(t *testing.T, impl authv1.AuthServiceServer) authv1.AuthServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor()))
+	authv1.RegisterAuthServiceServer(srv, impl)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor("listener")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return authv1.NewAuthServiceClient(conn)
+}
+
+func TestMetadataAndDeadlinePropagate(t *testing.T) {
+	impl := &recordingAuth{}
+	client := dial(t, impl)
+
+	parent := trace.New()
+	parent.State = "vendor=1"
+	ctx, _ := trace.Start(context.Background(), parent)
+	sent, _ := trace.FromContext(ctx)
+	ctx = clientip.NewContext(ctx, "203.0.113.7")
+	deadline := time.Now().Add(5 * time.Second)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	if _, err := client.GetUser(ctx, &authv1.GetUserRequest{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if impl.span.TraceID != sent.TraceID || impl.span.SpanID == sent.SpanID {
+		t.Errorf("server span %+v does not continue client span %+v", impl.span, sent)
+	}
+	if impl.span.State != "vendor=1" {
+		t.Errorf("tracestate %q, want vendor=1", impl.span.State)
+	}
+	if impl.caller != "listener" {
+		t.Errorf("caller %q, want listener", impl.caller)
+	}
+	if impl.clientIP != "203.0.113.7" {
+		t.Errorf("client IP %q, want 203.0.113.7", impl.clientIP)
+	}
+	// The deadline travels as a relative timeout, so allow for clock drift
+	if d := impl.deadline.Sub(deadline).Abs(); impl.deadline.IsZero() || d > 100*time.Millisecond {
+		t.Errorf("server deadline %v, want %v", impl.deadline, deadline)
+	}
+}
+
+func TestErrorsRoundTrip(t *testing.T) {
+	impl := &recordingAuth{}
+	client := dial(t, impl)
+
+	impl.err = contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Invalid user ID format").WithField("id", "id must be a UUID")
+	_, err := client.GetUser(context.Background(), &authv1.GetUserRequest{Id: "x"})
+	apiErr, ok := Error(err)
+	if !ok {
+		t.Fatalf("no contracts error in %v", err)
+	}
+	if apiErr.Status != http.StatusBadRequest || apiErr.Code != contracts.ErrCodeValidation || apiErr.Fields["id"] != "id must be a UUID" {
+		t.Errorf("got %+v", apiErr)
+	}
+
+	impl.err = StatusRetryAfter(contracts.NewError(http.StatusTooManyRequests, contracts.ErrCodeRateLimited, "slow down"), 3*time.Second)
+	_, err = client.GetUser(context.Background(), &authv1.GetUserRequest{Id: "x"})
+	if apiErr, _ := Error(err); apiErr == nil || apiErr.Code != contracts.ErrCodeRateLimited {
+		t.Errorf("got %v, want rate_limited", err)
+	}
+	if d := RetryAfter(err); d != 3*time.Second {
+		t.Errorf("retry after %v, want 3s", d)
+	}
+
+	// Unexpected errors must not leak their message
+	impl.err = errString("pq: relation users does not exist")
+	_, err = client.GetUser(context.Background(), &authv1.GetUserRequest{Id: "x"})
+	if apiErr, _ := Error(err); apiErr == nil || apiErr.Code != contracts.ErrCodeInternal || apiErr.Message != "Internal server error" {
+		t.Errorf("got %v, want a bare internal error", err)
+	}
+}
+
+type errString string
+
+func (e errString) Error() string { return string(e) }
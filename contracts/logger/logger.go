@@ -9,7 +9,10 @@ import (
 	"time"
 
 	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -32,6 +35,11 @@ type Config struct {
 	BatchSize    int
 }
 
+var droppedEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "logger_dropped_entries_total",
+	Help: "Log entries dropped because the logger's buffer was full.",
+}, []string{"level"})
+
 var levelPriority = map[contracts.LogLevel]int{
 	contracts.LogDebug: 0,
 	contracts.LogInfo:  1,
@@ -109,10 +117,18 @@ func (l *Logger) send(entry contracts.LogEntry) {
 		return
 	}
 
-	_ = l.writer.WriteMessages(l.ctx, kafka.Message{
+	msg := kafka.Message{
 		Key:   []byte(entry.Service),
 		Value: data,
-	})
+	}
+	// The trace also travels as a header, so consumers can follow it
+	// without decoding the entry
+	span := trace.SpanContext{TraceID: entry.TraceID, SpanID: entry.SpanID, Flags: trace.FlagSampled}
+	if span.IsValid() {
+		msg.Headers = []kafka.Header{{Key: trace.HeaderTraceparent, Value: []byte(span.Traceparent())}}
+	}
+
+	_ = l.writer.WriteMessages(l.ctx, msg)
 }
 
 func (l *Logger) shouldLog(level contracts.LogLevel) bool {
@@ -139,7 +155,8 @@ func (l *Logger) log(level contracts.LogLevel, msg string, opts ...Option) {
 	select {
 	case l.buffer <- entry:
 	default:
-		// Buffer full, drop log (could emit metric here)
+		// Buffer full, drop log
+		droppedEntries.WithLabelValues(string(level)).Inc()
 	}
 }
 
@@ -215,6 +232,17 @@ func WithSpanID(spanID string) Option {
 	}
 }
 
+// WithContext records the current span of ctx, tying the entry to the
+// entries other services write for the same request.
+func WithContext(ctx context.Context) Option {
+	return func(e *contracts.LogEntry) {
+		if span, ok := trace.FromContext(ctx); ok {
+			e.TraceID = span.TraceID
+			e.SpanID = span.SpanID
+		}
+	}
+}
+
 func WithHTTP(method, path string, status int, duration time.Duration) Option {
 	return func(e *contracts.LogEntry) {
 		e.HTTPMethod = method
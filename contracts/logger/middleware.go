@@ -1,28 +1,28 @@
 package logger
 
 import (
+	"bufio"
+	"fmt"
+	"net"
 	"net/http"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
 )
 
-// Middleware creates an HTTP middleware for request logging
+// Middleware creates an HTTP middleware for request logging. It starts
+// the request's span (see trace.Middleware) unless an outer middleware
+// already did, so handlers can pass the context on to downstream calls.
 func (l *Logger) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return trace.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Generate trace ID if not present
-		traceID := r.Header.Get("X-Trace-ID")
-		if traceID == "" {
-			traceID = uuid.New().String()
-		}
-
 		// Wrap response writer to capture status
 		wrapped := &responseWriter{ResponseWriter: w, status: http.StatusOK}
 
 		// Add trace ID to response headers
-		w.Header().Set("X-Trace-ID", traceID)
+		span, _ := trace.FromContext(r.Context())
+		w.Header().Set("X-Trace-ID", span.TraceID)
 
 		next.ServeHTTP(wrapped, r)
 
@@ -33,10 +33,10 @@ func (l *Logger) Middleware(next http.Handler) http.Handler {
 			r.URL.Path,
 			wrapped.status,
 			duration,
-			WithTraceID(traceID),
+			WithContext(r.Context()),
 			WithUser("", r.RemoteAddr, r.UserAgent()),
 		)
-	})
+	}))
 }
 
 type responseWriter struct {
@@ -48,3 +48,20 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap exposes the underlying writer so http.ResponseController can
+// reach Flush and deadlines through the middleware, which streaming
+// handlers need.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades pass through the logging middleware.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
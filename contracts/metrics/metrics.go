@@ -0,0 +1,104 @@
+// Package metrics holds the Prometheus instrumentation shared by the
+// services: the HTTP request histogram and the /metrics endpoint.
+// Service-specific metrics live next to the code they measure.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is where every service serves its metrics.
+const Path = "/metrics"
+
+// unmatchedRoute labels requests no route matched, so scanners probing
+// random paths can't blow up the label cardinality.
+const unmatchedRoute = "unmatched"
+
+var httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "http_request_duration_seconds",
+	Help:    "Duration of HTTP requests by method, route pattern and status.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "route", "status"})
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records the duration of each request. route names the
+// matched route pattern; it is called after the handler ran, when routers
+// such as chi know the pattern, and may return "" for unmatched requests.
+func Middleware(route func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(sw, r)
+
+			pattern := route(r)
+			if pattern == "" {
+				pattern = unmatchedRoute
+			}
+			httpDuration.WithLabelValues(r.Method, pattern, strconv.Itoa(sw.status)).
+				Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// Serve exposes the metrics on addr for services that have no HTTP server
+// of their own. It returns once the listener is closed or fails.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler())
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Metrics server failed: %v", err)
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.status = code
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach Flush and deadlines, which
+// streaming handlers need.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades pass through.
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	sw.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
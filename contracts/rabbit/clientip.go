@@ -0,0 +1,28 @@
+package rabbit
+
+import (
+	"context"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ExtractClientIP reads the client address the publisher relayed.
+func ExtractClientIP(headers amqp.Table) (string, bool) {
+	ip, _ := headers[clientip.Key].(string)
+	return ip, ip != ""
+}
+
+// InjectClientIP adds the client address of ctx to message headers and
+// returns them, allocating the table when headers is nil.
+func InjectClientIP(ctx context.Context, headers amqp.Table) amqp.Table {
+	ip, ok := clientip.FromContext(ctx)
+	if !ok {
+		return headers
+	}
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[clientip.Key] = ip
+	return headers
+}
@@ -0,0 +1,36 @@
+package rabbit
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderDeadline carries the publisher's deadline, in Unix milliseconds,
+// so consumers stop working on requests nobody waits for anymore and can
+// pass the deadline on to the services they call.
+const HeaderDeadline = "x-deadline"
+
+// ExtractDeadline reads the publisher's deadline from message headers.
+func ExtractDeadline(headers amqp.Table) (time.Time, bool) {
+	ms, ok := headers[HeaderDeadline].(int64)
+	if !ok || ms <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// InjectDeadline adds the deadline of ctx to message headers and returns
+// them, allocating the table when headers is nil.
+func InjectDeadline(ctx context.Context, headers amqp.Table) amqp.Table {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return headers
+	}
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[HeaderDeadline] = deadline.UnixMilli()
+	return headers
+}
@@ -0,0 +1,595 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrNotConnected is returned while the connection is down and being
+// re-established. Callers should treat it as retriable.
+var ErrNotConnected = errors.New("rabbitmq: not connected")
+
+var ErrClosed = errors.New("rabbitmq: manager closed")
+
+var (
+	// ErrUnroutable is returned for a mandatory publish no queue is bound
+	// to receive. Retrying does not help until a consumer declares one.
+	ErrUnroutable = errors.New("rabbitmq: message unroutable")
+	// ErrNacked is returned when the broker kept refusing a publish.
+	ErrNacked = errors.New("rabbitmq: publish nacked")
+)
+
+// IsRetriable reports whether err was caused by a lost connection that the
+// manager is already recovering from, or by the broker refusing a publish
+// under load.
+func IsRetriable(err error) bool {
+	return errors.Is(err, ErrNotConnected) || errors.Is(err, amqp.ErrClosed) || errors.Is(err, ErrNacked)
+}
+
+// UnroutableError builds the error for a returned publish.
+func UnroutableError(ret amqp.Return) error {
+	return fmt.Errorf("%w: %s %q on exchange %q", ErrUnroutable, ret.ReplyText, ret.RoutingKey, ret.Exchange)
+}
+
+// RetryNacked runs publish until the broker acks it, at most attempts
+// times, backing off between tries. publish reports whether the broker
+// acked.
+func RetryNacked(ctx context.Context, attempts int, publish func() (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		acked, err := publish()
+		if err != nil {
+			return err
+		}
+		if acked {
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("%w after %d attempts", ErrNacked, attempt)
+		}
+
+		delay := nackBackoff << (attempt - 1)
+		select {
+		case <-time.After(delay/2 + rand.N(delay)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Declarer is the part of a channel that topologies use. *amqp.Channel
+// implements it.
+type Declarer interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// Topology declares exchanges, queues and bindings. Registered topologies
+// are re-applied on every reconnect, since a broker restart can drop
+// non-durable entities.
+type Topology func(ch Declarer) error
+
+type Config struct {
+	URL         string
+	MaxAttempts int           // initial dial attempts before giving up (default: 10)
+	MinBackoff  time.Duration // first reconnect delay (default: 500ms)
+	MaxBackoff  time.Duration // reconnect delay ceiling (default: 30s)
+	PoolSize    int           // idle channels kept for reuse (default: 8)
+
+	PublishAttempts int // tries for a nacked confirmed publish (default: 3)
+}
+
+const nackBackoff = 50 * time.Millisecond
+
+// Manager owns a single AMQP connection, reconnects it with jittered
+// backoff when it drops and hands out channels from a pool.
+type Manager struct {
+	cfg  Config
+	dial func(url string) (connection, error)
+
+	mu         sync.RWMutex
+	conn       connection
+	ready      chan struct{} // closed while connected
+	topologies []Topology
+	hooks      []func()
+	closed     bool
+
+	pool        chan channel
+	confirmPool chan *confirmChannel
+	done        chan struct{}
+}
+
+// confirmChannel is a pooled channel in confirm mode. Its returns are
+// buffered so a publisher can check for a return of its own message once
+// the confirm arrives: the broker sends basic.return before basic.ack.
+type confirmChannel struct {
+	ch      channel
+	returns chan amqp.Return
+}
+
+// connection, channel and confirmation are the parts of amqp091 the
+// manager uses, so that tests can stand in for the broker.
+type connection interface {
+	channel() (channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+type channel interface {
+	Declarer
+	Confirm(noWait bool) error
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	// publishMandatory publishes msg as mandatory on a channel in confirm
+	// mode.
+	publishMandatory(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error)
+	IsClosed() bool
+	Close() error
+}
+
+type confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+type amqpConnection struct{ *amqp.Connection }
+
+func (c amqpConnection) channel() (channel, error) {
+	ch, err := c.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return amqpChannel{ch}, nil
+}
+
+type amqpChannel struct{ *amqp.Channel }
+
+func (ch amqpChannel) publishMandatory(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error) {
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return nil, err
+	}
+	return confirm, nil
+}
+
+func dialAMQP(url string) (connection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return amqpConnection{conn}, nil
+}
+
+func Dial(cfg Config) (*Manager, error) {
+	return dial(cfg, dialAMQP)
+}
+
+func dial(cfg Config, dialer func(url string) (connection, error)) (*Manager, error) {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 8
+	}
+	if cfg.PublishAttempts <= 0 {
+		cfg.PublishAttempts = 3
+	}
+
+	m := &Manager{
+		cfg:   cfg,
+		dial:  dialer,
+		ready: make(chan struct{}),
+		pool:  make(chan channel, cfg.PoolSize),
+		done:  make(chan struct{}),
+
+		confirmPool: make(chan *confirmChannel, cfg.PoolSize),
+	}
+
+	var conn connection
+	var err error
+	for attempt := 1; ; attempt++ {
+		conn, err = m.dial(cfg.URL)
+		if err == nil {
+			break
+		}
+		if attempt >= cfg.MaxAttempts {
+			return nil, fmt.Errorf("failed to connect to RabbitMQ after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("RabbitMQ not ready, attempt %d, retrying...", attempt)
+		time.Sleep(m.backoff(attempt))
+	}
+
+	log.Println("Connected to RabbitMQ")
+	notify := notifyClose(conn)
+	m.setConnection(conn)
+	go m.watch(notify)
+
+	return m, nil
+}
+
+// DeclareTopology applies t now and again after every reconnect.
+func (m *Manager) DeclareTopology(t Topology) error {
+	if err := m.apply(t); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.topologies = append(m.topologies, t)
+	m.mu.Unlock()
+	return nil
+}
+
+// OnReconnect registers fn to run after the connection has been restored
+// and all topologies re-declared.
+func (m *Manager) OnReconnect(fn func()) {
+	m.mu.Lock()
+	m.hooks = append(m.hooks, fn)
+	m.mu.Unlock()
+}
+
+// Connected reports whether the connection is currently up.
+func (m *Manager) Connected() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.conn != nil
+}
+
+// WaitConnected blocks until the connection is up, ctx ends or the
+// manager is closed.
+func (m *Manager) WaitConnected(ctx context.Context) error {
+	m.mu.RLock()
+	ready := m.ready
+	m.mu.RUnlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-m.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Channel opens a dedicated channel the caller owns and must close. Use it
+// for consumers, whose deliveries are tied to the channel.
+func (m *Manager) Channel() (*amqp.Channel, error) {
+	ch, err := m.open()
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := ch.(amqpChannel)
+	if !ok {
+		ch.Close()
+		return nil, errors.New("rabbitmq: not an AMQP channel")
+	}
+	return raw.Channel, nil
+}
+
+// open opens a channel on the current connection, failing fast while it is
+// down.
+func (m *Manager) open() (channel, error) {
+	m.mu.RLock()
+	conn := m.conn
+	closed := m.closed
+	m.mu.RUnlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := conn.channel()
+	if err != nil {
+		return nil, fmt.Errorf("%w: open channel: %v", ErrNotConnected, err)
+	}
+	return ch, nil
+}
+
+// acquire takes a channel from the pool, opening a new one when the pool is
+// empty. Return it with release.
+func (m *Manager) acquire() (channel, error) {
+	for {
+		select {
+		case ch := <-m.pool:
+			if !ch.IsClosed() {
+				return ch, nil
+			}
+		default:
+			return m.open()
+		}
+	}
+}
+
+// release returns ch to the pool, or closes it when the pool is full or the
+// channel has failed.
+func (m *Manager) release(ch channel) {
+	if ch == nil || ch.IsClosed() {
+		return
+	}
+
+	select {
+	case m.pool <- ch:
+	default:
+		ch.Close()
+	}
+}
+
+// Publish sends msg on a pooled channel.
+func (m *Manager) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	ch, err := m.acquire()
+	if err != nil {
+		return err
+	}
+	defer m.release(ch)
+
+	if err := ch.PublishWithContext(ctx, exchange, key, false, false, msg); err != nil {
+		if ch.IsClosed() {
+			return fmt.Errorf("%w: %v", ErrNotConnected, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// PublishConfirmed sends msg as mandatory on a pooled channel in confirm
+// mode and waits for the broker to take responsibility for it. Nacked
+// publishes are retried up to Config.PublishAttempts times; a message no
+// queue is bound for fails with ErrUnroutable.
+func (m *Manager) PublishConfirmed(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	cc, err := m.acquireConfirm()
+	if err != nil {
+		return err
+	}
+	defer m.releaseConfirm(cc)
+
+	err = RetryNacked(ctx, m.cfg.PublishAttempts, func() (bool, error) {
+		// Only the last attempt's return counts
+		select {
+		case <-cc.returns:
+		default:
+		}
+
+		confirm, err := cc.ch.publishMandatory(ctx, exchange, key, msg)
+		if err != nil {
+			if cc.ch.IsClosed() {
+				return false, fmt.Errorf("%w: %v", ErrNotConnected, err)
+			}
+			return false, err
+		}
+
+		acked, err := confirm.WaitContext(ctx)
+		if err != nil {
+			// The confirm may still arrive; the channel cannot be reused
+			// without mistaking it for the next publisher's
+			cc.ch.Close()
+			return false, err
+		}
+		if !acked && cc.ch.IsClosed() {
+			return false, fmt.Errorf("%w: channel closed before confirm", ErrNotConnected)
+		}
+		return acked, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case ret := <-cc.returns:
+		return UnroutableError(ret)
+	default:
+		return nil
+	}
+}
+
+func (m *Manager) acquireConfirm() (*confirmChannel, error) {
+	for {
+		select {
+		case cc := <-m.confirmPool:
+			if !cc.ch.IsClosed() {
+				return cc, nil
+			}
+		default:
+			ch, err := m.open()
+			if err != nil {
+				return nil, err
+			}
+			if err := ch.Confirm(false); err != nil {
+				ch.Close()
+				return nil, fmt.Errorf("enable publisher confirms: %w", err)
+			}
+			return &confirmChannel{
+				ch:      ch,
+				returns: ch.NotifyReturn(make(chan amqp.Return, 1)),
+			}, nil
+		}
+	}
+}
+
+func (m *Manager) releaseConfirm(cc *confirmChannel) {
+	if cc.ch.IsClosed() {
+		return
+	}
+
+	select {
+	case m.confirmPool <- cc:
+	default:
+		cc.ch.Close()
+	}
+}
+
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	conn := m.conn
+	m.conn = nil
+	m.ready = make(chan struct{}) // never closed, so waiters see done
+	m.mu.Unlock()
+
+	close(m.done)
+	m.drainPool()
+
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// notifyClose tells when conn closes. Call it right after dialing: a
+// connection that is already closed closes the channel without an error,
+// which reads as a deliberate close rather than a drop.
+func notifyClose(conn connection) chan *amqp.Error {
+	return conn.NotifyClose(make(chan *amqp.Error, 1))
+}
+
+// watch waits for the connection notify belongs to to close and reconnects
+// until it succeeds or the manager is closed.
+func (m *Manager) watch(notify chan *amqp.Error) {
+	for {
+		select {
+		case amqpErr := <-notify:
+			if amqpErr == nil {
+				// Closed on purpose
+				return
+			}
+			log.Printf("RabbitMQ connection lost: %v", amqpErr)
+		case <-m.done:
+			return
+		}
+
+		m.setConnection(nil)
+		m.drainPool()
+
+		next, ok := m.reconnect()
+		if !ok {
+			return
+		}
+		notify = next
+	}
+}
+
+// reconnect dials until a connection with every topology declared is up and
+// returns its close notifications.
+func (m *Manager) reconnect() (chan *amqp.Error, bool) {
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(m.backoff(attempt)):
+		case <-m.done:
+			return nil, false
+		}
+
+		conn, err := m.dial(m.cfg.URL)
+		if err != nil {
+			log.Printf("RabbitMQ reconnect attempt %d failed: %v", attempt, err)
+			continue
+		}
+		notify := notifyClose(conn)
+
+		if err := m.redeclare(conn); err != nil {
+			log.Printf("RabbitMQ reconnect attempt %d: redeclare topology: %v", attempt, err)
+			conn.Close()
+			continue
+		}
+
+		log.Printf("Reconnected to RabbitMQ after %d attempts", attempt)
+		m.setConnection(conn)
+
+		m.mu.RLock()
+		hooks := append([]func(){}, m.hooks...)
+		m.mu.RUnlock()
+		for _, hook := range hooks {
+			hook()
+		}
+
+		return notify, true
+	}
+}
+
+func (m *Manager) redeclare(conn connection) error {
+	m.mu.RLock()
+	topologies := append([]Topology{}, m.topologies...)
+	m.mu.RUnlock()
+
+	ch, err := conn.channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, t := range topologies {
+		if err := t(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) apply(t Topology) error {
+	ch, err := m.open()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return t(ch)
+}
+
+func (m *Manager) setConnection(conn connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		if conn != nil {
+			conn.Close()
+		}
+		return
+	}
+
+	m.conn = conn
+	if conn != nil {
+		close(m.ready)
+	} else {
+		m.ready = make(chan struct{})
+	}
+}
+
+func (m *Manager) drainPool() {
+	for {
+		select {
+		case ch := <-m.pool:
+			ch.Close()
+		case cc := <-m.confirmPool:
+			cc.ch.Close()
+		default:
+			return
+		}
+	}
+}
+
+// backoff returns a full-jitter exponential delay for the given attempt.
+func (m *Manager) backoff(attempt int) time.Duration {
+	ceiling := m.cfg.MinBackoff << min(attempt-1, 16)
+	if ceiling <= 0 || ceiling > m.cfg.MaxBackoff {
+		ceiling = m.cfg.MaxBackoff
+	}
+	return m.cfg.MinBackoff/2 + rand.N(ceiling)
+}
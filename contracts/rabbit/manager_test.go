@@ -0,0 +1,492 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeBroker stands in for RabbitMQ. It refuses dials while down, nacks
+// the first nacks confirmed publishes and returns mandatory publishes
+// while unroutable.
+type fakeBroker struct {
+	mu         sync.Mutex
+	down       bool
+	dials      int
+	conns      []*fakeConn
+	declared   []string
+	nacks      int
+	unroutable bool
+	published  int
+}
+
+func (b *fakeBroker) dial(string) (connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dials++
+	if b.down {
+		return nil, errors.New("connection refused")
+	}
+	conn := &fakeConn{broker: b}
+	b.conns = append(b.conns, conn)
+	return conn, nil
+}
+
+func (b *fakeBroker) setDown(down bool) {
+	b.mu.Lock()
+	b.down = down
+	b.mu.Unlock()
+}
+
+// drop kills the newest connection as a broker restart would.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	conn := b.conns[len(b.conns)-1]
+	b.mu.Unlock()
+	conn.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "broker restart"})
+}
+
+func (b *fakeBroker) stats() (dials, conns int, declared []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dials, len(b.conns), append([]string{}, b.declared...)
+}
+
+type fakeConn struct {
+	broker *fakeBroker
+
+	mu       sync.Mutex
+	closed   bool
+	notify   []chan *amqp.Error
+	channels []*fakeChannel
+}
+
+func (c *fakeConn) channel() (channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &fakeChannel{broker: c.broker}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakeConn) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *fakeConn) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+// shutdown closes the connection and its channels, telling listeners why:
+// amqp091 sends nothing on a deliberate close.
+func (c *fakeConn) shutdown(reason *amqp.Error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, ch := range c.channels {
+		ch.Close()
+	}
+	for _, receiver := range c.notify {
+		if reason != nil {
+			receiver <- reason
+		}
+		close(receiver)
+	}
+}
+
+func (c *fakeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConn) opened() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels)
+}
+
+type fakeChannel struct {
+	broker *fakeBroker
+
+	mu      sync.Mutex
+	closed  bool
+	returns chan amqp.Return
+}
+
+func (ch *fakeChannel) declare(what string) error {
+	if ch.IsClosed() {
+		return amqp.ErrClosed
+	}
+	ch.broker.mu.Lock()
+	ch.broker.declared = append(ch.broker.declared, what)
+	ch.broker.mu.Unlock()
+	return nil
+}
+
+func (ch *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return ch.declare("exchange " + name)
+}
+
+func (ch *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, ch.declare("queue " + name)
+}
+
+func (ch *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return ch.declare("binding " + name + " " + key)
+}
+
+func (ch *fakeChannel) Confirm(bool) error { return nil }
+
+func (ch *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.mu.Lock()
+	ch.returns = c
+	ch.mu.Unlock()
+	return c
+}
+
+func (ch *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if ch.IsClosed() {
+		return amqp.ErrClosed
+	}
+	ch.broker.mu.Lock()
+	ch.broker.published++
+	ch.broker.mu.Unlock()
+	return nil
+}
+
+func (ch *fakeChannel) publishMandatory(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error) {
+	if err := ch.PublishWithContext(ctx, exchange, key, true, false, msg); err != nil {
+		return nil, err
+	}
+
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.unroutable {
+		ch.returns <- amqp.Return{ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key}
+	}
+	if b.nacks > 0 {
+		b.nacks--
+		return fakeConfirmation(false), nil
+	}
+	return fakeConfirmation(true), nil
+}
+
+func (ch *fakeChannel) IsClosed() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.closed
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.mu.Lock()
+	ch.closed = true
+	ch.mu.Unlock()
+	return nil
+}
+
+type fakeConfirmation bool
+
+func (c fakeConfirmation) WaitContext(context.Context) (bool, error) {
+	return bool(c), nil
+}
+
+func newTestManager(t *testing.T, b *fakeBroker) *Manager {
+	t.Helper()
+
+	m, err := dial(Config{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+	}, b.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// eventually polls cond until it holds or a second has passed.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func exchangeTopology(name string) Topology {
+	return func(ch Declarer) error {
+		return ch.ExchangeDeclare(name, "topic", true, false, false, false, nil)
+	}
+}
+
+func TestDialRetries(t *testing.T) {
+	b := &fakeBroker{down: true}
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		b.setDown(false)
+	}()
+
+	m, err := dial(Config{MaxAttempts: 1000, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, b.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if dials, _, _ := b.stats(); dials < 2 || !m.Connected() {
+		t.Errorf("connected %v after %d dials, want a retry", m.Connected(), dials)
+	}
+}
+
+func TestDialGivesUp(t *testing.T) {
+	b := &fakeBroker{down: true}
+	_, err := dial(Config{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, b.dial)
+	if dials, _, _ := b.stats(); err == nil || dials != 3 {
+		t.Errorf("err %v after %d dials, want failure after 3", err, dials)
+	}
+}
+
+func TestReconnectRedeclaresTopology(t *testing.T) {
+	b := &fakeBroker{}
+	m := newTestManager(t, b)
+
+	if err := m.DeclareTopology(exchangeTopology("events")); err != nil {
+		t.Fatal(err)
+	}
+	reconnected := make(chan struct{}, 1)
+	m.OnReconnect(func() { reconnected <- struct{}{} })
+
+	// Fill the pool with a channel of the first connection
+	if err := m.Publish(context.Background(), "events", "a", amqp.Publishing{}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.setDown(true)
+	b.drop()
+	eventually(t, "the drop is noticed", func() bool { return !m.Connected() })
+
+	// Callers fail fast while the connection is down
+	err := m.Publish(context.Background(), "events", "a", amqp.Publishing{})
+	if !errors.Is(err, ErrNotConnected) || !IsRetriable(err) {
+		t.Errorf("publish while down: %v, want a retriable ErrNotConnected", err)
+	}
+	if _, err := m.Channel(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("channel while down: %v, want ErrNotConnected", err)
+	}
+
+	b.setDown(false)
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("hooks did not run after reconnecting")
+	}
+
+	_, conns, declared := b.stats()
+	if conns != 2 || len(declared) != 2 || declared[1] != "exchange events" {
+		t.Errorf("%d connections declared %v, want the exchange declared again on the second", conns, declared)
+	}
+	if err := m.WaitConnected(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Publish(context.Background(), "events", "a", amqp.Publishing{}); err != nil {
+		t.Errorf("publish after reconnecting: %v", err)
+	}
+}
+
+func TestReconnectRetriesFailedRedeclare(t *testing.T) {
+	b := &fakeBroker{}
+	m := newTestManager(t, b)
+
+	var mu sync.Mutex
+	failures := 0
+	err := m.DeclareTopology(func(ch Declarer) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, conns, _ := b.stats(); conns > 1 && failures < 2 {
+			failures++
+			return errors.New("precondition failed")
+		}
+		return exchangeTopology("events")(ch)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconnected := make(chan struct{}, 1)
+	m.OnReconnect(func() { reconnected <- struct{}{} })
+
+	b.drop()
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("hooks did not run after reconnecting")
+	}
+
+	// Connections whose topology failed are closed and not used
+	_, conns, _ := b.stats()
+	if conns != 4 {
+		t.Errorf("%d connections, want the first, two failed and the last", conns)
+	}
+	for i, conn := range b.conns[:3] {
+		if !conn.isClosed() {
+			t.Errorf("connection %d left open", i)
+		}
+	}
+}
+
+func TestPoolReusesChannels(t *testing.T) {
+	b := &fakeBroker{}
+	m := newTestManager(t, b)
+	conn := b.conns[0]
+
+	for range 3 {
+		if err := m.Publish(context.Background(), "events", "a", amqp.Publishing{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conn.opened(); n != 1 {
+		t.Errorf("%d channels opened for sequential publishes, want 1", n)
+	}
+
+	// A pooled channel that failed is replaced
+	conn.channels[0].Close()
+	if err := m.Publish(context.Background(), "events", "a", amqp.Publishing{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.opened(); n != 2 {
+		t.Errorf("%d channels opened, want a new one for the closed channel", n)
+	}
+}
+
+func TestPublishConfirmed(t *testing.T) {
+	tests := []struct {
+		name       string
+		nacks      int
+		unroutable bool
+		want       error
+		publishes  int
+	}{
+		{"acked", 0, false, nil, 1},
+		{"nacked once", 1, false, nil, 2},
+		{"always nacked", 10, false, ErrNacked, 3},
+		{"unroutable", 0, true, ErrUnroutable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &fakeBroker{nacks: tt.nacks, unroutable: tt.unroutable}
+			m := newTestManager(t, b)
+
+			err := m.PublishConfirmed(context.Background(), "events", "a", amqp.Publishing{})
+			if !errors.Is(err, tt.want) || (tt.want == nil) != (err == nil) {
+				t.Errorf("err %v, want %v", err, tt.want)
+			}
+			if b.published != tt.publishes {
+				t.Errorf("%d publishes, want %d", b.published, tt.publishes)
+			}
+		})
+	}
+}
+
+func TestPublishConfirmedClearsStaleReturns(t *testing.T) {
+	b := &fakeBroker{unroutable: true}
+	m := newTestManager(t, b)
+
+	if err := m.PublishConfirmed(context.Background(), "events", "a", amqp.Publishing{}); !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("err %v, want ErrUnroutable", err)
+	}
+
+	// The next publisher on the pooled channel must not see that return
+	b.unroutable = false
+	if err := m.PublishConfirmed(context.Background(), "events", "a", amqp.Publishing{}); err != nil {
+		t.Errorf("routable publish: %v", err)
+	}
+	if n := b.conns[0].opened(); n != 1 {
+		t.Errorf("%d channels opened, want the confirm channel reused", n)
+	}
+}
+
+func TestClosedManagerFailsFast(t *testing.T) {
+	b := &fakeBroker{}
+	m := newTestManager(t, b)
+	m.Close()
+
+	if err := m.Publish(context.Background(), "events", "a", amqp.Publishing{}); !errors.Is(err, ErrClosed) || IsRetriable(err) {
+		t.Errorf("publish after close: %v, want ErrClosed, not retriable", err)
+	}
+	if err := m.PublishConfirmed(context.Background(), "events", "a", amqp.Publishing{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("confirmed publish after close: %v, want ErrClosed", err)
+	}
+	if err := m.WaitConnected(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("wait after close: %v, want ErrClosed", err)
+	}
+	if !b.conns[0].isClosed() {
+		t.Error("connection left open")
+	}
+
+	// A deliberate close is not a drop to recover from
+	time.Sleep(10 * time.Millisecond)
+	if dials, _, _ := b.stats(); dials != 1 {
+		t.Errorf("%d dials, want no reconnect after close", dials)
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{ErrNotConnected, true},
+		{amqp.ErrClosed, true},
+		{ErrNacked, true},
+		{UnroutableError(amqp.Return{}), false},
+		{ErrClosed, false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		if got := IsRetriable(tt.err); got != tt.want {
+			t.Errorf("IsRetriable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffIsJitteredAndCapped(t *testing.T) {
+	m := &Manager{cfg: Config{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+
+	for attempt := 1; attempt <= 40; attempt++ {
+		ceiling := min(m.cfg.MinBackoff<<min(attempt-1, 16), m.cfg.MaxBackoff)
+		for range 20 {
+			d := m.backoff(attempt)
+			if d < m.cfg.MinBackoff/2 || d >= m.cfg.MinBackoff/2+ceiling {
+				t.Fatalf("attempt %d: backoff %v outside [%v, %v)", attempt, d, m.cfg.MinBackoff/2, m.cfg.MinBackoff/2+ceiling)
+			}
+		}
+	}
+}
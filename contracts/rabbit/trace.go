@@ -0,0 +1,36 @@
+package rabbit
+
+import (
+	"context"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ExtractTrace reads the publisher's span from message headers.
+func ExtractTrace(headers amqp.Table) (trace.SpanContext, bool) {
+	v, _ := headers[trace.HeaderTraceparent].(string)
+	sc, err := trace.Parse(v)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	sc.State, _ = headers[trace.HeaderTracestate].(string)
+	return sc, true
+}
+
+// InjectTrace adds the current span of ctx to message headers and returns
+// them, allocating the table when headers is nil.
+func InjectTrace(ctx context.Context, headers amqp.Table) amqp.Table {
+	sc, ok := trace.FromContext(ctx)
+	if !ok {
+		return headers
+	}
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[trace.HeaderTraceparent] = sc.Traceparent()
+	if sc.State != "" {
+		headers[trace.HeaderTracestate] = sc.State
+	}
+	return headers
+}
@@ -0,0 +1,53 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Extract reads the caller's span from HTTP headers.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, err := Parse(h.Get(HeaderTraceparent))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.State = h.Get(HeaderTracestate)
+	return sc, true
+}
+
+// Inject writes the current span of ctx into HTTP headers, making it the
+// parent of whatever the receiver does.
+func Inject(ctx context.Context, h http.Header) {
+	sc, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+	h.Set(HeaderTraceparent, sc.Traceparent())
+	if sc.State != "" {
+		h.Set(HeaderTracestate, sc.State)
+	}
+}
+
+// Middleware starts a server span for each request, continuing the
+// caller's trace when it sent a traceparent. Clients that only know the
+// older X-Trace-ID header keep their trace ID if it is a UUID or 32 hex
+// digits. Requests that already carry a span pass through unchanged.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := FromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		parent, ok := Extract(r.Header)
+		if !ok {
+			if id := strings.ToLower(strings.ReplaceAll(r.Header.Get("X-Trace-ID"), "-", "")); validID(id, 32) {
+				parent = SpanContext{TraceID: id, SpanID: newID(8), Flags: FlagSampled}
+			}
+		}
+
+		ctx, _ := Start(r.Context(), parent)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serve runs a request with header through Middleware and returns the span
+// the handler saw.
+func serve(t *testing.T, header http.Header) SpanContext {
+	t.Helper()
+
+	var sc SpanContext
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		if sc, ok = FromContext(r.Context()); !ok {
+			t.Error("no span in the request context")
+		}
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	return sc
+}
+
+func TestMiddlewareContinuesTraceparent(t *testing.T) {
+	sc := serve(t, http.Header{
+		"Traceparent": {"00-" + traceID + "-" + spanID + "-00"},
+		"Tracestate":  {"k=v"},
+		"X-Trace-Id":  {"11111111-2222-3333-4444-555555555555"},
+	})
+	if sc.TraceID != traceID || sc.SpanID == spanID || sc.Flags != 0 || sc.State != "k=v" {
+		t.Errorf("span %+v, want a child of the traceparent", sc)
+	}
+}
+
+func TestMiddlewareLegacyTraceID(t *testing.T) {
+	tests := []struct {
+		name, header, want string // want is empty when a new trace starts
+	}{
+		{"UUID", "4BF92F35-77B3-4DA6-A3CE-929D0E0E4736", traceID},
+		{"hex", traceID, traceID},
+		{"uppercase hex", "4BF92F3577B34DA6A3CE929D0E0E4736", traceID},
+		{"all zero", "00000000-0000-0000-0000-000000000000", ""},
+		{"too short", traceID[1:], ""},
+		{"not hex", "not-a-trace-id", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := serve(t, http.Header{"X-Trace-Id": {tt.header}})
+			if !sc.IsValid() {
+				t.Fatalf("invalid span %+v", sc)
+			}
+			if tt.want != "" && sc.TraceID != tt.want {
+				t.Errorf("trace ID %s, want %s", sc.TraceID, tt.want)
+			}
+			if tt.want == "" && sc.TraceID == traceID {
+				t.Errorf("trace ID %s kept", sc.TraceID)
+			}
+			if sc.Flags != FlagSampled {
+				t.Errorf("flags %02x, want sampled", sc.Flags)
+			}
+		})
+	}
+}
+
+func TestMiddlewareKeepsInvalidTraceparentOut(t *testing.T) {
+	// A broken traceparent is ignored, not mixed with X-Trace-ID's
+	sc := serve(t, http.Header{
+		"Traceparent": {"00-" + traceID + "-" + spanID},
+		"X-Trace-Id":  {traceID},
+	})
+	if !sc.IsValid() || sc.TraceID != traceID {
+		t.Errorf("span %+v, want the legacy trace ID", sc)
+	}
+}
+
+func TestMiddlewareKeepsExistingSpan(t *testing.T) {
+	parent := SpanContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled}
+	var got SpanContext
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(HeaderTraceparent, New().Traceparent())
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(NewContext(r.Context(), parent)))
+	if got != parent {
+		t.Errorf("span %+v, want %+v", got, parent)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	sc := SpanContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled, State: "k=v"}
+	h := http.Header{}
+	Inject(NewContext(t.Context(), sc), h)
+
+	got, ok := Extract(h)
+	if !ok || got != sc {
+		t.Errorf("Extract = %+v, %v; want %+v", got, ok, sc)
+	}
+
+	h = http.Header{}
+	Inject(t.Context(), h)
+	if len(h) != 0 {
+		t.Errorf("headers %v injected without a span", h)
+	}
+}
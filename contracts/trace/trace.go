@@ -0,0 +1,135 @@
+// Package trace propagates W3C trace context (the traceparent header)
+// across HTTP, AMQP and Kafka, so the log entries every service writes
+// while handling one user request share a trace ID.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+)
+
+// FlagSampled is the trace-flags bit recording that the caller samples the
+// trace.
+const FlagSampled byte = 0x01
+
+var ErrInvalid = errors.New("trace: invalid traceparent")
+
+// SpanContext identifies one span of a trace.
+type SpanContext struct {
+	TraceID string // 32 lowercase hex digits
+	SpanID  string // 16 lowercase hex digits
+	Flags   byte
+	State   string // tracestate, passed through untouched
+}
+
+// IsValid reports whether sc has non-zero trace and span IDs.
+func (sc SpanContext) IsValid() bool {
+	return validID(sc.TraceID, 32) && validID(sc.SpanID, 16)
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID + "-" + sc.SpanID + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// Child returns a new span in the same trace, with sc as its parent.
+func (sc SpanContext) Child() SpanContext {
+	sc.SpanID = newID(8)
+	return sc
+}
+
+// New starts a new, sampled trace.
+func New() SpanContext {
+	return SpanContext{TraceID: newID(16), SpanID: newID(8), Flags: FlagSampled}
+}
+
+// Parse decodes a traceparent header value. Versions above 00 are
+// accepted as long as they start with the version 00 fields, as the spec
+// asks of parsers.
+func Parse(traceparent string) (SpanContext, error) {
+	v := strings.TrimSpace(traceparent)
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return SpanContext{}, ErrInvalid
+	}
+
+	version := v[:2]
+	if !isHex(version) || version == "ff" {
+		return SpanContext{}, ErrInvalid
+	}
+	if version == "00" && len(v) != 55 {
+		return SpanContext{}, ErrInvalid
+	}
+	if len(v) > 55 && v[55] != '-' {
+		return SpanContext{}, ErrInvalid
+	}
+
+	flags, err := hex.DecodeString(v[53:55])
+	if err != nil || !isHex(v[53:55]) {
+		return SpanContext{}, ErrInvalid
+	}
+
+	sc := SpanContext{TraceID: v[3:35], SpanID: v[36:52], Flags: flags[0]}
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalid
+	}
+	return sc, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying sc as the current span.
+func NewContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext returns the current span of ctx.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Start begins a span as a child of parent, or of a new trace when parent
+// is not valid, and makes it the current span of the returned context.
+func Start(ctx context.Context, parent SpanContext) (context.Context, SpanContext) {
+	sc := New()
+	if parent.IsValid() {
+		sc = parent.Child()
+	}
+	return NewContext(ctx, sc), sc
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	for {
+		// crypto/rand.Read never fails
+		rand.Read(b)
+		for _, c := range b {
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+// validID checks for n lowercase hex digits, not all zero.
+func validID(id string, n int) bool {
+	return len(id) == n && isHex(id) && strings.Trim(id, "0") != ""
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
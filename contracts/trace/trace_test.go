@@ -0,0 +1,88 @@
+package trace
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     SpanContext // zero when in is invalid
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", SpanContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled}},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", SpanContext{TraceID: traceID, SpanID: spanID}},
+		{"surrounding space", " 00-" + traceID + "-" + spanID + "-01\t", SpanContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled}},
+		{"unknown flags kept", "00-" + traceID + "-" + spanID + "-09", SpanContext{TraceID: traceID, SpanID: spanID, Flags: 0x09}},
+
+		// Later versions may append fields after the version 00 ones
+		{"future version", "01-" + traceID + "-" + spanID + "-01", SpanContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled}},
+		{"future version with extra fields", "cc-" + traceID + "-" + spanID + "-01-what-the-future-holds", SpanContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled}},
+		{"future version, extra fields not dash separated", "cc-" + traceID + "-" + spanID + "-01.x", SpanContext{}},
+		{"version 00 with extra fields", "00-" + traceID + "-" + spanID + "-01-extra", SpanContext{}},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", SpanContext{}},
+		{"version not hex", "0g-" + traceID + "-" + spanID + "-01", SpanContext{}},
+
+		{"uppercase trace ID", "00-" + strings.ToUpper(traceID) + "-" + spanID + "-01", SpanContext{}},
+		{"uppercase span ID", "00-" + traceID + "-" + strings.ToUpper(spanID) + "-01", SpanContext{}},
+		{"uppercase version", "0A-" + traceID + "-" + spanID + "-01", SpanContext{}},
+		{"uppercase flags", "00-" + traceID + "-" + spanID + "-0A", SpanContext{}},
+		{"zero trace ID", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", SpanContext{}},
+		{"zero span ID", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", SpanContext{}},
+		{"flags not hex", "00-" + traceID + "-" + spanID + "-0x", SpanContext{}},
+
+		{"empty", "", SpanContext{}},
+		{"short trace ID", "00-" + traceID[1:] + "-" + spanID + "-01", SpanContext{}},
+		{"long trace ID", "00-" + traceID + "0-" + spanID + "-01", SpanContext{}},
+		{"short span ID", "00-" + traceID + "-" + spanID[1:] + "-01", SpanContext{}},
+		{"long span ID", "00-" + traceID + "-" + spanID + "0-01", SpanContext{}},
+		{"short flags", "00-" + traceID + "-" + spanID + "-1", SpanContext{}},
+		{"long flags", "00-" + traceID + "-" + spanID + "-001", SpanContext{}},
+		{"wrong separators", "00_" + traceID + "_" + spanID + "_01", SpanContext{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.want == (SpanContext{}) {
+				if err != ErrInvalid {
+					t.Errorf("Parse(%q) = %+v, %v; want ErrInvalid", tt.in, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Parse(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	sc := New()
+	got, err := Parse(sc.Traceparent())
+	if err != nil || got != sc {
+		t.Errorf("Parse(%q) = %+v, %v; want %+v", sc.Traceparent(), got, err, sc)
+	}
+}
+
+func TestStart(t *testing.T) {
+	parent := SpanContext{TraceID: traceID, SpanID: spanID, Flags: FlagSampled, State: "k=v"}
+
+	ctx, sc := Start(context.Background(), parent)
+	if sc.TraceID != traceID || sc.SpanID == spanID || !sc.IsValid() || sc.State != "k=v" {
+		t.Errorf("child of %+v: %+v", parent, sc)
+	}
+	if cur, ok := FromContext(ctx); !ok || cur != sc {
+		t.Errorf("current span %+v, want %+v", cur, sc)
+	}
+
+	// An invalid parent starts a new trace
+	if _, sc := Start(context.Background(), SpanContext{}); !sc.IsValid() || sc.TraceID == traceID {
+		t.Errorf("new trace: %+v", sc)
+	}
+}
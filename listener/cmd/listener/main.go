@@ -3,7 +3,13 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts/logger"
+	"github.com/Flaviogonzalez/e-commerce/contracts/metrics"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
 	"github.com/Flaviogonzalez/e-commerce/listener/internal/event"
 	"github.com/Flaviogonzalez/e-commerce/listener/internal/handlers"
 )
@@ -26,25 +32,104 @@ func main() {
 		authURL = "http://auth:8080"
 	}
 
-	// Connect to RabbitMQ
-	conn, err := event.ConnectToRabbit(rabbitURL)
+	// AUTH_TRANSPORT picks how handlers reach auth: "http" (default) or
+	// "grpc", which dials AUTH_GRPC_ADDR
+	authTransport := os.Getenv("AUTH_TRANSPORT")
+	if authTransport == "" {
+		authTransport = "http"
+	}
+
+	authGRPCAddr := os.Getenv("AUTH_GRPC_ADDR")
+	if authGRPCAddr == "" {
+		authGRPCAddr = "auth:9090"
+	}
+
+	// Failing events are retried MAX_RETRIES times, after RETRY_DELAY and
+	// then delays four times longer each, before they are parked. Each delay
+	// has its own queues, so changing it leaves the old ones to drain
+	maxRetries := 0
+	if v := os.Getenv("MAX_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("Invalid MAX_RETRIES:", err)
+		}
+		maxRetries = n
+	}
+
+	var retryDelay time.Duration
+	if v := os.Getenv("RETRY_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("Invalid RETRY_DELAY:", err)
+		}
+		retryDelay = d
+	}
+
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":8080"
+	}
+
+	kafkaBrokers := os.Getenv("KAFKA_BROKERS")
+	if kafkaBrokers == "" {
+		kafkaBrokers = "kafka:9092"
+	}
+
+	// Initialize logger
+	appLogger, err := logger.New(logger.Config{
+		Service:      "listener",
+		KafkaBrokers: strings.Split(kafkaBrokers, ","),
+		Topic:        "logs",
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to initialize logger: %v", err)
+	} else {
+		defer appLogger.Close()
+	}
+
+	// Connect to RabbitMQ; the manager reconnects on its own if the
+	// connection drops later
+	rabbitManager, err := rabbit.Dial(rabbit.Config{URL: rabbitURL})
 	if err != nil {
 		log.Fatal("Failed to connect to RabbitMQ:", err)
 	}
-	defer conn.Close()
+	defer rabbitManager.Close()
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authURL)
+	var authHandler handlers.Auth
+	switch authTransport {
+	case "http":
+		authHandler = handlers.NewAuthHandler(authURL)
+	case "grpc":
+		grpcHandler, err := handlers.NewGRPCAuthHandler(authGRPCAddr)
+		if err != nil {
+			log.Fatal("Failed to create auth gRPC client:", err)
+		}
+		defer grpcHandler.Close()
+		authHandler = grpcHandler
+	default:
+		log.Fatalf("Unknown AUTH_TRANSPORT %q, want http or grpc", authTransport)
+	}
+	log.Printf("Reaching auth over %s", authTransport)
 
 	// Create consumer with handlers
 	consumer := event.NewConsumer(event.ConsumerConfig{
-		Connection: conn,
+		Manager:    rabbitManager,
 		Exchange:   exchange,
 		WorkerPool: 10,
+		Logger:     appLogger,
+		MaxRetries: maxRetries,
+		RetryDelay: retryDelay,
 		Handlers: event.HandlerMap{
 			"get_users": authHandler.GetUsers,
 			"get_user":  authHandler.GetUser,
 			"register":  authHandler.Register,
+			"login":     authHandler.Login,
+		},
+		// Writes are announced as domain events, which invalidate the
+		// broker's cached responses
+		Announcers: event.AnnouncerMap{
+			"register": handlers.UserCreated,
 		},
 	})
 
@@ -58,6 +143,9 @@ func main() {
 		"auth.#", // all auth events
 	}
 
+	// The listener has no API; /metrics is all it serves over HTTP
+	go metrics.Serve(metricsAddr)
+
 	log.Println("Listener service starting...")
 	if err := consumer.Listen(topics); err != nil {
 		log.Fatal("Listener failed:", err)
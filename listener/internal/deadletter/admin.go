@@ -0,0 +1,169 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Message is a dead-lettered event as Inspect shows it.
+type Message struct {
+	MessageID     string          `json:"message_id,omitempty"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	RoutingKey    string          `json:"routing_key"`
+	Reason        string          `json:"reason"`
+	Error         string          `json:"error,omitempty"`
+	Retries       int             `json:"retries"`
+	DeadAt        string          `json:"dead_lettered_at,omitempty"`
+	Body          json.RawMessage `json:"body"`
+}
+
+func newMessage(d amqp.Delivery) Message {
+	m := Message{
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		Retries:       Retries(d.Headers),
+		Body:          d.Body,
+	}
+	m.RoutingKey, _ = d.Headers[HeaderRoutingKey].(string)
+	m.Reason, _ = d.Headers[HeaderReason].(string)
+	m.Error, _ = d.Headers[HeaderError].(string)
+	m.DeadAt, _ = d.Headers[HeaderDeadAt].(string)
+	if !json.Valid(d.Body) {
+		// Malformed events are dead-lettered too; show them as a string
+		m.Body, _ = json.Marshal(string(d.Body))
+	}
+	return m
+}
+
+// Match selects the events Replay acts on; nil matches all of them.
+type Match func(Message) bool
+
+// ByID matches events by message or correlation ID.
+func ByID(id string) Match {
+	return func(m Message) bool {
+		return m.MessageID == id || m.CorrelationID == id
+	}
+}
+
+// Inspect returns up to limit events from queue, oldest first, and leaves
+// them in place. They are redelivered in the same order, marked as
+// redelivered.
+func Inspect(ch *amqp.Channel, queue string, limit int) ([]Message, error) {
+	var (
+		messages []Message
+		last     uint64
+	)
+	for len(messages) < limit {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return nil, fmt.Errorf("get from %s: %w", queue, err)
+		}
+		if !ok {
+			break
+		}
+		messages = append(messages, newMessage(d))
+		last = d.DeliveryTag
+	}
+
+	if last > 0 {
+		if err := ch.Nack(last, true, true); err != nil {
+			return nil, fmt.Errorf("requeue inspected events: %w", err)
+		}
+	}
+	return messages, nil
+}
+
+// Replay moves up to limit events matching match from queue back to the
+// work queue and returns how many it moved. Replayed events start over
+// with no retries; their original caller stopped waiting long ago, so they
+// carry no reply address or deadline and run as fire-and-forget events.
+// Events that do not match stay where they are.
+func Replay(ctx context.Context, ch *amqp.Channel, cfg Config, queue string, limit int, match Match) (int, error) {
+	if err := ch.Confirm(false); err != nil {
+		return 0, fmt.Errorf("enable publisher confirms: %w", err)
+	}
+
+	var (
+		replayed int
+		skipped  []uint64
+	)
+	defer func() {
+		for _, tag := range skipped {
+			ch.Nack(tag, false, true)
+		}
+	}()
+
+	for replayed < limit {
+		d, ok, err := ch.Get(queue, false)
+		if err != nil {
+			return replayed, fmt.Errorf("get from %s: %w", queue, err)
+		}
+		if !ok {
+			break
+		}
+		if match != nil && !match(newMessage(d)) {
+			skipped = append(skipped, d.DeliveryTag)
+			continue
+		}
+
+		if err := republish(ctx, ch, cfg.Queue, d); err != nil {
+			ch.Nack(d.DeliveryTag, false, true)
+			return replayed, err
+		}
+		if err := d.Ack(false); err != nil {
+			return replayed, fmt.Errorf("ack replayed event: %w", err)
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+func republish(ctx context.Context, ch *amqp.Channel, queue string, d amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		switch k {
+		case HeaderRetries, HeaderReason, HeaderError, HeaderDeadAt, HeaderRoutingKey, rabbit.HeaderDeadline, "x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason", "x-last-death-exchange", "x-last-death-queue", "x-last-death-reason":
+			continue
+		}
+		headers[k] = v
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: d.CorrelationId,
+		MessageId:     d.MessageId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		Body:          d.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("publish to %s: %w", queue, err)
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("confirm publish to %s: %w", queue, err)
+	}
+	if !acked {
+		return fmt.Errorf("publish to %s was nacked", queue)
+	}
+	return nil
+}
+
+// Purge deletes every event in queue and returns how many there were.
+func Purge(ch *amqp.Channel, queue string) (int, error) {
+	n, err := ch.QueuePurge(queue, false)
+	if err != nil {
+		return 0, fmt.Errorf("purge %s: %w", queue, err)
+	}
+	return n, nil
+}
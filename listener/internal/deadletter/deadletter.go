@@ -0,0 +1,191 @@
+// Package deadletter keeps failing events out of a hot redelivery loop.
+// An event whose handler failed waits in a retry queue, each tier holding
+// it longer than the one before, until its TTL sends it back to the work
+// queue. Retry queues are named after their delay, so changing the delay
+// declares new queues rather than redeclaring old ones with another TTL,
+// which RabbitMQ refuses; the old queues drain and can be deleted. Events
+// still failing after the last tier are parked, and events that can never
+// succeed, malformed or unhandled ones, go straight to the dead-letter
+// queue. Both keep their events until an operator inspects,
+// replays or purges them with cmd/dlq.
+package deadletter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Exchange routes failed events to the retry, dead-letter and parking
+// queues by the keys below.
+const Exchange = "listener.dlx"
+
+const (
+	KeyDead    = "dead"
+	KeyParking = "parking"
+)
+
+// Headers added to dead-lettered events. RabbitMQ records each TTL expiry
+// under x-death as well, but that list is per queue; HeaderRetries is the
+// one count across all tiers.
+const (
+	HeaderRetries    = "x-retries"
+	HeaderReason     = "x-dead-letter-reason"
+	HeaderError      = "x-dead-letter-error"
+	HeaderRoutingKey = "x-original-routing-key"
+	HeaderDeadAt     = "x-dead-lettered-at"
+)
+
+const (
+	defaultRetries   = 3
+	defaultBaseDelay = time.Second
+	// tierFactor is how much longer each retry tier holds events
+	tierFactor = 4
+)
+
+// Config names the work queue and shapes its retry tiers.
+type Config struct {
+	Queue     string        // the work queue events return to
+	Retries   int           // retry tiers before an event is parked (default 3)
+	BaseDelay time.Duration // delay of the first tier (default 1s)
+}
+
+func (c Config) withDefaults() Config {
+	if c.Retries <= 0 {
+		c.Retries = defaultRetries
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = defaultBaseDelay
+	}
+	return c
+}
+
+// RetryKey is the routing key of retry tier n, counted from 1. It names
+// the tier's delay, like "retry.4s".
+func (c Config) RetryKey(n int) string {
+	return "retry." + c.Delay(n).String()
+}
+
+// RetryQueue is the queue of retry tier n, like "listener_queue.retry.4s".
+func (c Config) RetryQueue(n int) string {
+	return c.Queue + "." + c.RetryKey(n)
+}
+
+// DeadQueue holds events that can never be handled.
+func (c Config) DeadQueue() string {
+	return c.Queue + ".dead"
+}
+
+// ParkingQueue holds events that failed on every retry.
+func (c Config) ParkingQueue() string {
+	return c.Queue + ".parking"
+}
+
+// Delay is how long retry tier n holds events: BaseDelay, four times
+// longer for every tier after the first, in whole milliseconds as queue
+// TTLs are.
+func (c Config) Delay(n int) time.Duration {
+	c = c.withDefaults()
+	d := max(c.BaseDelay.Truncate(time.Millisecond), time.Millisecond)
+	for i := 1; i < n; i++ {
+		d *= tierFactor
+	}
+	return d
+}
+
+// Topology declares the exchange and queues. Retry queues dead-letter
+// expired events to the work queue through the default exchange, so only
+// this listener's queue sees them again.
+func (c Config) Topology() rabbit.Topology {
+	c = c.withDefaults()
+	return func(ch rabbit.Declarer) error {
+		if err := ch.ExchangeDeclare(Exchange, "direct", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("declare dead-letter exchange: %w", err)
+		}
+
+		queues := map[string]amqp.Table{
+			c.DeadQueue():    nil,
+			c.ParkingQueue(): nil,
+		}
+		keys := map[string]string{
+			c.DeadQueue():    KeyDead,
+			c.ParkingQueue(): KeyParking,
+		}
+		for n := 1; n <= c.Retries; n++ {
+			queues[c.RetryQueue(n)] = amqp.Table{
+				"x-message-ttl":             c.Delay(n).Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": c.Queue,
+			}
+			keys[c.RetryQueue(n)] = c.RetryKey(n)
+		}
+
+		for name, args := range queues {
+			if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+				return fmt.Errorf("declare queue %s: %w", name, err)
+			}
+			if err := ch.QueueBind(name, keys[name], Exchange, false, nil); err != nil {
+				return fmt.Errorf("bind queue %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Retries returns how often an event has been retried so far.
+func Retries(headers amqp.Table) int {
+	switch n := headers[HeaderRetries].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	}
+	return 0
+}
+
+// NextKey picks where a delivery whose handler failed goes: the next retry
+// tier, or the parking queue once every tier was tried.
+func (c Config) NextKey(headers amqp.Table) string {
+	c = c.withDefaults()
+	if n := Retries(headers); n < c.Retries {
+		return c.RetryKey(n + 1)
+	}
+	return KeyParking
+}
+
+// Publishing copies msg for publishing under key, recording why it failed.
+// Events sent to a retry tier count one more retry.
+func Publishing(msg amqp.Delivery, key, reason string, cause error) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	retries := Retries(msg.Headers)
+	if key != KeyDead && key != KeyParking {
+		retries++
+	}
+	headers[HeaderRetries] = int32(retries)
+	headers[HeaderReason] = reason
+	headers[HeaderDeadAt] = time.Now().UTC().Format(time.RFC3339)
+	if cause != nil {
+		headers[HeaderError] = cause.Error()
+	}
+	if _, ok := headers[HeaderRoutingKey]; !ok {
+		headers[HeaderRoutingKey] = msg.RoutingKey
+	}
+
+	return amqp.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: msg.CorrelationId,
+		ReplyTo:       msg.ReplyTo,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Type:          msg.Type,
+		Body:          msg.Body,
+	}
+}
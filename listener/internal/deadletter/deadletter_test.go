@@ -0,0 +1,83 @@
+package deadletter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestFailingEventWalksTheTiersThenParks(t *testing.T) {
+	cfg := Config{Queue: "work", Retries: 3, BaseDelay: time.Second}
+
+	msg := amqp.Delivery{RoutingKey: "auth.register", MessageId: "m1", Body: []byte(`{}`)}
+	var keys []string
+	for range 5 {
+		key := cfg.NextKey(msg.Headers)
+		keys = append(keys, key)
+		if key == KeyParking {
+			break
+		}
+		// The retry queue's TTL sends the copy back to the work queue
+		pub := Publishing(msg, key, "handler_error", errors.New("auth down"))
+		msg = amqp.Delivery{RoutingKey: "work", Headers: pub.Headers, MessageId: pub.MessageId, Body: pub.Body}
+	}
+
+	want := []string{"retry.1s", "retry.4s", "retry.16s", KeyParking}
+	if len(keys) != len(want) {
+		t.Fatalf("keys %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("keys %v, want %v", keys, want)
+		}
+	}
+
+	parked := Publishing(msg, KeyParking, "handler_error", errors.New("auth down"))
+	if got := Retries(parked.Headers); got != 3 {
+		t.Errorf("parked after %d retries, want 3", got)
+	}
+	if got := parked.Headers[HeaderRoutingKey]; got != "auth.register" {
+		t.Errorf("original routing key %v, want auth.register", got)
+	}
+	if got := parked.Headers[HeaderError]; got != "auth down" {
+		t.Errorf("error header %v", got)
+	}
+}
+
+func TestTierDelaysGrowExponentially(t *testing.T) {
+	cfg := Config{Queue: "work", BaseDelay: 500 * time.Millisecond}
+	for n, want := range map[int]time.Duration{1: 500 * time.Millisecond, 2: 2 * time.Second, 3: 8 * time.Second} {
+		if got := cfg.Delay(n); got != want {
+			t.Errorf("tier %d delay %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestRetryQueuesAreNamedByDelay(t *testing.T) {
+	// A new delay must not redeclare an existing queue with another TTL
+	short := Config{Queue: "work", BaseDelay: time.Second}
+	long := Config{Queue: "work", BaseDelay: 1500 * time.Millisecond}
+	for n := 1; n <= defaultRetries; n++ {
+		if short.RetryQueue(n) == long.RetryQueue(n) {
+			t.Errorf("tier %d: both delays use %s", n, short.RetryQueue(n))
+		}
+	}
+
+	if got := long.RetryQueue(2); got != "work.retry.6s" {
+		t.Errorf("queue %q, want work.retry.6s", got)
+	}
+	if got := long.RetryKey(1); got != "retry.1.5s" {
+		t.Errorf("key %q, want retry.1.5s", got)
+	}
+
+	// Queue TTLs are whole milliseconds, and so are the names
+	odd := Config{Queue: "work", BaseDelay: 1500*time.Millisecond + 300*time.Microsecond}
+	if got := odd.RetryQueue(1); got != "work.retry.1.5s" {
+		t.Errorf("queue %q, want work.retry.1.5s", got)
+	}
+	if got := (Config{Queue: "work", BaseDelay: time.Microsecond}).Delay(1); got != time.Millisecond {
+		t.Errorf("sub-millisecond delay %v, want 1ms", got)
+	}
+}
@@ -1,34 +1,81 @@
 package event
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	"github.com/Flaviogonzalez/e-commerce/contracts/logger"
+	"github.com/Flaviogonzalez/e-commerce/contracts/rabbit"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
+	"github.com/Flaviogonzalez/e-commerce/listener/internal/deadletter"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// Handler is a function that processes an event and returns a response
-type Handler func(data json.RawMessage) ([]byte, error)
+const (
+	// QueueName is the work queue every listener replica consumes
+	QueueName = "listener_queue"
+
+	// resubscribeDelay spaces out attempts to consume again after the
+	// channel closed, so a persistent channel error can't spin
+	resubscribeDelay = time.Second
+
+	// deadLetterTimeout bounds moving a failed event to the dead-letter
+	// exchange
+	deadLetterTimeout = 10 * time.Second
+
+	// announceTimeout bounds publishing a domain event
+	announceTimeout = 10 * time.Second
+)
+
+// Handler is a function that processes an event and returns the reply
+// envelope sent back to the caller. ctx carries the event's span; pass it
+// to downstream calls to keep them in the publisher's trace.
+type Handler func(ctx context.Context, data json.RawMessage) (*contracts.Reply, error)
 
 // HandlerMap maps event names to their handlers
 type HandlerMap map[string]Handler
 
+// Announcer derives the domain event a command announces once its handler
+// succeeded, such as user.created after a registration: the topic to
+// publish on the exchange and the event's data. ok is false when there is
+// nothing to announce.
+type Announcer func(data json.RawMessage, reply *contracts.Reply) (topic string, fields map[string]any, ok bool)
+
+// AnnouncerMap maps event names to the announcer of their domain event
+type AnnouncerMap map[string]Announcer
+
 type Consumer struct {
-	conn       *amqp.Connection
-	exchange   string
-	handlers   HandlerMap
-	workerPool int
-	mu         sync.RWMutex
+	manager     *rabbit.Manager
+	exchange    string
+	handlers    HandlerMap
+	announcers  AnnouncerMap
+	workerPool  int
+	logger      *logger.Logger
+	deadLetters deadletter.Config
+	mu          sync.RWMutex
 }
 
 type ConsumerConfig struct {
-	Connection *amqp.Connection
+	Manager    *rabbit.Manager
 	Exchange   string
 	Handlers   HandlerMap
-	WorkerPool int // number of concurrent workers (default: 10)
+	Announcers AnnouncerMap   // optional; domain events published after handling
+	WorkerPool int            // number of concurrent workers (default: 10)
+	Logger     *logger.Logger // optional; records every handled event
+
+	// Failed events are retried after RetryDelay, then after delays four
+	// times longer each, MaxRetries times in all before they are parked
+	// (defaults: 3 retries, 1s)
+	MaxRetries int
+	RetryDelay time.Duration
 }
 
 func NewConsumer(cfg ConsumerConfig) *Consumer {
@@ -38,36 +85,92 @@ func NewConsumer(cfg ConsumerConfig) *Consumer {
 	}
 
 	return &Consumer{
-		conn:       cfg.Connection,
+		manager:    cfg.Manager,
 		exchange:   cfg.Exchange,
 		handlers:   cfg.Handlers,
+		announcers: cfg.Announcers,
 		workerPool: workers,
+		logger:     cfg.Logger,
+		deadLetters: deadletter.Config{
+			Queue:     QueueName,
+			Retries:   cfg.MaxRetries,
+			BaseDelay: cfg.RetryDelay,
+		},
 	}
 }
 
 func (c *Consumer) Setup() error {
-	ch, err := c.conn.Channel()
+	return c.manager.DeclareTopology(func(ch rabbit.Declarer) error {
+		return ch.ExchangeDeclare(
+			c.exchange,
+			"topic",
+			true,  // durable
+			false, // auto-deleted
+			false, // internal
+			false, // no-wait
+			nil,
+		)
+	})
+}
+
+// Listen starts consuming messages for the given topics. It keeps consuming
+// across connection losses and only returns once the manager is closed or
+// the topology can't be declared.
+func (c *Consumer) Listen(topics []string) error {
+	if err := c.manager.DeclareTopology(c.deadLetters.Topology()); err != nil {
+		return err
+	}
+
+	err := c.manager.DeclareTopology(func(ch rabbit.Declarer) error {
+		// Declare queue
+		q, err := ch.QueueDeclare(
+			QueueName, // named queue for persistence
+			true,      // durable
+			false,     // auto-delete
+			false,     // exclusive
+			false,     // no-wait
+			nil,
+		)
+		if err != nil {
+			return fmt.Errorf("declare queue: %w", err)
+		}
+
+		// Bind queue to topics
+		for _, topic := range topics {
+			if err := ch.QueueBind(q.Name, topic, c.exchange, false, nil); err != nil {
+				return fmt.Errorf("bind queue to %s: %w", topic, err)
+			}
+			log.Printf("Bound to topic: %s", topic)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("open channel: %w", err)
+		return err
 	}
-	defer ch.Close()
 
-	return ch.ExchangeDeclare(
-		c.exchange,
-		"topic",
-		true,  // durable
-		false, // auto-deleted
-		false, // internal
-		false, // no-wait
-		nil,
-	)
+	for {
+		err := c.consume()
+		if errors.Is(err, rabbit.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Consumer stopped: %v", err)
+		} else {
+			log.Println("Delivery channel closed, resubscribing...")
+		}
+
+		time.Sleep(resubscribeDelay)
+		if err := c.manager.WaitConnected(context.Background()); err != nil {
+			return nil
+		}
+	}
 }
 
-// Listen starts consuming messages for the given topics
-func (c *Consumer) Listen(topics []string) error {
-	ch, err := c.conn.Channel()
+// consume runs one subscription until its channel closes.
+func (c *Consumer) consume() error {
+	ch, err := c.manager.Channel()
 	if err != nil {
-		return fmt.Errorf("open channel: %w", err)
+		return err
 	}
 	defer ch.Close()
 
@@ -76,30 +179,9 @@ func (c *Consumer) Listen(topics []string) error {
 		return fmt.Errorf("set qos: %w", err)
 	}
 
-	// Declare queue
-	q, err := ch.QueueDeclare(
-		"listener_queue", // named queue for persistence
-		true,             // durable
-		false,            // auto-delete
-		false,            // exclusive
-		false,            // no-wait
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("declare queue: %w", err)
-	}
-
-	// Bind queue to topics
-	for _, topic := range topics {
-		if err := ch.QueueBind(q.Name, topic, c.exchange, false, nil); err != nil {
-			return fmt.Errorf("bind queue to %s: %w", topic, err)
-		}
-		log.Printf("Bound to topic: %s", topic)
-	}
-
 	// Start consuming
 	msgs, err := ch.Consume(
-		q.Name,
+		QueueName,
 		"",    // consumer tag
 		false, // auto-ack (manual for reliability)
 		false, // exclusive
@@ -127,7 +209,7 @@ func (c *Consumer) Listen(topics []string) error {
 				wg.Done()
 			}()
 
-			c.processMessage(ch, d)
+			c.processMessage(d)
 		}(msg)
 	}
 
@@ -135,11 +217,20 @@ func (c *Consumer) Listen(topics []string) error {
 	return nil
 }
 
-func (c *Consumer) processMessage(ch *amqp.Channel, msg amqp.Delivery) {
+func (c *Consumer) processMessage(msg amqp.Delivery) {
+	// Continue the publisher's trace, or start one for untraced events
+	parent, _ := rabbit.ExtractTrace(msg.Headers)
+	ctx, _ := trace.Start(context.Background(), parent)
+	if ip, ok := rabbit.ExtractClientIP(msg.Headers); ok {
+		ctx = clientip.NewContext(ctx, ip)
+	}
+	start := time.Now()
+
 	var payload contracts.EventPayload
 	if err := json.Unmarshal(msg.Body, &payload); err != nil {
 		log.Printf("Failed to unmarshal message: %v", err)
-		msg.Nack(false, false) // don't requeue malformed messages
+		c.logError(ctx, "Malformed event", err, msg.RoutingKey)
+		c.deadLetter(msg, "unknown", deadletter.KeyDead, "malformed", err) // retrying can't help
 		return
 	}
 
@@ -151,34 +242,72 @@ func (c *Consumer) processMessage(ch *amqp.Channel, msg amqp.Delivery) {
 
 	if !ok {
 		log.Printf("No handler for event: %s", payload.Name)
-		msg.Nack(false, false)
+		c.logError(ctx, "No handler for event", nil, payload.Name)
+		c.deadLetter(msg, "unknown", deadletter.KeyDead, "no_handler", nil)
 		return
 	}
 
+	// Work under the publisher's deadline, which handlers pass on to the
+	// services they call. Nobody waits for the reply of an expired RPC.
+	if deadline, ok := rabbit.ExtractDeadline(msg.Headers); ok {
+		if time.Now().After(deadline) {
+			log.Printf("Dropping expired event: %s, correlationId: %s", payload.Name, msg.CorrelationId)
+			nack(msg, payload.Name, "expired", false)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
 	// Execute handler
-	response, err := handler(payload.Data)
+	handlerStart := time.Now()
+	reply, err := handler(ctx, payload.Data)
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	} else if reply != nil && reply.Error != nil {
+		outcome = "error_reply"
+	}
+	handlerDuration.WithLabelValues(payload.Name, outcome).Observe(time.Since(handlerStart).Seconds())
 	if err != nil {
 		log.Printf("Handler error for %s: %v", payload.Name, err)
-		msg.Nack(false, true) // requeue on handler error
+		c.logError(ctx, "Handler failed", err, payload.Name)
+		c.deadLetter(msg, payload.Name, c.deadLetters.NextKey(msg.Headers), "handler_error", err)
 		return
 	}
 
-	// Send response if ReplyTo is set
+	response, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Failed to marshal reply for %s: %v", payload.Name, err)
+		c.deadLetter(msg, payload.Name, deadletter.KeyDead, "bad_reply", err)
+		return
+	}
+
+	// Announce the write before replying, so whoever acts on the reply
+	// finds caches already invalidated
+	c.announce(ctx, payload, reply)
+
+	// Send response if ReplyTo is set. Workers publish concurrently, so
+	// replies go out on pooled channels rather than the consuming one.
 	if msg.ReplyTo != "" {
-		err = ch.Publish(
+		err = c.manager.Publish(
+			context.Background(),
 			"",          // default exchange
 			msg.ReplyTo, // routing key = reply queue
-			false,
-			false,
 			amqp.Publishing{
 				ContentType:   "application/json",
+				Type:          contracts.ReplyMessageType,
 				CorrelationId: msg.CorrelationId,
+				Headers:       rabbit.InjectTrace(ctx, nil),
 				Body:          response,
 			},
 		)
 		if err != nil {
+			// The handler already ran and may not be idempotent, so the
+			// event is parked for an operator rather than retried
 			log.Printf("Failed to publish response: %v", err)
-			msg.Nack(false, true)
+			c.deadLetter(msg, payload.Name, deadletter.KeyParking, "reply_failed", err)
 			return
 		}
 
@@ -186,6 +315,97 @@ func (c *Consumer) processMessage(ch *amqp.Channel, msg amqp.Delivery) {
 	}
 
 	msg.Ack(false)
+
+	if c.logger != nil {
+		c.logger.Info("Event handled",
+			logger.WithContext(ctx),
+			logger.WithDuration(time.Since(start)),
+			logger.WithField("event", payload.Name),
+			logger.WithField("status", reply.Status),
+			logger.WithField("correlation_id", msg.CorrelationId),
+		)
+	}
+}
+
+// announce publishes the domain event of a successfully handled command.
+// The command is not retried when that fails, since its write is done;
+// caches then fall back to their TTL.
+func (c *Consumer) announce(ctx context.Context, payload contracts.EventPayload, reply *contracts.Reply) {
+	announcer, ok := c.announcers[payload.Name]
+	if !ok || reply == nil || reply.Error != nil || reply.Status < 200 || reply.Status > 299 {
+		return
+	}
+	topic, fields, ok := announcer(payload.Data, reply)
+	if !ok {
+		return
+	}
+
+	if err := c.publishEvent(ctx, topic, fields); err != nil {
+		log.Printf("Failed to announce %s after %s: %v", topic, payload.Name, err)
+		c.logError(ctx, "Domain event not published", err, payload.Name)
+	}
+}
+
+// publishEvent publishes a domain event on the exchange and waits for the
+// broker to confirm it.
+func (c *Consumer) publishEvent(ctx context.Context, topic string, fields map[string]any) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", topic, err)
+	}
+	body, err := json.Marshal(contracts.EventPayload{Name: topic, Data: data})
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", topic, err)
+	}
+
+	pubCtx, cancel := context.WithTimeout(context.Background(), announceTimeout)
+	defer cancel()
+	return c.manager.PublishConfirmed(pubCtx, c.exchange, topic, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now().UTC(),
+		Headers:      rabbit.InjectTrace(ctx, nil),
+		Body:         body,
+	})
+}
+
+// deadLetter moves msg to the dead-letter exchange under key, a retry
+// tier, the parking or the dead-letter queue, and acks it. Should that
+// fail, msg is requeued instead so it is not lost.
+func (c *Consumer) deadLetter(msg amqp.Delivery, event, key, reason string, cause error) {
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	err := c.manager.PublishConfirmed(ctx, deadletter.Exchange, key, deadletter.Publishing(msg, key, reason, cause))
+	if err != nil {
+		log.Printf("Failed to dead-letter %s to %s: %v", event, key, err)
+		nack(msg, event, reason, true)
+		return
+	}
+
+	msg.Ack(false)
+	deadLetters.WithLabelValues(event, reason, key).Inc()
+	if key == deadletter.KeyDead || key == deadletter.KeyParking {
+		log.Printf("Event %s (%s) moved to the %s queue", event, reason, key)
+	}
+}
+
+// nack rejects msg and counts it. Malformed and unhandled events count as
+// "unknown" to keep arbitrary event names out of the metric labels.
+func nack(msg amqp.Delivery, event, reason string, requeue bool) {
+	nacks.WithLabelValues(event, reason, strconv.FormatBool(requeue)).Inc()
+	msg.Nack(false, requeue)
+}
+
+func (c *Consumer) logError(ctx context.Context, msg string, err error, event string) {
+	if c.logger == nil {
+		return
+	}
+	c.logger.Error(msg,
+		logger.WithContext(ctx),
+		logger.WithError(err),
+		logger.WithField("event", event),
+	)
 }
 
 // RegisterHandler adds or updates a handler at runtime
@@ -0,0 +1,24 @@
+package event
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "listener_handler_duration_seconds",
+		Help:    "Time spent in event handlers, by event and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"event", "outcome"})
+
+	nacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "listener_nacks_total",
+		Help: "Messages nacked, by event, reason and whether they were requeued.",
+	}, []string{"event", "reason", "requeue"})
+
+	deadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "listener_dead_letters_total",
+		Help: "Failed events moved to the dead-letter exchange, by event, reason and destination (retry.N, parking or dead).",
+	}, []string{"event", "reason", "destination"})
+)
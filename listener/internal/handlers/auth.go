@@ -2,13 +2,31 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/clientip"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
 )
 
+// forwardedHeaders are the downstream response headers relayed to the broker.
+var forwardedHeaders = []string{"Content-Type", "Retry-After", "Location", "ETag", "Cache-Control"}
+
+// Auth handles the auth events. AuthHandler reaches the service over HTTP
+// and GRPCAuthHandler over gRPC; both answer with the same replies.
+type Auth interface {
+	GetUsers(ctx context.Context, data json.RawMessage) (*contracts.Reply, error)
+	GetUser(ctx context.Context, data json.RawMessage) (*contracts.Reply, error)
+	Register(ctx context.Context, data json.RawMessage) (*contracts.Reply, error)
+	Login(ctx context.Context, data json.RawMessage) (*contracts.Reply, error)
+}
+
 type AuthHandler struct {
 	client  *http.Client
 	baseURL string
@@ -23,11 +41,40 @@ func NewAuthHandler(baseURL string) *AuthHandler {
 	}
 }
 
-func (h *AuthHandler) GetUsers(data json.RawMessage) ([]byte, error) {
-	return h.forward("GET", "/users", nil)
+func (h *AuthHandler) GetUsers(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
+	var page usersPage
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("unmarshal request: %w", err)
+		}
+	}
+
+	path := "/users"
+	if q := page.query(); q != "" {
+		path += "?" + q
+	}
+	return h.forward(ctx, "GET", path, nil)
+}
+
+// usersPage is the get_users event data: the limit and offset query
+// params, passed on as strings for the service to check.
+type usersPage struct {
+	Limit  string `json:"limit,omitempty"`
+	Offset string `json:"offset,omitempty"`
 }
 
-func (h *AuthHandler) GetUser(data json.RawMessage) ([]byte, error) {
+func (p usersPage) query() string {
+	q := make(url.Values)
+	if p.Limit != "" {
+		q.Set("limit", p.Limit)
+	}
+	if p.Offset != "" {
+		q.Set("offset", p.Offset)
+	}
+	return q.Encode()
+}
+
+func (h *AuthHandler) GetUser(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
 	var req struct {
 		ID string `json:"id"`
 	}
@@ -35,24 +82,34 @@ func (h *AuthHandler) GetUser(data json.RawMessage) ([]byte, error) {
 		return nil, fmt.Errorf("unmarshal request: %w", err)
 	}
 
-	return h.forward("GET", "/users/"+req.ID, nil)
+	return h.forward(ctx, "GET", "/users/"+req.ID, nil)
+}
+
+func (h *AuthHandler) Register(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
+	return h.forward(ctx, "POST", "/register", data)
 }
 
-func (h *AuthHandler) Register(data json.RawMessage) ([]byte, error) {
-	return h.forward("POST", "/register", data)
+func (h *AuthHandler) Login(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
+	return h.forward(ctx, "POST", "/login", data)
 }
 
-func (h *AuthHandler) forward(method, path string, body json.RawMessage) ([]byte, error) {
+// forward calls the auth service, passing the event's trace on so the
+// service's request log joins it, along with the client's address.
+func (h *AuthHandler) forward(ctx context.Context, method, path string, body json.RawMessage) (*contracts.Reply, error) {
 	var reqBody io.Reader
 	if body != nil {
 		reqBody = bytes.NewReader(body)
 	}
 
-	req, err := http.NewRequest(method, h.baseURL+path, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+path, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	trace.Inject(ctx, req.Header)
+	if ip, ok := clientip.FromContext(ctx); ok {
+		req.Header.Set(clientip.Header, ip)
+	}
 
 	resp, err := h.client.Do(req)
 	if err != nil {
@@ -65,5 +122,47 @@ func (h *AuthHandler) forward(method, path string, body json.RawMessage) ([]byte
 		return nil, fmt.Errorf("read response: %w", err)
 	}
 
-	return respBody, nil
+	return newReply(resp, respBody), nil
+}
+
+// newReply wraps a downstream response in the reply envelope. Error
+// responses are decoded into a typed contracts.Error; services that answer
+// with an unstructured body get one derived from the status code.
+func newReply(resp *http.Response, body []byte) *contracts.Reply {
+	reply := &contracts.Reply{
+		Status:  resp.StatusCode,
+		Headers: make(map[string]string),
+	}
+
+	for _, name := range forwardedHeaders {
+		if v := resp.Header.Get(name); v != "" {
+			reply.Headers[name] = v
+		}
+	}
+
+	if resp.StatusCode < 400 {
+		if json.Valid(body) {
+			reply.Body = body
+		} else if len(body) > 0 {
+			reply.Body, _ = json.Marshal(string(body))
+		}
+		return reply
+	}
+
+	var apiErr contracts.Error
+	if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Code == "" {
+		reply.Error = contracts.ErrorFromStatus(resp.StatusCode, apiErr.Message)
+		return reply
+	}
+	apiErr.Status = resp.StatusCode
+	reply.Error = &apiErr
+
+	return reply
+}
+
+// UserCreated announces a successful registration as user.created, so the
+// broker drops cached user lists. auth answers registrations without the
+// new user's id, so the event carries no data.
+func UserCreated(data json.RawMessage, reply *contracts.Reply) (string, map[string]any, bool) {
+	return "user.created", map[string]any{}, true
 }
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/grpcutil"
+	authv1 "github.com/Flaviogonzalez/e-commerce/contracts/proto/auth/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcTimeout bounds calls for events that came without a deadline, as the
+// HTTP client's timeout does for AuthHandler.
+const grpcTimeout = 10 * time.Second
+
+// GRPCAuthHandler handles the auth events through the service's gRPC API.
+// Calls carry the event's deadline, its trace and "listener" as the caller.
+type GRPCAuthHandler struct {
+	conn   *grpc.ClientConn
+	client authv1.AuthServiceClient
+}
+
+// NewGRPCAuthHandler prepares a client for the auth service at addr
+// (host:port). The connection is made lazily and re-established on its
+// own, so an auth service that is not up yet is no error.
+func NewGRPCAuthHandler(addr string) (*GRPCAuthHandler, error) {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(grpcutil.UnaryClientInterceptor("listener")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("grpc client for %s: %w", addr, err)
+	}
+	return &GRPCAuthHandler{conn: conn, client: authv1.NewAuthServiceClient(conn)}, nil
+}
+
+// Close releases the connection.
+func (h *GRPCAuthHandler) Close() error {
+	return h.conn.Close()
+}
+
+func (h *GRPCAuthHandler) GetUsers(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
+	var page usersPage
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &page); err != nil {
+			return invalidPayload(), nil
+		}
+	}
+	limit, apiErr := pageParam("limit", page.Limit)
+	if apiErr != nil {
+		return &contracts.Reply{Status: apiErr.Status, Error: apiErr}, nil
+	}
+	offset, apiErr := pageParam("offset", page.Offset)
+	if apiErr != nil {
+		return &contracts.Reply{Status: apiErr.Status, Error: apiErr}, nil
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	resp, err := h.client.ListUsers(ctx, &authv1.ListUsersRequest{Limit: limit, Offset: offset})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	users := make([]contracts.AuthUser, len(resp.Users))
+	for i, u := range resp.Users {
+		users[i] = authUser(u)
+	}
+	return jsonReply(users)
+}
+
+func (h *GRPCAuthHandler) GetUser(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return invalidPayload(), nil
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	user, err := h.client.GetUser(ctx, &authv1.GetUserRequest{Id: req.ID})
+	if err != nil {
+		return errorReply(err)
+	}
+	return jsonReply(authUser(user))
+}
+
+func (h *GRPCAuthHandler) Register(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
+	var req contracts.AuthRegisterRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return invalidPayload(), nil
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	resp, err := h.client.Register(ctx, &authv1.RegisterRequest{
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: req.Password,
+		Policy:   req.Policy,
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var out contracts.AuthRegisterResponse
+	out.Message = resp.Message
+	return jsonReply(out)
+}
+
+func (h *GRPCAuthHandler) Login(ctx context.Context, data json.RawMessage) (*contracts.Reply, error) {
+	var req contracts.AuthLoginRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return invalidPayload(), nil
+	}
+
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+
+	resp, err := h.client.Login(ctx, &authv1.LoginRequest{
+		Email:     req.Email,
+		Password:  req.Password,
+		Challenge: req.Challenge,
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var out contracts.AuthLoginResponse
+	out.Message = resp.Message
+	out.UserID = resp.UserId
+	return jsonReply(out)
+}
+
+// pageParam parses a paging parameter as the service's HTTP API does:
+// absent means 0, anything but a non-negative integer is rejected.
+func pageParam(name, v string) (int32, *contracts.Error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil || n < 0 {
+		return 0, contracts.NewError(http.StatusBadRequest, contracts.ErrCodeValidation, "Invalid paging parameter").
+			WithField(name, name+" must be a non-negative integer")
+	}
+	return int32(n), nil
+}
+
+// invalidPayload answers event data that does not decode, as the HTTP API
+// answers such a body.
+func invalidPayload() *contracts.Reply {
+	return &contracts.Reply{
+		Status: http.StatusBadRequest,
+		Error:  contracts.NewError(http.StatusBadRequest, contracts.ErrCodeInvalidPayload, "Invalid request payload"),
+	}
+}
+
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, grpcTimeout)
+}
+
+func jsonReply(v any) (*contracts.Reply, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encode reply: %w", err)
+	}
+	return &contracts.Reply{Status: http.StatusOK, Body: body}, nil
+}
+
+// errorReply turns an error the service answered with into an error
+// reply. Anything else, an unreachable service or an expired deadline,
+// is returned as is, like a failed HTTP request.
+func errorReply(err error) (*contracts.Reply, error) {
+	apiErr, ok := grpcutil.Error(err)
+	if !ok {
+		return nil, fmt.Errorf("call auth: %w", err)
+	}
+
+	reply := &contracts.Reply{Status: apiErr.Status, Error: apiErr}
+	if d := grpcutil.RetryAfter(err); d > 0 {
+		reply.Headers = map[string]string{"Retry-After": strconv.Itoa(int((d + time.Second - 1) / time.Second))}
+	}
+	return reply, nil
+}
+
+func authUser(u *authv1.User) contracts.AuthUser {
+	return contracts.AuthUser{
+		ID:            u.Id,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		Phone:         u.Phone,
+		PhoneVerified: u.PhoneVerified,
+		AvatarURL:     u.AvatarUrl,
+		Status:        u.Status,
+		Role:          u.Role,
+		LastLoginAt:   optionalTime(u.LastLoginAt),
+		CreatedAt:     u.CreatedAt.AsTime(),
+		UpdatedAt:     u.UpdatedAt.AsTime(),
+	}
+}
+
+func optionalTime(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
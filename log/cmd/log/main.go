@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/Flaviogonzalez/e-commerce/contracts/metrics"
 	"github.com/Flaviogonzalez/e-commerce/log/internal/consumer"
 	"github.com/Flaviogonzalez/e-commerce/log/internal/storage"
 )
@@ -32,6 +33,11 @@ func main() {
 		mongoDB = "logs"
 	}
 
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":8080"
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -65,6 +71,8 @@ func main() {
 		cancel()
 	}()
 
+	go metrics.Serve(metricsAddr)
+
 	log.Println("Log service starting, consuming from topic:", kafkaTopic)
 	if err := c.Start(ctx); err != nil {
 		log.Fatal("Consumer error:", err)
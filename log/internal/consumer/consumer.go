@@ -4,14 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/Flaviogonzalez/e-commerce/contracts/trace"
 	"github.com/Flaviogonzalez/e-commerce/log/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/segmentio/kafka-go"
 )
 
+var consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "log_kafka_consumer_lag",
+	Help: "Messages waiting behind the last one read, by partition.",
+}, []string{"partition"})
+
 type Storage interface {
 	InsertMany(ctx context.Context, entries []contracts.LogEntry) error
 }
@@ -94,12 +103,17 @@ func (c *Consumer) Start(ctx context.Context) error {
 			log.Printf("Read error: %v", err)
 			continue
 		}
+		// Messages after this one the partition already holds
+		consumerLag.WithLabelValues(strconv.Itoa(msg.Partition)).Set(float64(msg.HighWaterMark - msg.Offset - 1))
 
 		var entry contracts.LogEntry
 		if err := json.Unmarshal(msg.Value, &entry); err != nil {
 			log.Printf("Unmarshal error: %v", err)
 			continue
 		}
+		if entry.TraceID == "" {
+			traceFromHeaders(&entry, msg.Headers)
+		}
 
 		// Check for alerts
 		c.alerter.Check(entry)
@@ -114,6 +128,21 @@ func (c *Consumer) Start(ctx context.Context) error {
 	}
 }
 
+// traceFromHeaders fills in the trace of entries whose producer sent it as
+// a traceparent header only.
+func traceFromHeaders(entry *contracts.LogEntry, headers []kafka.Header) {
+	for _, h := range headers {
+		if h.Key != trace.HeaderTraceparent {
+			continue
+		}
+		if span, err := trace.Parse(string(h.Value)); err == nil {
+			entry.TraceID = span.TraceID
+			entry.SpanID = span.SpanID
+		}
+		return
+	}
+}
+
 func (c *Consumer) batchWriter(ctx context.Context, batch <-chan contracts.LogEntry) {
 	entries := make([]contracts.LogEntry, 0, c.batchSize)
 	ticker := time.NewTicker(c.batchDelay)
@@ -6,11 +6,19 @@ import (
 	"time"
 
 	"github.com/Flaviogonzalez/e-commerce/contracts"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var insertDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "log_mongo_insert_duration_seconds",
+	Help:    "Duration of batched log entry inserts into MongoDB, by result.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"result"})
+
 type MongoDB struct {
 	client     *mongo.Client
 	db         *mongo.Database
@@ -84,7 +92,14 @@ func (m *MongoDB) InsertMany(ctx context.Context, entries []contracts.LogEntry)
 		docs[i] = e
 	}
 
+	start := time.Now()
 	_, err := m.collection.InsertMany(ctx, docs)
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	insertDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
 	return err
 }
 
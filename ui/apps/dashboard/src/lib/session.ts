@@ -0,0 +1,112 @@
+import { SignJWT, jwtVerify } from "jose";
+import {
+  getCookie,
+  setCookie,
+  deleteCookie,
+} from "@tanstack/react-start/server";
+
+// Server-only: the dashboard's own sessions. Operators sign in with their
+// shop account, which must have the admin role; the session cookie then
+// names them, so the broker can tell their connections apart.
+
+const SESSION_COOKIE = "dashboard_session";
+const SESSION_TTL_SECONDS = 8 * 60 * 60;
+
+export class NotSignedInError extends Error {
+  constructor() {
+    super("Not signed in");
+    this.name = "NotSignedInError";
+  }
+}
+
+function sessionKey(): Uint8Array {
+  const secret = process.env.DASHBOARD_SESSION_SECRET;
+  if (!secret) {
+    throw new Error("DASHBOARD_SESSION_SECRET is not set");
+  }
+  return new TextEncoder().encode(secret);
+}
+
+export function brokerURL(): string {
+  return process.env.BROKER_URL || "http://localhost:8080";
+}
+
+// brokerFetch calls the broker as the dashboard service. The token never
+// leaves the dashboard server.
+export function brokerFetch(path: string, init: RequestInit = {}) {
+  return fetch(`${brokerURL()}${path}`, {
+    ...init,
+    headers: {
+      "Content-Type": "application/json",
+      ...init.headers,
+      Authorization: `Bearer ${process.env.BROKER_API_TOKEN ?? ""}`,
+    },
+  });
+}
+
+// signIn checks the credentials with the broker and starts a session for
+// admins. Anyone else gets the same answer as for a wrong password.
+export async function signIn(email: string, password: string): Promise<string> {
+  const login = await brokerFetch("/api/v1/login", {
+    method: "POST",
+    body: JSON.stringify({ email, password }),
+  });
+  if (!login.ok) {
+    throw new Error("Invalid email or password");
+  }
+  const { user_id: userId } = (await login.json()) as { user_id?: string };
+  if (!userId) {
+    throw new Error("Invalid email or password");
+  }
+
+  const user = await brokerFetch(
+    `/api/v1/users/${encodeURIComponent(userId)}`,
+    { headers: { "Cache-Control": "no-cache" } },
+  );
+  if (!user.ok || ((await user.json()) as { role?: string }).role !== "admin") {
+    throw new Error("Invalid email or password");
+  }
+
+  const token = await new SignJWT({})
+    .setProtectedHeader({ alg: "HS256" })
+    .setSubject(userId)
+    .setIssuedAt()
+    .setExpirationTime(`${SESSION_TTL_SECONDS}s`)
+    .sign(sessionKey());
+  setCookie(SESSION_COOKIE, token, {
+    httpOnly: true,
+    secure: process.env.NODE_ENV === "production",
+    sameSite: "strict",
+    path: "/",
+    maxAge: SESSION_TTL_SECONDS,
+  });
+  return userId;
+}
+
+export function signOut() {
+  deleteCookie(SESSION_COOKIE, { path: "/" });
+}
+
+// currentUser returns the signed-in user's ID, or null.
+export async function currentUser(): Promise<string | null> {
+  const token = getCookie(SESSION_COOKIE);
+  if (!token) {
+    return null;
+  }
+  try {
+    const { payload } = await jwtVerify(token, sessionKey(), {
+      algorithms: ["HS256"],
+    });
+    return payload.sub ?? null;
+  } catch {
+    return null;
+  }
+}
+
+export async function requireUser(): Promise<string> {
+  const userId = await currentUser();
+  if (!userId) {
+    throw new NotSignedInError();
+  }
+  return userId;
+}
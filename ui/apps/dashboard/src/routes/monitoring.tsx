@@ -1,5 +1,7 @@
 import { createFileRoute } from "@tanstack/react-router";
+import { createServerFn } from "@tanstack/react-start";
 import { useState, useEffect, useRef, useCallback } from "react";
+import type { FormEvent } from "react";
 import {
   Card,
   CardContent,
@@ -11,6 +13,14 @@ import { Badge } from "@repo/ui/badge";
 import { Button } from "@repo/ui/button";
 import { Separator } from "@repo/ui/separator";
 import { ScrollArea } from "@repo/ui/scroll-area";
+import { Input } from "@repo/ui/input";
+import { Label } from "@repo/ui/label";
+import {
+  brokerFetch,
+  currentUser,
+  requireUser,
+  signIn,
+} from "../lib/session";
 
 export const Route = createFileRoute("/monitoring")({
   component: MonitoringPage,
@@ -22,6 +32,101 @@ interface WebSocketMessage {
   data: Record<string, unknown>;
 }
 
+const WS_URL = import.meta.env.VITE_WS_URL || "ws://localhost:8080/api/v1/ws";
+
+interface WSTicket {
+  ticket: string;
+  expires_at: string;
+}
+
+// Browsers cannot set headers on the handshake, so the broker admits it
+// with a short-lived, single-use ticket in the URL. The dashboard server
+// asks for one with its own token, which never reaches the browser, and
+// only on behalf of a signed-in admin.
+const issueWSTicket = createServerFn({ method: "POST" }).handler(
+  async (): Promise<WSTicket> => {
+    const userId = await requireUser();
+    const res = await brokerFetch("/api/v1/ws/tickets", {
+      method: "POST",
+      body: JSON.stringify({ subject: userId }),
+    });
+    if (!res.ok) {
+      throw new Error(`Broker refused a WebSocket ticket: ${res.status}`);
+    }
+    return res.json();
+  },
+);
+
+const getSessionUser = createServerFn({ method: "GET" }).handler(() =>
+  currentUser(),
+);
+
+const signInFn = createServerFn({ method: "POST" })
+  .validator((data: { email: string; password: string }) => data)
+  .handler(({ data }) => signIn(data.email, data.password));
+
+async function wsUrlWithTicket(): Promise<string> {
+  const { ticket } = await issueWSTicket();
+  const url = new URL(WS_URL, window.location.href);
+  url.protocol = url.protocol.replace("http", "ws");
+  url.searchParams.set("ticket", ticket);
+  return url.toString();
+}
+
+function SignIn({ onSignedIn }: { onSignedIn: () => void }) {
+  const [error, setError] = useState<string | null>(null);
+  const [pending, setPending] = useState(false);
+
+  const submit = async (e: FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    const form = new FormData(e.currentTarget);
+    setPending(true);
+    setError(null);
+    try {
+      await signInFn({
+        data: {
+          email: String(form.get("email") ?? ""),
+          password: String(form.get("password") ?? ""),
+        },
+      });
+      onSignedIn();
+    } catch {
+      setError("Invalid email or password");
+    } finally {
+      setPending(false);
+    }
+  };
+
+  return (
+    <main className="container mx-auto px-4 py-8">
+      <Card className="max-w-sm mx-auto">
+        <CardHeader>
+          <CardTitle>Sign in</CardTitle>
+          <CardDescription>
+            The event stream is for administrators only
+          </CardDescription>
+        </CardHeader>
+        <CardContent>
+          <form onSubmit={submit} className="space-y-4">
+            <div className="space-y-2">
+              <Label htmlFor="email">Email</Label>
+              <Input id="email" name="email" type="email" autoComplete="username" required />
+            </div>
+            <div className="space-y-2">
+              <Label htmlFor="password">Password</Label>
+              <Input id="password" name="password" type="password" autoComplete="current-password" required />
+            </div>
+            {error && <p className="text-sm text-destructive">{error}</p>}
+            <Button type="submit" className="w-full" disabled={pending}>
+              Sign in
+            </Button>
+          </form>
+        </CardContent>
+      </Card>
+    </main>
+  );
+}
+
 interface ConnectionStats {
   connected: boolean;
   reconnectAttempts: number;
@@ -37,14 +142,18 @@ function MonitoringPage() {
     lastConnected: null,
     messagesReceived: 0,
   });
+  const [signedIn, setSignedIn] = useState<boolean | null>(null);
   const wsRef = useRef<WebSocket | null>(null);
   const reconnectTimeoutRef = useRef<ReturnType<typeof setTimeout>>();
 
-  const connect = useCallback(() => {
-    const wsUrl = import.meta.env.VITE_WS_URL || "ws://localhost:8080/ws";
+  useEffect(() => {
+    getSessionUser().then((userId) => setSignedIn(userId !== null));
+  }, []);
 
+  const connect = useCallback(async () => {
     try {
-      const ws = new WebSocket(wsUrl);
+      // Tickets expire within seconds, so each attempt needs a fresh one
+      const ws = new WebSocket(await wsUrlWithTicket());
 
       ws.onopen = () => {
         setStats((prev) => ({
@@ -86,10 +195,17 @@ function MonitoringPage() {
       wsRef.current = ws;
     } catch {
       console.error("Failed to connect to WebSocket");
+      // The session may have expired since the page loaded
+      if ((await getSessionUser()) === null) {
+        setSignedIn(false);
+      }
     }
   }, [stats.reconnectAttempts]);
 
   useEffect(() => {
+    if (!signedIn) {
+      return;
+    }
     connect();
 
     return () => {
@@ -100,7 +216,7 @@ function MonitoringPage() {
         wsRef.current.close();
       }
     };
-  }, [connect]);
+  }, [connect, signedIn]);
 
   const typeColors: Record<WebSocketMessage["type"], string> = {
     order: "bg-blue-500",
@@ -110,6 +226,10 @@ function MonitoringPage() {
     error: "bg-red-500",
   };
 
+  if (signedIn === false) {
+    return <SignIn onSignedIn={() => setSignedIn(true)} />;
+  }
+
   return (
     <main className="container mx-auto px-4 py-8">
       <div className="max-w-6xl mx-auto">
@@ -288,7 +408,7 @@ function MonitoringPage() {
               <div className="flex justify-between text-sm">
                 <span className="text-muted-foreground">URL</span>
                 <code className="text-xs">
-                  {import.meta.env.VITE_WS_URL || "ws://localhost:8080/ws"}
+                  {WS_URL}
                 </code>
               </div>
               <div className="flex justify-between text-sm">